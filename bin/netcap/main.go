@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"GameServer/common/network"
+)
+
+// netcap 控制协议抓包查看与回放
+//
+//	netcap dump [-presentation raw|json|pb] [-route] [-conn id] capture.gscp
+//	netcap replay -addr host:port [-conn id] [-direction in|out] [-speed 1] capture.gscp
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "dump":
+		err = dump(os.Args[2:])
+	case "replay":
+		err = replay(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "netcap:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  netcap dump [-presentation raw|json|pb] [-route] [-conn id] <capture file>")
+	fmt.Fprintln(os.Stderr, "  netcap replay -addr host:port [-conn id] [-direction in|out] [-speed n] [-wait d] [-codec varint|fixed|crc32] <capture file>")
+}
+
+// openCapture 打开抓包文件
+func openCapture(fs *flag.FlagSet) (*network.CaptureReader, io.Closer, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, nil, errors.New("capture file required")
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := network.NewCaptureReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+
+	return reader, file, nil
+}
+
+// dump 按时间顺序打印抓包记录 PUBLISH 负载经表示层解码
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	presentationName := fs.String("presentation", "raw", "payload presentation: raw, json or pb")
+	routed := fs.Bool("route", false, "payload starts with a 4-byte route header")
+	connectionID := fs.String("conn", "", "only dump the given connection id")
+	_ = fs.Parse(args)
+
+	decoder, err := newPayloadDecoder(*presentationName)
+	if err != nil {
+		return err
+	}
+	reader, closer, err := openCapture(fs)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	fmt.Printf("capture start:%s, byteOrder:%s\n", reader.Start().Format(time.RFC3339Nano), reader.ByteOrder().String())
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if *connectionID != "" && record.Connection.ConnectionID != *connectionID {
+			continue
+		}
+		fmt.Println(record.String())
+
+		publish, ok := record.Packet.(*network.PublishPacket)
+		if !ok || len(publish.Payload) == 0 {
+			continue
+		}
+		payload := publish.Payload
+		if *routed {
+			message, err := network.DecodeRouteMessage(payload, reader.ByteOrder())
+			if err != nil {
+				fmt.Printf("\troute: %v\n", err)
+				continue
+			}
+			fmt.Printf("\troute:%d\n", message.Route)
+			payload = message.Body
+		}
+		fmt.Printf("\tpayload: %s\n", decoder(payload))
+	}
+}
+
+// newPayloadDecoder 根据表示层名称创建负载解码
+func newPayloadDecoder(name string) (func(payload []byte) string, error) {
+	switch name {
+	case "raw":
+		return hex.EncodeToString, nil
+	case "json":
+		presentation := network.NewJsonPresentation()
+		return func(payload []byte) string {
+			var value any
+			if err := presentation.Decode(payload, &value); err != nil {
+				return fmt.Sprintf("<json decode failed: %v> %s", err, hex.EncodeToString(payload))
+			}
+			data, _ := json.Marshal(value)
+			return string(data)
+		}, nil
+	case "pb":
+		// 没有消息定义 解码为空消息后按字段号输出未知字段
+		presentation := network.NewPBPresentation()
+		return func(payload []byte) string {
+			message := &emptypb.Empty{}
+			if err := presentation.Decode(payload, message); err != nil {
+				return fmt.Sprintf("<pb decode failed: %v> %s", err, hex.EncodeToString(payload))
+			}
+			return formatProtoFields(message.ProtoReflect().GetUnknown())
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown presentation %q", name)
+}
+
+// formatProtoFields 按线路格式输出protobuf字段
+func formatProtoFields(data []byte) string {
+	fields := make([]string, 0)
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Sprintf("{%s <malformed: %v>}", strings.Join(fields, " "), protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var value string
+		switch wireType {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(data)
+			n, value = m, fmt.Sprint(v)
+		case protowire.Fixed32Type:
+			v, m := protowire.ConsumeFixed32(data)
+			n, value = m, fmt.Sprintf("0x%08x", v)
+		case protowire.Fixed64Type:
+			v, m := protowire.ConsumeFixed64(data)
+			n, value = m, fmt.Sprintf("0x%016x", v)
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(data)
+			n, value = m, fmt.Sprintf("%q", v)
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, data)
+			value = "?"
+		}
+		if n < 0 {
+			return fmt.Sprintf("{%s <malformed: %v>}", strings.Join(fields, " "), protowire.ParseError(n))
+		}
+		data = data[n:]
+		fields = append(fields, fmt.Sprintf("%d:%s", number, value))
+	}
+
+	return "{" + strings.Join(fields, " ") + "}"
+}
+
+// replay 按原始时间间隔将一个连接某个方向的包重新发往服务端
+// 握手按抓包记录的协议版本和能力重新进行 应答类的包(心跳应答、PUBLISH 确认)不回放 由回放端对服务端实时应答
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	address := fs.String("addr", "", "server address")
+	connectionID := fs.String("conn", "", "connection id to replay, default the first connection in the capture")
+	identifier := fs.String("id", "", "connection id used when replaying, default the captured one")
+	direction := fs.String("direction", "in", "packets to replay: in (server side capture) or out (client side capture)")
+	speed := fs.Float64("speed", 1, "replay speed multiplier, <=0 sends without delay")
+	wait := fs.Duration("wait", 3*time.Second, "time to wait for server packets after replay")
+	codecName := fs.String("codec", "varint", "frame codec: varint, fixed or crc32")
+	authMethod := fs.String("auth-method", "", "auth method for handshake")
+	authData := fs.String("auth-data", "", "auth data for handshake")
+	_ = fs.Parse(args)
+
+	if *address == "" {
+		fs.Usage()
+		return errors.New("server address required")
+	}
+	kind := network.CaptureInbound
+	switch *direction {
+	case "in":
+	case "out":
+		kind = network.CaptureOutbound
+	default:
+		return fmt.Errorf("unknown direction %q", *direction)
+	}
+	codec, err := frameCodecByName(*codecName)
+	if err != nil {
+		return err
+	}
+	reader, closer, err := openCapture(fs)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	connection, records, err := collectReplay(reader, *connectionID, kind)
+	if err != nil {
+		return err
+	}
+	cfg := &network.BrokerConf{
+		ConnectionID:      connection.ConnectionID,
+		KeepaliveInterval: int(connection.Keepalive / time.Millisecond),
+		Version:           connection.Version,
+		Capabilities:      connection.Capabilities,
+		ByteOrder:         reader.ByteOrder(),
+		AuthMethod:        *authMethod,
+		AuthData:          []byte(*authData),
+		FrameCodec:        codec,
+	}
+	if *identifier != "" {
+		cfg.ConnectionID = *identifier
+	}
+
+	dialed := network.TcpDialBrokerFactory(*address, cfg)(context.Background())
+	if dialed == nil {
+		return fmt.Errorf("connect %s failed", *address)
+	}
+	defer dialed.Close()
+	broker := &serialBroker{ConnectionBroker: dialed}
+	fmt.Printf("replaying %d packets of %s to %s as %s\n", len(records), connection.ConnectionID, *address, broker.ConnectionID())
+
+	go receive(broker)
+
+	start := time.Now()
+	for _, record := range records {
+		if *speed > 0 {
+			delay := time.Duration(float64(record.Offset-records[0].Offset) / *speed)
+			time.Sleep(time.Until(start.Add(delay)))
+		}
+		if err = broker.WritePacket(record.Packet); err != nil {
+			return fmt.Errorf("replay %s failed: %w", record.Packet.Name(), err)
+		}
+		fmt.Printf("-> %s\n", record.Packet.String())
+	}
+	time.Sleep(*wait)
+
+	return nil
+}
+
+// collectReplay 收集待回放的包
+func collectReplay(reader *network.CaptureReader, connectionID string, kind network.CaptureKind) (*network.CaptureConnection, []*network.CaptureRecord, error) {
+	var (
+		stream     uint64
+		connection *network.CaptureConnection
+		records    []*network.CaptureRecord
+	)
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if connection == nil {
+			if record.Kind == network.CaptureOpen && (connectionID == "" || record.Connection.ConnectionID == connectionID) {
+				stream, connection = record.Stream, record.Connection
+			}
+			continue
+		}
+		if record.Stream != stream {
+			continue
+		}
+		if record.Kind == network.CaptureClose {
+			break
+		}
+		if record.Kind != kind || record.Packet == nil {
+			continue
+		}
+		switch record.Packet.Header().PacketType {
+		case network.Connect, network.ConnectAck, network.HeartbeatAck, network.PublishAck:
+			continue
+		}
+		records = append(records, record)
+	}
+	if connection == nil {
+		return nil, nil, fmt.Errorf("connection %q not found in capture", connectionID)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("no packets to replay for %s", connection.ConnectionID)
+	}
+
+	return connection, records, nil
+}
+
+// serialBroker 回放协程和应答协程同时写入 写入串行化
+type serialBroker struct {
+	network.ConnectionBroker
+	lock sync.Mutex
+}
+
+func (gs *serialBroker) WritePacket(packet network.ControlPacket) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	return gs.ConnectionBroker.WritePacket(packet)
+}
+
+func (gs *serialBroker) WritePackets(packets []network.ControlPacket) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	return gs.ConnectionBroker.WritePackets(packets)
+}
+
+// receive 打印服务端发来的包 并应答心跳和需确认的 PUBLISH
+func receive(broker network.ConnectionBroker) {
+	for {
+		packet, err := broker.ReadPacket()
+		if err != nil {
+			fmt.Printf("<- read stopped: %v\n", err)
+			return
+		}
+		fmt.Printf("<- %s\n", packet.String())
+
+		switch p := packet.(type) {
+		case *network.HeartbeatPacket:
+			ack := network.NewControlPacket(network.HeartbeatAck).(*network.HeartbeatAckPacket)
+			ack.Timestamp = p.Timestamp
+			_ = broker.WritePacket(ack)
+		case *network.PublishPacket:
+			if p.MessageID != 0 && broker.Capabilities().Has(network.CapAckMode) {
+				ack := network.NewControlPacket(network.PublishAck).(*network.PublishAckPacket)
+				ack.MessageID = p.MessageID
+				_ = broker.WritePacket(ack)
+			}
+		}
+	}
+}
+
+func frameCodecByName(name string) (network.FrameCodec, error) {
+	for _, codec := range []network.FrameCodec{network.VarintFrameCodec, network.FixedLengthFrameCodec, network.CRC32FrameCodec} {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown frame codec %q", name)
+}
@@ -0,0 +1,284 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 接入过滤
+// 服务端接受连接后、读取 CONNECT 之前按顺序执行过滤链 任一过滤器拒绝则直接关闭连接
+// 内置 CIDR 黑白名单(可运行时重载)、单IP并发连接数上限和单IP握手频率上限
+
+var (
+	ErrIPDenied                = errors.New("remote ip denied")
+	ErrIPNotAllowed            = errors.New("remote ip not in allow list")
+	ErrTooManyConnectionsPerIP = errors.New("too many connections from remote ip")
+	ErrHandshakeRateExceeded   = errors.New("handshake rate exceeded for remote ip")
+)
+
+// handshakeBucketSweepInterval 清理空闲握手令牌桶的间隔
+const handshakeBucketSweepInterval = time.Minute
+
+// AcceptFilter 接入过滤器 并发调用
+type AcceptFilter interface {
+	// Allow 是否接受来自ip的连接 返回错误时拒绝 无法解析的地址为零值
+	Allow(ip netip.Addr) error
+	// Release 已接受的连接关闭或握手失败
+	Release(ip netip.Addr)
+}
+
+// acceptFilterChain 过滤链
+type acceptFilterChain []AcceptFilter
+
+// allow 依次过滤 被拒绝时释放已通过的过滤器
+func (gs acceptFilterChain) allow(ip netip.Addr) error {
+	for i, filter := range gs {
+		if err := filter.Allow(ip); err != nil {
+			for _, accepted := range gs[:i] {
+				accepted.Release(ip)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (gs acceptFilterChain) release(ip netip.Addr) {
+	for _, filter := range gs {
+		filter.Release(ip)
+	}
+}
+
+// remoteIP 远端IP IPv4映射的IPv6地址转换为IPv4
+func remoteIP(addr net.Addr) netip.Addr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort().Addr().Unmap()
+	case *net.UDPAddr:
+		return a.AddrPort().Addr().Unmap()
+	}
+	if addr == nil {
+		return netip.Addr{}
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
+type ipRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// IPFilter CIDR 黑白名单
+// 命中黑名单拒绝 白名单非空时只接受白名单内的地址
+type IPFilter struct {
+	rules atomic.Pointer[ipRules]
+}
+
+// NewIPFilter 创建黑白名单 支持 CIDR 和单个IP
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	instance := &IPFilter{}
+	if err := instance.Reload(allow, deny); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// Reload 替换黑白名单 解析失败时保留原名单
+func (gs *IPFilter) Reload(allow, deny []string) error {
+	allowPrefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	denyPrefixes, err := parsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+	gs.rules.Store(&ipRules{allow: allowPrefixes, deny: denyPrefixes})
+
+	return nil
+}
+
+func (gs *IPFilter) Allow(ip netip.Addr) error {
+	rules := gs.rules.Load()
+	if containsIP(rules.deny, ip) {
+		return ErrIPDenied
+	}
+	if len(rules.allow) > 0 && !containsIP(rules.allow, ip) {
+		return ErrIPNotAllowed
+	}
+
+	return nil
+}
+
+func (gs *IPFilter) Release(ip netip.Addr) {
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(value)
+			if ipErr != nil {
+				return nil, fmt.Errorf("invalid cidr %q: %w", value, err)
+			}
+			prefix = netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPConnectionLimiter 单IP并发连接数上限
+type IPConnectionLimiter struct {
+	maxConnections atomic.Int32
+	connections    map[netip.Addr]int
+	lock           sync.Mutex
+}
+
+// NewIPConnectionLimiter 创建单IP并发连接数上限 <=0 不限制
+func NewIPConnectionLimiter(maxConnections int) *IPConnectionLimiter {
+	instance := &IPConnectionLimiter{
+		connections: make(map[netip.Addr]int),
+	}
+	instance.SetMaxConnections(maxConnections)
+
+	return instance
+}
+
+// SetMaxConnections 调整上限 只影响之后的新连接
+func (gs *IPConnectionLimiter) SetMaxConnections(maxConnections int) {
+	gs.maxConnections.Store(int32(maxConnections))
+}
+
+// Connections 来自ip的当前连接数
+func (gs *IPConnectionLimiter) Connections(ip netip.Addr) int {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	return gs.connections[ip]
+}
+
+func (gs *IPConnectionLimiter) Allow(ip netip.Addr) error {
+	if !ip.IsValid() {
+		return nil
+	}
+	maxConnections := int(gs.maxConnections.Load())
+
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if maxConnections > 0 && gs.connections[ip] >= maxConnections {
+		return ErrTooManyConnectionsPerIP
+	}
+	gs.connections[ip]++
+
+	return nil
+}
+
+func (gs *IPConnectionLimiter) Release(ip netip.Addr) {
+	if !ip.IsValid() {
+		return
+	}
+
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.connections[ip] <= 1 {
+		delete(gs.connections, ip)
+		return
+	}
+	gs.connections[ip]--
+}
+
+// HandshakeRateLimiter 单IP握手频率上限
+type HandshakeRateLimiter struct {
+	limit     RateLimit
+	buckets   map[netip.Addr]*tokenBucket
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// NewHandshakeRateLimiter 创建单IP握手频率上限 Rate<=0 不限制
+func NewHandshakeRateLimiter(limit RateLimit) *HandshakeRateLimiter {
+	return &HandshakeRateLimiter{
+		limit:     limit,
+		buckets:   make(map[netip.Addr]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetLimit 调整频率上限 已有的计数重新开始
+func (gs *HandshakeRateLimiter) SetLimit(limit RateLimit) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	gs.limit = limit
+	gs.buckets = make(map[netip.Addr]*tokenBucket)
+}
+
+func (gs *HandshakeRateLimiter) Allow(ip netip.Addr) error {
+	if !ip.IsValid() {
+		return nil
+	}
+	now := time.Now()
+
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.limit.Rate <= 0 {
+		return nil
+	}
+	gs.sweep(now)
+	bucket, ok := gs.buckets[ip]
+	if !ok {
+		bucket = newTokenBucket(gs.limit)
+		gs.buckets[ip] = bucket
+	}
+	if bucket.wait(now) > 0 {
+		return ErrHandshakeRateExceeded
+	}
+	bucket.take()
+
+	return nil
+}
+
+func (gs *HandshakeRateLimiter) Release(ip netip.Addr) {
+}
+
+// sweep 定期清理已补满的令牌桶 调用方持有锁
+func (gs *HandshakeRateLimiter) sweep(now time.Time) {
+	if now.Sub(gs.lastSweep) < handshakeBucketSweepInterval {
+		return
+	}
+	gs.lastSweep = now
+	for ip, bucket := range gs.buckets {
+		if bucket.wait(now) == 0 && bucket.tokens >= bucket.burst {
+			delete(gs.buckets, ip)
+		}
+	}
+}
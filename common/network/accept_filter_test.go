@@ -0,0 +1,207 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestIPFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		ip      netip.Addr
+		wantErr error
+	}{
+		{name: "no rules", ip: netip.MustParseAddr("10.0.0.1")},
+		{name: "denied cidr", deny: []string{"10.0.0.0/8"}, ip: netip.MustParseAddr("10.1.2.3"), wantErr: ErrIPDenied},
+		{name: "denied single ip", deny: []string{"192.168.1.7"}, ip: netip.MustParseAddr("192.168.1.7"), wantErr: ErrIPDenied},
+		{name: "outside deny list", deny: []string{"10.0.0.0/8"}, ip: netip.MustParseAddr("11.0.0.1")},
+		{name: "in allow list", allow: []string{"172.16.0.0/12"}, ip: netip.MustParseAddr("172.16.5.5")},
+		{name: "outside allow list", allow: []string{"172.16.0.0/12"}, ip: netip.MustParseAddr("8.8.8.8"), wantErr: ErrIPNotAllowed},
+		{name: "deny wins over allow", allow: []string{"10.0.0.0/8"}, deny: []string{"10.0.0.5"}, ip: netip.MustParseAddr("10.0.0.5"), wantErr: ErrIPDenied},
+		{name: "ipv6", allow: []string{"2001:db8::/32"}, ip: netip.MustParseAddr("2001:db8::1")},
+		// 无法解析的远端地址为零值 不命中任何名单
+		{name: "unparseable address without allow list", deny: []string{"0.0.0.0/0"}, ip: netip.Addr{}},
+		{name: "unparseable address with allow list", allow: []string{"0.0.0.0/0"}, ip: netip.Addr{}, wantErr: ErrIPNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewIPFilter(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("new ip filter: %v", err)
+			}
+			if err = filter.Allow(tt.ip); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("allow %s: %v, want %v", tt.ip, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIPFilterReload(t *testing.T) {
+	if _, err := NewIPFilter([]string{"not a cidr"}, nil); err == nil {
+		t.Fatalf("invalid cidr accepted")
+	}
+	filter, err := NewIPFilter(nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("new ip filter: %v", err)
+	}
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	// 解析失败时保留原名单
+	if err = filter.Reload(nil, []string{"10.0.0.0/33"}); err == nil {
+		t.Fatalf("invalid cidr accepted on reload")
+	}
+	if err = filter.Allow(ip); !errors.Is(err, ErrIPDenied) {
+		t.Fatalf("allow after failed reload: %v, want %v", err, ErrIPDenied)
+	}
+	if err = filter.Reload(nil, nil); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if err = filter.Allow(ip); err != nil {
+		t.Fatalf("allow after reload: %v", err)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		want netip.Addr
+	}{
+		{name: "tcp", addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}, want: netip.MustParseAddr("10.0.0.1")},
+		{name: "mapped ipv4", addr: &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 80}, want: netip.MustParseAddr("10.0.0.1")},
+		{name: "udp", addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}, want: netip.MustParseAddr("2001:db8::1")},
+		{name: "address string", addr: wsAddr("10.0.0.2:443"), want: netip.MustParseAddr("10.0.0.2")},
+		{name: "unparseable", addr: wsAddr("websocket")},
+		{name: "nil", addr: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remoteIP(tt.addr); got != tt.want {
+				t.Fatalf("remote ip %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptFilterChainReleasesOnReject(t *testing.T) {
+	limiter := NewIPConnectionLimiter(10)
+	deny, err := NewIPFilter(nil, []string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("new ip filter: %v", err)
+	}
+	chain := acceptFilterChain{limiter, deny}
+	ip := netip.MustParseAddr("10.0.0.1")
+	if err = chain.allow(ip); !errors.Is(err, ErrIPDenied) {
+		t.Fatalf("allow: %v, want %v", err, ErrIPDenied)
+	}
+	// 后面的过滤器拒绝时 已通过的过滤器释放计数
+	if count := limiter.Connections(ip); count != 0 {
+		t.Fatalf("%d connections counted after reject", count)
+	}
+}
+
+// waitConnections 等待单IP连接数达到预期
+func waitConnections(t *testing.T, limiter *IPConnectionLimiter, ip netip.Addr, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for limiter.Connections(ip) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections from %s, want %d", limiter.Connections(ip), ip, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIPConnectionLimiterRelease(t *testing.T) {
+	limiter := NewIPConnectionLimiter(1)
+	closed := make(chan struct{}, 1)
+	server := startServer(t,
+		WithAcceptFilters(limiter),
+		WithHandshakeTimeout(300*time.Millisecond),
+		WithOnConnectionClose(func(string) {
+			closed <- struct{}{}
+		}),
+	)
+	ip := netip.MustParseAddr("127.0.0.1")
+
+	// 握手失败 未发送 CONNECT 直到握手超时
+	conn, err := net.Dial("tcp", server.address)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, limiter, ip, 1)
+	waitConnections(t, limiter, ip, 0)
+
+	// 握手失败 发送无法解析的数据
+	if conn, err = net.Dial("tcp", server.address); err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	waitConnections(t, limiter, ip, 0)
+
+	// 连接关闭后释放 同一IP可以再次连接
+	for _, connectionID := range []string{"c1", "c2"} {
+		client := dialServer(t, server, connectionID)
+		waitConnections(t, limiter, ip, 1)
+		_ = client.Close()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not closed", connectionID)
+		}
+		waitConnections(t, limiter, ip, 0)
+	}
+}
+
+func TestHandshakeRateLimiterSweep(t *testing.T) {
+	limiter := NewHandshakeRateLimiter(RateLimit{Rate: 1, Burst: 2})
+	busy := netip.MustParseAddr("10.0.0.1")
+	idle := netip.MustParseAddr("10.0.0.2")
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow(busy); err != nil {
+			t.Fatalf("allow %d: %v", i, err)
+		}
+	}
+	if err := limiter.Allow(busy); !errors.Is(err, ErrHandshakeRateExceeded) {
+		t.Fatalf("allow over burst: %v, want %v", err, ErrHandshakeRateExceeded)
+	}
+	if err := limiter.Allow(idle); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	// 不受限制的地址不占用令牌桶
+	if err := limiter.Allow(netip.Addr{}); err != nil {
+		t.Fatalf("allow unparseable address: %v", err)
+	}
+
+	// idle 的令牌桶已补满 busy 仍在补充中
+	limiter.lock.Lock()
+	limiter.buckets[idle].last = time.Now().Add(-time.Minute)
+	limiter.buckets[busy].last = time.Now()
+	limiter.buckets[busy].tokens = 0
+	limiter.lastSweep = time.Now().Add(-handshakeBucketSweepInterval)
+	limiter.lock.Unlock()
+
+	if err := limiter.Allow(netip.MustParseAddr("10.0.0.3")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if _, ok := limiter.buckets[idle]; ok {
+		t.Fatalf("refilled bucket not swept")
+	}
+	if _, ok := limiter.buckets[busy]; !ok {
+		t.Fatalf("bucket still refilling swept")
+	}
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("%d buckets left, want 2", len(limiter.buckets))
+	}
+}
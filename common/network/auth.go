@@ -0,0 +1,170 @@
+package network
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 连接鉴权
+// 客户端在 CONNECT 中携带鉴权方式和鉴权数据 服务端通过 Authenticator 校验
+// 校验通过的身份信息挂载到连接代理上 校验失败按错误回复对应的拒绝码
+
+const (
+	// AuthMethodHMACToken HMAC签名令牌鉴权
+	AuthMethodHMACToken = "hmac-token"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Principal 鉴权通过的身份信息
+type Principal struct {
+	ID         string            // 身份标识 如玩家ID
+	Method     string            // 鉴权方式
+	Attributes map[string]string // 附加属性
+	ExpireAt   time.Time         // 过期时间 零值不过期
+}
+
+// Authenticator 鉴权器
+// 返回 ErrBadCredentials/ErrBanned/ErrNotAuthorized 时回复对应的拒绝码
+// 其他错误视为 ErrNotAuthorized
+type Authenticator interface {
+	Authenticate(connect *ConnectPacket) (*Principal, error)
+}
+
+// AuthenticatorFunc 函数形式的鉴权器
+type AuthenticatorFunc func(connect *ConnectPacket) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(connect *ConnectPacket) (*Principal, error) {
+	return f(connect)
+}
+
+// authReturnCode 鉴权错误对应的拒绝码
+func authReturnCode(err error) int {
+	switch {
+	case errors.Is(err, ErrBadCredentials):
+		return RefusedBadCredentials
+	case errors.Is(err, ErrBanned):
+		return RefusedBanned
+	default:
+		return RefusedNotAuthorized
+	}
+}
+
+// TokenClaims 令牌声明
+type TokenClaims struct {
+	Subject    string            `json:"sub"`
+	IssuedAt   int64             `json:"iat"`
+	ExpireAt   int64             `json:"exp"`
+	Attributes map[string]string `json:"attrs,omitempty"`
+}
+
+// SignHMACToken 签发HMAC令牌
+// 格式: base64url(claims json) + "." + base64url(hmac-sha256(claims部分))
+func SignHMACToken(secret []byte, claims TokenClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature := base64.RawURLEncoding.EncodeToString(signHMAC(secret, payload))
+
+	return payload + "." + signature, nil
+}
+
+// NewHMACToken 为指定身份签发有效期为ttl的令牌
+func NewHMACToken(secret []byte, subject string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return SignHMACToken(secret, TokenClaims{
+		Subject:  subject,
+		IssuedAt: now.Unix(),
+		ExpireAt: now.Add(ttl).Unix(),
+	})
+}
+
+func signHMAC(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// HMACTokenAuthenticator HMAC签名令牌鉴权器
+// 令牌声明的身份需要与 ClientIdentifier 一致
+type HMACTokenAuthenticator struct {
+	secret    []byte
+	clockSkew time.Duration             // 允许的时钟误差
+	isBanned  func(subject string) bool // 封禁检查
+}
+
+// NewHMACTokenAuthenticator 创建HMAC令牌鉴权器
+// isBanned 可为nil
+func NewHMACTokenAuthenticator(secret []byte, isBanned func(subject string) bool) *HMACTokenAuthenticator {
+	return &HMACTokenAuthenticator{
+		secret:    secret,
+		clockSkew: 30 * time.Second,
+		isBanned:  isBanned,
+	}
+}
+
+func (gs *HMACTokenAuthenticator) Authenticate(connect *ConnectPacket) (*Principal, error) {
+	if connect.AuthMethod != AuthMethodHMACToken {
+		return nil, ErrBadCredentials
+	}
+	claims, err := gs.Verify(string(connect.AuthData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadCredentials, err)
+	}
+	if claims.Subject != connect.ClientIdentifier {
+		return nil, ErrBadCredentials
+	}
+	if gs.isBanned != nil && gs.isBanned(claims.Subject) {
+		return nil, ErrBanned
+	}
+
+	principal := &Principal{
+		ID:         claims.Subject,
+		Method:     AuthMethodHMACToken,
+		Attributes: claims.Attributes,
+	}
+	if claims.ExpireAt > 0 {
+		principal.ExpireAt = time.Unix(claims.ExpireAt, 0)
+	}
+
+	return principal, nil
+}
+
+// Verify 校验令牌签名和有效期
+func (gs *HMACTokenAuthenticator) Verify(token string) (*TokenClaims, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal(sign, signHMAC(gs.secret, payload)) {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &TokenClaims{}
+	if err = json.Unmarshal(data, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpireAt > 0 && time.Now().Add(-gs.clockSkew).Unix() > claims.ExpireAt {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
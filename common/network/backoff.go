@@ -0,0 +1,48 @@
+package network
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// DefaultBackoff 默认重连退避策略
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Backoff 指数退避
+// 第n次重试等待 Initial * Multiplier^n 不超过 Max
+// Jitter 为随机抖动比例 [0, 1] 避免大量客户端同时重连
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// Next 获取第attempt次(从0开始)重试的等待时间
+func (gs Backoff) Next(attempt int) time.Duration {
+	if gs.Initial <= 0 {
+		return 0
+	}
+	multiplier := gs.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(gs.Initial) * math.Pow(multiplier, float64(attempt))
+	if gs.Max > 0 && delay > float64(gs.Max) {
+		delay = float64(gs.Max)
+	}
+	if gs.Jitter > 0 {
+		jitter := math.Min(gs.Jitter, 1)
+		// 在 [delay*(1-jitter), delay*(1+jitter)] 内随机
+		delay = delay * (1 - jitter + 2*jitter*rand.Float64())
+	}
+
+	return time.Duration(delay)
+}
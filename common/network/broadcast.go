@@ -0,0 +1,256 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"GameServer/gslog"
+)
+
+// 广播分组
+// 房间、公会频道、世界公告等按名字分组 连接加入/离开分组
+// 广播只编码一次 以非阻塞方式投递到每个成员的发送队列 慢连接丢包不影响其他成员
+// 连接关闭时通过 OnConnectionClose 自动退出所有分组
+
+// BroadcastResult 广播投递结果
+type BroadcastResult struct {
+	Sent    int // 成功入队
+	Dropped int // 发送队列满被丢弃
+	Failed  int // 连接已关闭等其他错误
+}
+
+// GroupManager 广播分组管理
+type GroupManager struct {
+	byteOrder   binary.ByteOrder
+	groups      map[string]map[string]ConnectionLayer // group => connectionID => conn
+	memberships map[string]map[string]struct{}        // connectionID => groups
+
+	lock sync.RWMutex
+}
+
+// NewGroupManager 创建分组管理 order为广播包的编码字节序 需与连接一致才能共享编码
+func NewGroupManager(order binary.ByteOrder) *GroupManager {
+	return &GroupManager{
+		byteOrder:   order,
+		groups:      make(map[string]map[string]ConnectionLayer),
+		memberships: make(map[string]map[string]struct{}),
+	}
+}
+
+// Join 加入分组 分组不存在时创建 已在分组中或连接已关闭返回false
+func (gs *GroupManager) Join(group string, conn ConnectionLayer) bool {
+	// 已关闭的连接不会再触发 OnConnectionClose 加入后无法退出
+	if conn.IsClosed() {
+		return false
+	}
+	connectionID := conn.ConnectionID()
+
+	gs.lock.Lock()
+	members, ok := gs.groups[group]
+	if !ok {
+		members = make(map[string]ConnectionLayer)
+		gs.groups[group] = members
+	}
+	if _, exist := members[connectionID]; exist {
+		gs.lock.Unlock()
+		return false
+	}
+	members[connectionID] = conn
+
+	joined, ok := gs.memberships[connectionID]
+	if !ok {
+		joined = make(map[string]struct{})
+		gs.memberships[connectionID] = joined
+	}
+	joined[group] = struct{}{}
+	gs.lock.Unlock()
+
+	// 加入期间连接关闭 OnConnectionClose 可能在加入之前已执行
+	if conn.IsClosed() {
+		gs.Leave(group, connectionID)
+		return false
+	}
+
+	return true
+}
+
+// Leave 离开分组 分组为空时删除 不在分组中返回false
+func (gs *GroupManager) Leave(group string, connectionID string) bool {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	return gs.leave(group, connectionID)
+}
+
+func (gs *GroupManager) leave(group string, connectionID string) bool {
+	members, ok := gs.groups[group]
+	if !ok {
+		return false
+	}
+	if _, exist := members[connectionID]; !exist {
+		return false
+	}
+	delete(members, connectionID)
+	if len(members) == 0 {
+		delete(gs.groups, group)
+	}
+
+	if joined, ok := gs.memberships[connectionID]; ok {
+		delete(joined, group)
+		if len(joined) == 0 {
+			delete(gs.memberships, connectionID)
+		}
+	}
+
+	return true
+}
+
+// LeaveAll 退出连接加入的所有分组
+func (gs *GroupManager) LeaveAll(connectionID string) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	for group := range gs.memberships[connectionID] {
+		gs.leave(group, connectionID)
+	}
+}
+
+// OnConnectionClose 连接关闭 退出所有分组
+func (gs *GroupManager) OnConnectionClose(connectionID string) {
+	gs.LeaveAll(connectionID)
+}
+
+// Dismiss 解散分组
+func (gs *GroupManager) Dismiss(group string) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	for connectionID := range gs.groups[group] {
+		gs.leave(group, connectionID)
+	}
+}
+
+// IsMember 连接是否在分组中
+func (gs *GroupManager) IsMember(group string, connectionID string) bool {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	_, ok := gs.groups[group][connectionID]
+	return ok
+}
+
+// Count 分组成员数
+func (gs *GroupManager) Count(group string) int {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	return len(gs.groups[group])
+}
+
+// Members 分组成员快照
+func (gs *GroupManager) Members(group string) []ConnectionLayer {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	members := make([]ConnectionLayer, 0, len(gs.groups[group]))
+	for _, conn := range gs.groups[group] {
+		members = append(members, conn)
+	}
+
+	return members
+}
+
+// Groups 所有分组名
+func (gs *GroupManager) Groups() []string {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	groups := make([]string, 0, len(gs.groups))
+	for group := range gs.groups {
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// GroupsOf 连接加入的分组
+func (gs *GroupManager) GroupsOf(connectionID string) []string {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	groups := make([]string, 0, len(gs.memberships[connectionID]))
+	for group := range gs.memberships[connectionID] {
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// Broadcast 向分组广播 exclude中的连接不发送(如消息发送者自己)
+func (gs *GroupManager) Broadcast(group string, packet ControlPacket, exclude ...string) (BroadcastResult, error) {
+	members := gs.Members(group)
+	if len(exclude) > 0 {
+		members = excludeConnections(members, exclude)
+	}
+
+	return Multicast(members, packet, gs.byteOrder)
+}
+
+// BroadcastRoute 以指定路由向分组广播已编码的消息
+func (gs *GroupManager) BroadcastRoute(group string, route uint32, body []byte, exclude ...string) (BroadcastResult, error) {
+	packet := NewControlPacket(Publish).(*PublishPacket)
+	packet.Payload = EncodeRouteMessage(route, body, gs.byteOrder)
+
+	return gs.Broadcast(group, packet, exclude...)
+}
+
+// Multicast 预编码一次后非阻塞投递到所有连接
+// 队列满的连接丢弃该包 不等待慢连接
+func Multicast(conns []ConnectionLayer, packet ControlPacket, order binary.ByteOrder) (BroadcastResult, error) {
+	var result BroadcastResult
+	if len(conns) == 0 {
+		return result, nil
+	}
+
+	encoded, ok := packet.(*EncodedPacket)
+	if !ok {
+		var err error
+		if encoded, err = PreEncode(packet, order); err != nil {
+			return result, err
+		}
+	}
+
+	for _, conn := range conns {
+		err := conn.TryWritePacket(encoded)
+		switch {
+		case err == nil:
+			result.Sent++
+		case errors.Is(err, ErrQueueFull), errors.Is(err, ErrQueueOverflow):
+			result.Dropped++
+			gslog.Debug("[Multicast] outbound queue full, packet dropped", "connID", conn.ConnectionID(), "packet", encoded.Name())
+		default:
+			result.Failed++
+		}
+	}
+
+	return result, nil
+}
+
+func excludeConnections(conns []ConnectionLayer, exclude []string) []ConnectionLayer {
+	filtered := conns[:0]
+	for _, conn := range conns {
+		excluded := false
+		for _, connectionID := range exclude {
+			if conn.ConnectionID() == connectionID {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered = append(filtered, conn)
+		}
+	}
+
+	return filtered
+}
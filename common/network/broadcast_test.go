@@ -0,0 +1,49 @@
+package network
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestGroupJoinClosedConnection(t *testing.T) {
+	groups := NewGroupManager(binary.BigEndian)
+	opened := make(chan ConnectionLayer, 1)
+	joinedOnClose := make(chan bool, 1)
+	server := startServer(t,
+		WithOnConnectionOpen(func(conn ConnectionLayer) {
+			if !groups.Join("room", conn) {
+				t.Errorf("join open connection failed")
+			}
+			opened <- conn
+		}),
+		WithGroupManager(groups),
+		WithOnConnectionClose(func(string) {
+			// 关闭回调执行时连接已关闭 OnConnectionClose 之后的加入被拒绝
+			conn := <-opened
+			joinedOnClose <- groups.Join("lobby", conn)
+		}),
+	)
+
+	broker := dialServer(t, server, "c1")
+	deadline := time.Now().Add(5 * time.Second)
+	for groups.Count("room") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !groups.IsMember("room", "c1") {
+		t.Fatalf("connection not joined")
+	}
+
+	_ = broker.Close()
+	select {
+	case joined := <-joinedOnClose:
+		if joined {
+			t.Fatalf("closed connection joined")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("close callback not called")
+	}
+	if groups.Count("room") != 0 || groups.Count("lobby") != 0 || len(groups.GroupsOf("c1")) != 0 {
+		t.Fatalf("closed connection left in groups %v", groups.Groups())
+	}
+}
@@ -0,0 +1,494 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"GameServer/gslog"
+)
+
+// 抓包
+// 在连接代理之上记录每个读写的控制报文 加解密和压缩之前的明文 握手报文不经过连接代理 不记录
+// 文件格式:
+//
+//	文件头 [魔数 "GSCP"][版本 1字节][字节序 1字节][起始时间 8字节 UnixNano 大端]
+//	记录   [类型 1字节][连接序号 uvarint][相对起始时间的纳秒 uvarint][数据长度 uvarint][数据]
+//
+// OPEN 记录的数据为连接信息 IN/OUT 为默认帧格式编码的完整包 CLOSE 无数据
+// 同一文件可记录多个连接 以连接序号区分
+
+const (
+	captureMagic   = "GSCP"
+	captureVersion = 1
+	// captureHeaderSize 文件头长度
+	captureHeaderSize = len(captureMagic) + 2 + 8
+	// maxCaptureRecordSize 单条记录数据的最大长度
+	maxCaptureRecordSize = maxFrameHeaderSize + MaxRemainLength
+)
+
+var (
+	ErrInvalidCaptureFile          = errors.New("invalid capture file")
+	ErrUnsupportedCaptureVersion   = errors.New("unsupported capture version")
+	ErrCaptureRecordTooLarge       = errors.New("capture record too large")
+	ErrCaptureStreamNotFound       = errors.New("capture stream not opened")
+	ErrUnsupportedCaptureByteOrder = errors.New("unsupported capture byte order")
+)
+
+// CaptureKind 抓包记录类型
+type CaptureKind byte
+
+const (
+	CaptureOpen     CaptureKind = iota // 连接建立
+	CaptureInbound                     // 读到的包
+	CaptureOutbound                    // 写出的包
+	CaptureClose                       // 连接关闭
+)
+
+var captureKindNames = []string{
+	"OPEN",
+	"IN",
+	"OUT",
+	"CLOSE",
+}
+
+func (gs CaptureKind) String() string {
+	if int(gs) >= len(captureKindNames) {
+		return "UNKNOWN"
+	}
+	return captureKindNames[gs]
+}
+
+// CaptureConnection 抓包记录的连接信息
+type CaptureConnection struct {
+	ConnectionID string
+	LocalAddr    string
+	RemoteAddr   string
+	Version      int
+	Keepalive    time.Duration
+	Capabilities Capability
+}
+
+// CaptureRecord 抓包记录
+type CaptureRecord struct {
+	Kind       CaptureKind
+	Stream     uint64 // 文件内的连接序号
+	Time       time.Time
+	Offset     time.Duration      // 相对抓包开始的时间
+	Connection *CaptureConnection // 所属连接
+	Frame      []byte             // IN/OUT 默认帧格式编码的包
+	Packet     ControlPacket      // IN/OUT 解码后的包 解码失败时为nil
+	DecodeErr  error              // 包解码失败的原因 如未注册的自定义包类型
+}
+
+func (gs *CaptureRecord) String() string {
+	prefix := fmt.Sprintf("%s #%d %-5s %s", gs.Offset, gs.Stream, gs.Kind.String(), gs.Connection.ConnectionID)
+	switch gs.Kind {
+	case CaptureOpen:
+		return fmt.Sprintf("%s local:%s, remote:%s, version:%d, keepalive:%s, capabilities:%s", prefix,
+			gs.Connection.LocalAddr, gs.Connection.RemoteAddr, gs.Connection.Version, gs.Connection.Keepalive, gs.Connection.Capabilities.String())
+	case CaptureInbound, CaptureOutbound:
+		if gs.Packet == nil {
+			return fmt.Sprintf("%s undecodable packet, size:%d, err:%v", prefix, len(gs.Frame), gs.DecodeErr)
+		}
+		return fmt.Sprintf("%s %s", prefix, gs.Packet.String())
+	}
+	return prefix
+}
+
+// CaptureWriter 抓包文件写入 并发安全
+// 写入失败后停止记录 不影响连接本身
+type CaptureWriter struct {
+	w         io.Writer
+	byteOrder binary.ByteOrder
+	start     time.Time
+	streams   atomic.Uint64
+	err       error
+	lock      sync.Mutex
+}
+
+// NewCaptureWriter 写入文件头 包按order编码 需与连接的字节序一致
+func NewCaptureWriter(w io.Writer, order binary.ByteOrder) (*CaptureWriter, error) {
+	orderFlag, err := captureByteOrderFlag(order)
+	if err != nil {
+		return nil, err
+	}
+	instance := &CaptureWriter{
+		w:         w,
+		byteOrder: order,
+		start:     time.Now(),
+	}
+
+	header := make([]byte, 0, captureHeaderSize)
+	header = append(header, captureMagic...)
+	header = append(header, captureVersion, orderFlag)
+	header = binary.BigEndian.AppendUint64(header, uint64(instance.start.UnixNano()))
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// CreateCaptureFile 创建抓包文件 Close 时关闭文件
+func CreateCaptureFile(name string, order binary.ByteOrder) (*CaptureWriter, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := NewCaptureWriter(file, order)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// Err 写入失败的原因
+func (gs *CaptureWriter) Err() error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	return gs.err
+}
+
+// Close 停止记录 底层实现了 io.Closer 时一并关闭
+func (gs *CaptureWriter) Close() error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if errors.Is(gs.err, os.ErrClosed) {
+		return nil
+	}
+	gs.err = os.ErrClosed
+	if closer, ok := gs.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// open 分配连接序号并记录连接信息
+func (gs *CaptureWriter) open(broker ConnectionBroker) uint64 {
+	stream := gs.streams.Add(1)
+	data := appendCaptureString(nil, broker.ConnectionID())
+	data = appendCaptureString(data, broker.LocalAddr())
+	data = appendCaptureString(data, broker.RemoteAddr())
+	data = binary.AppendUvarint(data, uint64(broker.Version()))
+	data = binary.AppendUvarint(data, uint64(broker.Keepalive()/time.Millisecond))
+	data = binary.AppendUvarint(data, uint64(broker.Capabilities()))
+	gs.write(CaptureOpen, stream, data)
+
+	return stream
+}
+
+// packet 以默认帧格式记录一个包
+func (gs *CaptureWriter) packet(kind CaptureKind, stream uint64, packet ControlPacket) {
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	frame, err := encodePacket(buf, packet, gs.byteOrder, VarintFrameCodec)
+	if err != nil {
+		gslog.Warn("[CaptureWriter] encode packet failed", "stream", stream, "packet", packet.Name(), "err", err)
+		return
+	}
+	gs.write(kind, stream, frame)
+}
+
+func (gs *CaptureWriter) write(kind CaptureKind, stream uint64, data []byte) {
+	offset := time.Since(gs.start)
+
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	var scratch [3*binary.MaxVarintLen64 + 1]byte
+	head := append(scratch[:0], byte(kind))
+	head = binary.AppendUvarint(head, stream)
+	head = binary.AppendUvarint(head, uint64(offset))
+	head = binary.AppendUvarint(head, uint64(len(data)))
+	buf.AppendBytes(head)
+	buf.AppendBytes(data)
+
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.err != nil {
+		return
+	}
+	// 单次写入 避免多个连接的记录交错
+	if _, err := gs.w.Write(buf.Bytes()); err != nil {
+		gs.err = err
+		gslog.Error("[CaptureWriter] write capture failed, stop capturing", "err", err)
+	}
+}
+
+// CaptureReader 抓包文件读取
+type CaptureReader struct {
+	r         *bufio.Reader
+	byteOrder binary.ByteOrder
+	start     time.Time
+	streams   map[uint64]*CaptureConnection
+}
+
+// NewCaptureReader 读取并校验文件头
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	reader := bufio.NewReader(r)
+	var header [captureHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCaptureFile, err)
+	}
+	if string(header[:len(captureMagic)]) != captureMagic {
+		return nil, ErrInvalidCaptureFile
+	}
+	if version := header[len(captureMagic)]; version != captureVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCaptureVersion, version)
+	}
+	order, err := captureByteOrder(header[len(captureMagic)+1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptureReader{
+		r:         reader,
+		byteOrder: order,
+		start:     time.Unix(0, int64(binary.BigEndian.Uint64(header[len(captureMagic)+2:]))),
+		streams:   make(map[uint64]*CaptureConnection),
+	}, nil
+}
+
+// ByteOrder 抓包时连接的字节序
+func (gs *CaptureReader) ByteOrder() binary.ByteOrder {
+	return gs.byteOrder
+}
+
+// Start 抓包开始时间
+func (gs *CaptureReader) Start() time.Time {
+	return gs.start
+}
+
+// Next 读取下一条记录 文件结束时返回 io.EOF 记录不完整时返回 io.ErrUnexpectedEOF
+func (gs *CaptureReader) Next() (*CaptureRecord, error) {
+	kind, err := gs.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := binary.ReadUvarint(gs.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	offset, err := binary.ReadUvarint(gs.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	size, err := binary.ReadUvarint(gs.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if size > maxCaptureRecordSize {
+		return nil, ErrCaptureRecordTooLarge
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(gs.r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	record := &CaptureRecord{
+		Kind:   CaptureKind(kind),
+		Stream: stream,
+		Offset: time.Duration(offset),
+		Time:   gs.start.Add(time.Duration(offset)),
+	}
+	switch record.Kind {
+	case CaptureOpen:
+		connection, err := decodeCaptureConnection(data)
+		if err != nil {
+			return nil, err
+		}
+		gs.streams[stream] = connection
+		record.Connection = connection
+		return record, nil
+	case CaptureInbound, CaptureOutbound, CaptureClose:
+	default:
+		return nil, fmt.Errorf("%w: unknown record kind %d", ErrInvalidCaptureFile, kind)
+	}
+
+	connection, ok := gs.streams[stream]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrCaptureStreamNotFound, stream)
+	}
+	record.Connection = connection
+	if record.Kind == CaptureClose {
+		delete(gs.streams, stream)
+		return record, nil
+	}
+	record.Frame = data
+	fixedHeader, body, err := readFrame(bytes.NewReader(data), 0)
+	if err == nil {
+		record.Packet, err = decodePacket(fixedHeader, body, gs.byteOrder)
+	}
+	record.DecodeErr = err
+
+	return record, nil
+}
+
+func decodeCaptureConnection(data []byte) (*CaptureConnection, error) {
+	connection := &CaptureConnection{}
+	var ok bool
+	if connection.ConnectionID, data, ok = readCaptureString(data); !ok {
+		return nil, ErrInvalidCaptureFile
+	}
+	if connection.LocalAddr, data, ok = readCaptureString(data); !ok {
+		return nil, ErrInvalidCaptureFile
+	}
+	if connection.RemoteAddr, data, ok = readCaptureString(data); !ok {
+		return nil, ErrInvalidCaptureFile
+	}
+	var values [3]uint64
+	for i := range values {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, ErrInvalidCaptureFile
+		}
+		values[i], data = value, data[n:]
+	}
+	connection.Version = int(values[0])
+	connection.Keepalive = time.Duration(values[1]) * time.Millisecond
+	connection.Capabilities = Capability(values[2])
+
+	return connection, nil
+}
+
+func appendCaptureString(dst []byte, s string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func readCaptureString(data []byte) (string, []byte, bool) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return "", nil, false
+	}
+	data = data[n:]
+	return string(data[:size]), data[size:], true
+}
+
+func captureByteOrderFlag(order binary.ByteOrder) (byte, error) {
+	switch order {
+	case binary.BigEndian:
+		return 0, nil
+	case binary.LittleEndian:
+		return 1, nil
+	}
+	return 0, ErrUnsupportedCaptureByteOrder
+}
+
+func captureByteOrder(flag byte) (binary.ByteOrder, error) {
+	switch flag {
+	case 0:
+		return binary.BigEndian, nil
+	case 1:
+		return binary.LittleEndian, nil
+	}
+	return nil, ErrUnsupportedCaptureByteOrder
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// captureBroker 抓包连接代理 记录经过的每个包
+type captureBroker struct {
+	ConnectionBroker
+	capture   *CaptureWriter
+	stream    uint64
+	closeOnce sync.Once
+}
+
+// captureUnreliableBroker 底层支持不可靠通道时保留该能力
+type captureUnreliableBroker struct {
+	*captureBroker
+	writer UnreliableWriter
+}
+
+// NewCaptureBroker 包装连接代理 读写的包记录到capture
+func NewCaptureBroker(broker ConnectionBroker, capture *CaptureWriter) ConnectionBroker {
+	instance := &captureBroker{
+		ConnectionBroker: broker,
+		capture:          capture,
+	}
+	instance.stream = capture.open(broker)
+	if writer, ok := broker.(UnreliableWriter); ok {
+		return &captureUnreliableBroker{captureBroker: instance, writer: writer}
+	}
+
+	return instance
+}
+
+// CaptureBrokerFactory 包装连接代理工厂 每次获得的连接代理都记录到capture
+func CaptureBrokerFactory(factory ConnBrokerFactory, capture *CaptureWriter) ConnBrokerFactory {
+	return func(ctx context.Context) ConnectionBroker {
+		broker := factory(ctx)
+		if broker == nil {
+			return nil
+		}
+		return NewCaptureBroker(broker, capture)
+	}
+}
+
+func (gs *captureBroker) ReadPacket() (ControlPacket, error) {
+	packet, err := gs.ConnectionBroker.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	gs.capture.packet(CaptureInbound, gs.stream, packet)
+
+	return packet, nil
+}
+
+func (gs *captureBroker) WritePacket(packet ControlPacket) error {
+	if err := gs.ConnectionBroker.WritePacket(packet); err != nil {
+		return err
+	}
+	gs.capture.packet(CaptureOutbound, gs.stream, packet)
+
+	return nil
+}
+
+func (gs *captureBroker) WritePackets(packets []ControlPacket) error {
+	if err := gs.ConnectionBroker.WritePackets(packets); err != nil {
+		return err
+	}
+	for _, packet := range packets {
+		gs.capture.packet(CaptureOutbound, gs.stream, packet)
+	}
+
+	return nil
+}
+
+func (gs *captureBroker) Close() error {
+	gs.closeOnce.Do(func() {
+		gs.capture.write(CaptureClose, gs.stream, nil)
+	})
+
+	return gs.ConnectionBroker.Close()
+}
+
+func (gs *captureUnreliableBroker) WriteUnreliable(packet ControlPacket) error {
+	if err := gs.writer.WriteUnreliable(packet); err != nil {
+		return err
+	}
+	gs.capture.packet(CaptureOutbound, gs.stream, packet)
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"GameServer/gslog"
+)
+
+// DefaultDialTimeout 默认拨号超时时间
+var DefaultDialTimeout = 5 * time.Second
+
+// NewTcpClient 创建TCP客户端连接层
+// 通过 ConnectBroker 完成握手 断线后按 WithReconnect 配置的退避策略重连
+func NewTcpClient(ctx context.Context, address string, cfg *BrokerConf, options ...KeeperOption) ConnectionLayer {
+	return NewClient(ctx, cfg, TcpDialBrokerFactory(address, cfg), options...)
+}
+
+// NewTlsClient 创建TLS客户端连接层
+func NewTlsClient(ctx context.Context, address string, cfg *BrokerConf, tlsConfig *tls.Config, options ...KeeperOption) ConnectionLayer {
+	return NewClient(ctx, cfg, TlsDialBrokerFactory(address, cfg, tlsConfig), options...)
+}
+
+// NewClient 以指定的连接代理工厂创建客户端连接层 首次连接失败时按 cfg.ConnectionID 重连
+func NewClient(ctx context.Context, cfg *BrokerConf, factory ConnBrokerFactory, options ...KeeperOption) ConnectionLayer {
+	options = append([]KeeperOption{withConnectionID(cfg.ConnectionID)}, options...)
+
+	return NewTcpConnectionKeeper(ctx, factory, options...)
+}
+
+// TcpDialBrokerFactory 拨号并握手的连接代理工厂
+// 失败时返回nil 由连接层决定是否重试
+func TcpDialBrokerFactory(address string, cfg *BrokerConf) ConnBrokerFactory {
+	dialer := &net.Dialer{Timeout: DefaultDialTimeout}
+	return DialBrokerFactory(address, cfg, dialer.DialContext)
+}
+
+// TlsDialBrokerFactory TLS拨号并握手的连接代理工厂
+func TlsDialBrokerFactory(address string, cfg *BrokerConf, tlsConfig *tls.Config) ConnBrokerFactory {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: DefaultDialTimeout},
+		Config:    tlsConfig,
+	}
+	return DialBrokerFactory(address, cfg, dialer.DialContext)
+}
+
+// DialBrokerFactory 以dial建立连接后握手的连接代理工厂 可接入自定义的传输层
+func DialBrokerFactory(address string, cfg *BrokerConf, dial func(ctx context.Context, network, address string) (net.Conn, error)) ConnBrokerFactory {
+	// 重连共用同一份统计
+	statistics := cfg.Statistics
+	if statistics == nil {
+		statistics = NewFlowStatistics()
+	}
+	return func(ctx context.Context) ConnectionBroker {
+		conn, err := dial(ctx, "tcp", address)
+		if err != nil {
+			gslog.Warn("[TcpClient] dial failed", "address", address, "err", err)
+			return nil
+		}
+		// 握手会回写配置 每次使用副本
+		brokerConf := *cfg
+		brokerConf.Statistics = statistics
+		// 握手限时 对端接受连接后不应答时不会一直阻塞重连
+		deadline := time.Now().Add(DefaultDialTimeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		_ = conn.SetDeadline(deadline)
+		broker, err := ConnectBroker(conn, &brokerConf)
+		if err != nil {
+			statistics.OnHandshakeFailed()
+			gslog.Warn("[TcpClient] connect broker failed", "address", address, "connID", cfg.ConnectionID, "err", err)
+			_ = conn.Close()
+			return nil
+		}
+		_ = conn.SetDeadline(time.Time{})
+
+		return broker
+	}
+}
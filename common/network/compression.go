@@ -0,0 +1,137 @@
+package network
+
+import (
+	"GameServer/utils"
+)
+
+// 负载压缩
+// 客户端在 CONNECT 中声明 CapCompression 及支持的压缩算法 服务端取交集
+// 双方按固定优先级选出同一个算法 PUBLISH 负载超过阈值时压缩并在固定包头中标记 FlagCompressed
+
+var (
+	// DefaultCompressThreshold 默认压缩阈值
+	DefaultCompressThreshold = 512
+	// DefaultMaxDecompressedSize 解压后允许的最大负载 避免解压炸弹
+	DefaultMaxDecompressedSize = 16 * 1024 * 1024
+)
+
+// Compressor 压缩算法
+type Compressor interface {
+	Name() string
+	Capability() Capability
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+// compressors 支持的压缩算法 按优先级排列
+var compressors = []Compressor{
+	lzCompressor{},
+	zlibCompressor{},
+	gzipCompressor{},
+}
+
+// negotiateCompressor 根据协商后的能力选择压缩算法 未开启压缩返回nil
+func negotiateCompressor(capabilities Capability) Compressor {
+	if !capabilities.Has(CapCompression) {
+		return nil
+	}
+	for _, compressor := range compressors {
+		if capabilities.Has(compressor.Capability()) {
+			return compressor
+		}
+	}
+
+	return nil
+}
+
+// compressPublish 压缩超过阈值的负载
+// 返回压缩后的副本 原包可能被重发 不修改原包 压缩无收益时返回原包
+func compressPublish(packet *PublishPacket, compressor Compressor, threshold int) (*PublishPacket, error) {
+	if packet.HasFlag(FlagCompressed) || len(packet.Payload) <= threshold {
+		return packet, nil
+	}
+	payload, err := compressor.Compress(packet.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) >= len(packet.Payload) {
+		return packet, nil
+	}
+	compressed := *packet
+	compressed.Flags |= FlagCompressed
+	compressed.Payload = payload
+
+	return &compressed, nil
+}
+
+// decompressPublish 解压负载并清除压缩标记
+func decompressPublish(packet *PublishPacket, compressor Compressor, maxSize int) error {
+	if !packet.HasFlag(FlagCompressed) {
+		return nil
+	}
+	if compressor == nil {
+		return ErrUnexpectedCompression
+	}
+	payload, err := compressor.Decompress(packet.Payload, maxSize)
+	if err != nil {
+		return err
+	}
+	packet.Flags &^= FlagCompressed
+	packet.Payload = payload
+
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Capability() Capability {
+	return CapCompressGzip
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	return utils.GzipCompress(data)
+}
+
+func (gzipCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	return utils.GzipDecompress(data, maxSize)
+}
+
+type zlibCompressor struct{}
+
+func (zlibCompressor) Name() string {
+	return "zlib"
+}
+
+func (zlibCompressor) Capability() Capability {
+	return CapCompressZlib
+}
+
+func (zlibCompressor) Compress(data []byte) ([]byte, error) {
+	return utils.ZlibCompress(data)
+}
+
+func (zlibCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	return utils.ZlibDecompress(data, maxSize)
+}
+
+type lzCompressor struct{}
+
+func (lzCompressor) Name() string {
+	return "lz"
+}
+
+func (lzCompressor) Capability() Capability {
+	return CapCompressLZ
+}
+
+func (lzCompressor) Compress(data []byte) ([]byte, error) {
+	return utils.LZCompress(data), nil
+}
+
+func (lzCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	return utils.LZDecompress(data, maxSize)
+}
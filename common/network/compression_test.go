@@ -0,0 +1,208 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"GameServer/utils"
+)
+
+// countingConn 统计写入的字节数
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (gs *countingConn) Write(p []byte) (int, error) {
+	n, err := gs.Conn.Write(p)
+	gs.written.Add(int64(n))
+	return n, err
+}
+
+// newBrokerPair 在 net.Pipe 上完成握手 返回客户端和服务端的连接代理
+func newBrokerPair(t *testing.T, clientConf, serverConf *BrokerConf) (ConnectionBroker, ConnectionBroker, *countingConn) {
+	t.Helper()
+	clientSide, serverSide := net.Pipe()
+	counter := &countingConn{Conn: clientSide}
+
+	type result struct {
+		broker ConnectionBroker
+		err    error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		broker, err := AcceptBroker(serverSide, serverConf)
+		accepted <- result{broker: broker, err: err}
+	}()
+	client, err := ConnectBroker(counter, clientConf)
+	if err != nil {
+		t.Fatalf("connect broker: %v", err)
+	}
+	server := <-accepted
+	if server.err != nil {
+		t.Fatalf("accept broker: %v", server.err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.broker.Close()
+	})
+
+	return client, server.broker, counter
+}
+
+// transfer 客户端写入 服务端读取
+func transfer(t *testing.T, client, server ConnectionBroker, packet ControlPacket) (ControlPacket, error) {
+	t.Helper()
+	written := make(chan error, 1)
+	go func() {
+		written <- client.WritePacket(packet)
+	}()
+	received, err := server.ReadPacket()
+	if writeErr := <-written; writeErr != nil {
+		t.Fatalf("write packet: %v", writeErr)
+	}
+
+	return received, err
+}
+
+func compressionConf(connectionID string, capabilities Capability) *BrokerConf {
+	return &BrokerConf{
+		ConnectionID: connectionID,
+		ByteOrder:    binary.BigEndian,
+		Capabilities: capabilities,
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	compressible := []byte(strings.Repeat("inventory item 1001 ", 200))
+	small := []byte("short payload")
+
+	tests := []struct {
+		name       string
+		capability Capability
+		want       string
+	}{
+		{name: "gzip", capability: CapCompressGzip, want: "gzip"},
+		{name: "zlib", capability: CapCompressZlib, want: "zlib"},
+		{name: "lz", capability: CapCompressLZ, want: "lz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capabilities := CapCompression | tt.capability
+			client, server, counter := newBrokerPair(t, compressionConf("c", capabilities), compressionConf("s", capabilities|CapCompressGzip|CapCompressZlib|CapCompressLZ))
+			if got := negotiateCompressor(client.Capabilities()); got == nil || got.Name() != tt.want {
+				t.Fatalf("negotiated compressor %v, want %s", got, tt.want)
+			}
+
+			for _, payload := range [][]byte{compressible, small} {
+				packet := NewControlPacket(Publish).(*PublishPacket)
+				packet.MessageID = 7
+				packet.Payload = payload
+
+				before := counter.written.Load()
+				received, err := transfer(t, client, server, packet)
+				if err != nil {
+					t.Fatalf("read packet: %v", err)
+				}
+				wire := int(counter.written.Load() - before)
+
+				publish, ok := received.(*PublishPacket)
+				if !ok || !bytes.Equal(publish.Payload, payload) || publish.MessageID != 7 {
+					t.Fatalf("received %v, want payload of %d bytes", received, len(payload))
+				}
+				if publish.HasFlag(FlagCompressed) || packet.HasFlag(FlagCompressed) {
+					t.Fatalf("compressed flag leaked")
+				}
+				compressed := wire < len(payload)
+				if want := len(payload) > DefaultCompressThreshold; compressed != want {
+					t.Fatalf("payload %d bytes sent as %d bytes, compressed %v want %v", len(payload), wire, compressed, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressionRejectsBadPayload(t *testing.T) {
+	oversized := make([]byte, DefaultMaxDecompressedSize+1)
+	for _, compressor := range compressors {
+		t.Run(compressor.Name(), func(t *testing.T) {
+			bomb, err := compressor.Compress(oversized)
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			valid, err := compressor.Compress([]byte(strings.Repeat("payload ", 100)))
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			corrupt := append([]byte{}, valid...)
+			corrupt = corrupt[:len(corrupt)/2]
+
+			tests := []struct {
+				name    string
+				payload []byte
+				wantErr error
+			}{
+				{name: "oversized", payload: bomb, wantErr: utils.ErrDecompressedTooLarge},
+				{name: "corrupt", payload: corrupt},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					capabilities := CapCompression | compressor.Capability()
+					client, server, _ := newBrokerPair(t, compressionConf("c", capabilities), compressionConf("s", capabilities))
+
+					// 已标记压缩的包原样发出
+					packet := NewControlPacket(Publish).(*PublishPacket)
+					packet.Flags |= FlagCompressed
+					packet.Payload = tt.payload
+					_, err := transfer(t, client, server, packet)
+					if err == nil {
+						t.Fatalf("read packet succeeded")
+					}
+					if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+						t.Fatalf("read packet: %v, want %v", err, tt.wantErr)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestCompressionWithoutNegotiation(t *testing.T) {
+	client, server, _ := newBrokerPair(t, compressionConf("c", 0), compressionConf("s", CapCompression|CapCompressLZ))
+
+	packet := NewControlPacket(Publish).(*PublishPacket)
+	packet.Flags |= FlagCompressed
+	packet.Payload = utils.LZCompress([]byte("payload"))
+	if _, err := transfer(t, client, server, packet); !errors.Is(err, ErrUnexpectedCompression) {
+		t.Fatalf("read packet: %v, want %v", err, ErrUnexpectedCompression)
+	}
+}
+
+func TestCompressPublishKeepsOriginal(t *testing.T) {
+	payload := []byte(strings.Repeat("retransmitted ", 100))
+	random := make([]byte, 2048)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, compressor := range compressors {
+		packet := &PublishPacket{FixedHeader: FixedHeader{PacketType: Publish}, MessageID: 1, Payload: payload}
+		compressed, err := compressPublish(packet, compressor, DefaultCompressThreshold)
+		if err != nil {
+			t.Fatalf("%s compress: %v", compressor.Name(), err)
+		}
+		if compressed == packet || packet.HasFlag(FlagCompressed) || !bytes.Equal(packet.Payload, payload) {
+			t.Fatalf("%s modified the original packet", compressor.Name())
+		}
+
+		// 压缩无收益时发送原包
+		incompressible := &PublishPacket{FixedHeader: FixedHeader{PacketType: Publish}, Payload: random}
+		if got, err := compressPublish(incompressible, compressor, DefaultCompressThreshold); err != nil || got != incompressible {
+			t.Fatalf("%s compressed random payload: %v", compressor.Name(), err)
+		}
+	}
+}
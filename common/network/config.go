@@ -9,12 +9,32 @@ import (
 
 type BrokerConf struct {
 	ConnectionID      string
-	KeepaliveInterval int
-	Version           int
+	KeepaliveInterval int        // 心跳间隔 毫秒 <=0 不发送心跳
+	Version           int        // 协商后的协议版本
+	MinVersion        int        // 支持的最低协议版本
+	MaxVersion        int        // 支持的最高协议版本 <=0 不限制
+	Capabilities      Capability // 期望的能力 握手后为协商结果
 	WriteTimeout      time.Duration
 	ReadTimeout       time.Duration
 	ByteOrder         binary.ByteOrder
 	OnCloseCallback   OnConnectionCloseCallback
+	AuthMethod        string         // 客户端鉴权方式
+	AuthData          []byte         // 客户端鉴权数据
+	Authenticator     Authenticator  // 服务端鉴权器 nil时不鉴权
+	Principal         *Principal     // 服务端鉴权通过的身份信息
+	CompressThreshold int            // 负载超过该大小时压缩 <=0 使用默认值
+	Statistics        FlowStatistics // 连接流量统计 nil时自动创建
+	MaxPacketSize     int            // 最大包体长度 超过时关闭连接 <=0 使用默认值
+	RateLimit         *RateLimitConf // 收包限速 nil不限速
+	FrameCodec        FrameCodec     // 帧格式 nil使用 VarintFrameCodec 双方需一致
+}
+
+// maxPacketSize 最大包体长度
+func maxPacketSize(cfg *BrokerConf) int {
+	if cfg.MaxPacketSize <= 0 {
+		return DefaultMaxPacketSize
+	}
+	return cfg.MaxPacketSize
 }
 
 func IsNetTimeout(err error) bool {
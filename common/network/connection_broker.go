@@ -1,9 +1,12 @@
 package network
 
 import (
+	"GameServer/common/pool"
 	"GameServer/gslog"
+	"crypto/ecdh"
 	"encoding/binary"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -14,12 +17,22 @@ func ConnectBroker(conn net.Conn, cfg *BrokerConf) (ConnectionBroker, error) {
 	packet := NewControlPacket(Connect).(*ConnectPacket)
 	packet.Keepalive = cfg.KeepaliveInterval
 	packet.ClientIdentifier = cfg.ConnectionID
-	packet.ProtocolVersion = cfg.Version
+	packet.ProtocolVersion = requestVersion(cfg)
+	packet.Capabilities = cfg.Capabilities
+	packet.AuthMethod = cfg.AuthMethod
+	packet.AuthData = cfg.AuthData
+	var exchangeKey *ecdh.PrivateKey
+	if cfg.Capabilities.Has(CapEncryption) {
+		if exchangeKey, err = newExchangeKey(); err != nil {
+			return nil, err
+		}
+		packet.PublicKey = exchangeKey.PublicKey().Bytes()
+	}
 
 	if cfg.WriteTimeout > 0 {
 		_ = conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
 	}
-	_, err = packet.WriteTo(conn, cfg.ByteOrder)
+	_, err = WritePacketWithCodec(conn, packet, cfg.ByteOrder, frameCodec(cfg))
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +43,7 @@ func ConnectBroker(conn net.Conn, cfg *BrokerConf) (ConnectionBroker, error) {
 	if cfg.ReadTimeout > 0 {
 		_ = conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
 	}
-	ackPacket, err := ReadPacket(conn, cfg.ByteOrder)
+	ackPacket, err := ReadPacketWithCodec(conn, cfg.ByteOrder, maxPacketSize(cfg), frameCodec(cfg))
 	if err != nil {
 		return nil, err
 	}
@@ -38,18 +51,40 @@ func ConnectBroker(conn net.Conn, cfg *BrokerConf) (ConnectionBroker, error) {
 		_ = conn.SetReadDeadline(time.Time{})
 	}
 	if ret := ackPacket.Validate(); ret != Accepted {
-		return nil, RetCodeErrors[ret]
+		return nil, RetCodeError(ret)
 	}
 	connectAck, ok := ackPacket.(*ConnectAckPacket)
 	if !ok {
 		gslog.Warn("read ack packet not connect ack", "ack", ackPacket.String())
-		return nil, err
+		return nil, ErrInvalidPacketType
 	}
 	if connectAck.ReturnCode != Accepted {
-		return nil, RetCodeErrors[connectAck.ReturnCode]
+		return nil, RetCodeError(connectAck.ReturnCode)
+	}
+	// 服务端协商的版本需要在客户端支持范围内
+	if connectAck.ProtocolVersion < cfg.MinVersion || connectAck.ProtocolVersion > packet.ProtocolVersion {
+		gslog.Warn("negotiated protocol version not supported", "version", connectAck.ProtocolVersion,
+			"minVersion", cfg.MinVersion, "requestVersion", packet.ProtocolVersion)
+		return nil, ErrBadProtocolVersion
+	}
+	// 请求加密时不接受明文连接
+	if exchangeKey != nil && !connectAck.Capabilities.Has(CapEncryption) {
+		gslog.Warn("encryption requested but not accepted by server", "capabilities", connectAck.Capabilities.String())
+		return nil, ErrKeyExchangeFailed
 	}
+	cfg.Version = connectAck.ProtocolVersion
+	cfg.Capabilities = connectAck.Capabilities
 
-	return NewConnectionBroker(conn, cfg), nil
+	broker := newConnBroker(conn, cfg)
+	if exchangeKey != nil {
+		broker.cipher, err = newPacketCipher(exchangeKey, connectAck.PublicKey, packet.PublicKey, connectAck.PublicKey, true)
+		if err != nil {
+			gslog.Warn("key exchange failed", "err", err)
+			return nil, ErrKeyExchangeFailed
+		}
+	}
+
+	return broker, nil
 }
 
 // AcceptBroker 接受客户端连接
@@ -58,7 +93,7 @@ func AcceptBroker(conn net.Conn, cfg *BrokerConf) (ConnectionBroker, error) {
 	if cfg.ReadTimeout > 0 {
 		_ = conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
 	}
-	packet, err := ReadPacket(conn, cfg.ByteOrder)
+	packet, err := ReadPacketWithCodec(conn, cfg.ByteOrder, maxPacketSize(cfg), frameCodec(cfg))
 	if err != nil {
 		return nil, err
 	}
@@ -67,50 +102,153 @@ func AcceptBroker(conn net.Conn, cfg *BrokerConf) (ConnectionBroker, error) {
 	}
 	connect, ok := packet.(*ConnectPacket)
 	if !ok {
-		return nil, err
+		return nil, ErrInvalidPacketType
+	}
+	if ret := connect.Validate(); ret != Accepted {
+		_ = writeConnectAck(conn, cfg, ret, nil)
+		return nil, RetCodeError(ret)
+	}
+	version, ok := negotiateVersion(cfg, connect.ProtocolVersion)
+	if !ok {
+		gslog.Warn("client protocol version not supported", "clientID", connect.ClientIdentifier, "version", connect.ProtocolVersion,
+			"minVersion", cfg.MinVersion, "maxVersion", cfg.MaxVersion)
+		_ = writeConnectAck(conn, cfg, RefusedBadProtocolVersion, nil)
+		return nil, ErrBadProtocolVersion
+	}
+	if cfg.Authenticator != nil {
+		principal, err := cfg.Authenticator.Authenticate(connect)
+		if err != nil {
+			gslog.Warn("client authenticate failed", "clientID", connect.ClientIdentifier, "authMethod", connect.AuthMethod, "err", err)
+			ret := authReturnCode(err)
+			_ = writeConnectAck(conn, cfg, ret, nil)
+			return nil, RetCodeError(ret)
+		}
+		cfg.Principal = principal
+	}
+	cfg.Version = version
+	cfg.Capabilities &= connect.Capabilities
+	var sessionCipher *packetCipher
+	var publicKey []byte
+	if cfg.Capabilities.Has(CapEncryption) {
+		sessionCipher, publicKey, err = acceptKeyExchange(connect.PublicKey)
+		if err != nil {
+			gslog.Warn("client key exchange failed", "clientID", connect.ClientIdentifier, "err", err)
+			_ = writeConnectAck(conn, cfg, RefusedKeyExchangeFailed, nil)
+			return nil, ErrKeyExchangeFailed
+		}
 	}
-	cfg.Version = connect.ProtocolVersion
 	cfg.ConnectionID = connect.ClientIdentifier
 	cfg.KeepaliveInterval = connect.Keepalive
 
 	// send connect ack
+	if err = writeConnectAck(conn, cfg, Accepted, publicKey); err != nil {
+		return nil, err
+	}
+
+	broker := newConnBroker(conn, cfg)
+	broker.cipher = sessionCipher
+
+	return broker, nil
+}
+
+// RefuseBroker 拒绝客户端连接
+// 读取连接请求后回复指定的拒绝码并关闭连接
+func RefuseBroker(conn net.Conn, cfg *BrokerConf, returnCode int) error {
+	defer conn.Close()
+
+	if cfg.ReadTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
+	}
+	packet, err := ReadPacketWithCodec(conn, cfg.ByteOrder, maxPacketSize(cfg), frameCodec(cfg))
+	if err != nil {
+		return err
+	}
+	if _, ok := packet.(*ConnectPacket); !ok {
+		return ErrInvalidPacketType
+	}
+
+	return writeConnectAck(conn, cfg, returnCode, nil)
+}
+
+// writeConnectAck 回复连接确认
+// 接受时携带协商结果和密钥交换公钥 拒绝时携带服务端支持的最高版本
+func writeConnectAck(conn net.Conn, cfg *BrokerConf, returnCode int, publicKey []byte) error {
 	connectAck := NewControlPacket(ConnectAck).(*ConnectAckPacket)
-	connectAck.ReturnCode = Accepted
+	connectAck.ReturnCode = returnCode
+	if returnCode == Accepted {
+		connectAck.ProtocolVersion = cfg.Version
+		connectAck.Capabilities = cfg.Capabilities
+		connectAck.PublicKey = publicKey
+	} else {
+		connectAck.ProtocolVersion = cfg.MaxVersion
+	}
 	if cfg.WriteTimeout > 0 {
 		_ = conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
 	}
-	_, err = connectAck.WriteTo(conn, cfg.ByteOrder)
+	_, err := WritePacketWithCodec(conn, connectAck, cfg.ByteOrder, frameCodec(cfg))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if cfg.WriteTimeout > 0 {
 		_ = conn.SetWriteDeadline(time.Time{})
 	}
 
-	return NewConnectionBroker(conn, cfg), nil
+	return nil
 }
 
 type connBroker struct {
-	conn          net.Conn
-	connectionID  string
-	version       int
-	keepalive     time.Duration
-	readTimeout   time.Duration
-	writeTimeout  time.Duration
-	byteOrder     binary.ByteOrder
-	closeCallback OnConnectionCloseCallback
+	conn              net.Conn
+	connectionID      string
+	version           int
+	capabilities      Capability
+	principal         *Principal
+	compressor        Compressor // 协商的压缩算法 nil不压缩
+	compressThreshold int
+	maxPacketSize     int
+	codec             FrameCodec
+	limiter           *rateLimiter  // 收包限速 nil不限速 仅由读协程访问
+	cipher            *packetCipher // 协议内加密状态 nil为明文
+	writeLock         sync.Mutex    // 加密包序号需与写入顺序一致
+	statistics        FlowStatistics
+	keepalive         time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	byteOrder         binary.ByteOrder
+	closeCallback     OnConnectionCloseCallback
+	closeOnce         sync.Once // 关闭回调只执行一次
 }
 
 func NewConnectionBroker(conn net.Conn, cfg *BrokerConf) ConnectionBroker {
+	return newConnBroker(conn, cfg)
+}
+
+func newConnBroker(conn net.Conn, cfg *BrokerConf) *connBroker {
+	compressThreshold := cfg.CompressThreshold
+	if compressThreshold <= 0 {
+		compressThreshold = DefaultCompressThreshold
+	}
+	statistics := cfg.Statistics
+	if statistics == nil {
+		statistics = NewFlowStatistics()
+	}
+
 	return &connBroker{
-		conn:          conn,
-		connectionID:  cfg.ConnectionID,
-		version:       cfg.Version,
-		keepalive:     time.Duration(cfg.KeepaliveInterval),
-		readTimeout:   cfg.ReadTimeout,
-		writeTimeout:  cfg.WriteTimeout,
-		byteOrder:     cfg.ByteOrder,
-		closeCallback: cfg.OnCloseCallback,
+		conn:              conn,
+		connectionID:      cfg.ConnectionID,
+		version:           cfg.Version,
+		capabilities:      cfg.Capabilities,
+		principal:         cfg.Principal,
+		compressor:        negotiateCompressor(cfg.Capabilities),
+		compressThreshold: compressThreshold,
+		maxPacketSize:     maxPacketSize(cfg),
+		codec:             frameCodec(cfg),
+		limiter:           newRateLimiter(cfg.RateLimit),
+		statistics:        statistics,
+		keepalive:         time.Duration(cfg.KeepaliveInterval) * time.Millisecond,
+		readTimeout:       cfg.ReadTimeout,
+		writeTimeout:      cfg.WriteTimeout,
+		byteOrder:         cfg.ByteOrder,
+		closeCallback:     cfg.OnCloseCallback,
 	}
 }
 
@@ -118,40 +256,280 @@ func (gs *connBroker) ConnectionID() string {
 	return gs.connectionID
 }
 
+func (gs *connBroker) Version() int {
+	return gs.version
+}
+
+func (gs *connBroker) Capabilities() Capability {
+	return gs.capabilities
+}
+
+func (gs *connBroker) Principal() *Principal {
+	return gs.principal
+}
+
+func (gs *connBroker) Statistics() FlowStatistics {
+	return gs.statistics
+}
+
 func (gs *connBroker) Keepalive() time.Duration {
 	return gs.keepalive
 }
 
 func (gs *connBroker) WritePacket(packet ControlPacket) error {
+	return gs.WritePackets([]ControlPacket{packet})
+}
+
+// frameSegment 批量写入中单个包的帧
+// shared 非nil时为预编码包共享的帧 否则为写缓冲区中的区间
+type frameSegment struct {
+	packetType PacketType
+	shared     []byte
+	start, end int
+}
+
+// frame 帧数据 data为写缓冲区数据
+func (gs frameSegment) frame(data []byte) []byte {
+	if gs.shared != nil {
+		return gs.shared
+	}
+	return data[gs.start:gs.end]
+}
+
+// WritePackets 批量写入 所有包编码到同一个池化缓冲区后通过 net.Buffers 一次写出
+// TCP连接合并为一次writev 其他连接每个包仍然是一次独立的写入
+// 写入失败时无法确定哪些包已经写出
+func (gs *connBroker) WritePackets(packets []ControlPacket) error {
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	if gs.cipher != nil {
+		// 加密包序号需与写入顺序一致 编码和写入都在锁内完成
+		gs.writeLock.Lock()
+		defer gs.writeLock.Unlock()
+	}
+	segments := make([]frameSegment, 0, len(packets))
+	for _, packet := range packets {
+		segment, err := gs.appendFrame(buf, packet)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, segment)
+	}
+	data := buf.Bytes()
+	buffers := make(net.Buffers, 0, len(segments))
+	for _, segment := range segments {
+		buffers = append(buffers, segment.frame(data))
+	}
+
 	if gs.writeTimeout > 0 {
 		_ = gs.conn.SetWriteDeadline(time.Now().Add(gs.writeTimeout))
 	}
-	_, err := packet.WriteTo(gs.conn, gs.byteOrder)
-	if err != nil {
+	if _, err := buffers.WriteTo(gs.conn); err != nil {
+		if IsNetTimeout(err) {
+			gs.statistics.OnWriteTimeout()
+		}
 		return err
 	}
 	if gs.writeTimeout > 0 {
 		_ = gs.conn.SetWriteDeadline(time.Time{})
 	}
+	for _, segment := range segments {
+		gs.statistics.OnPacketWritten(segment.packetType, len(segment.frame(data)))
+	}
 
 	return nil
 }
 
+// appendFrame 将包按连接的压缩和加密设置编码到buf
+func (gs *connBroker) appendFrame(buf *pool.Buffer, packet ControlPacket) (frameSegment, error) {
+	if encoded, ok := packet.(*EncodedPacket); ok {
+		if gs.cipher == nil && encoded.order == gs.byteOrder && encoded.codec == gs.codec && (gs.compressor == nil || !isPublish(encoded)) {
+			return frameSegment{packetType: encoded.Header().PacketType, shared: encoded.frame}, nil
+		}
+		packet = encoded.packet
+	}
+	if publish, ok := packet.(*PublishPacket); ok && gs.compressor != nil {
+		compressed, err := compressPublish(publish, gs.compressor, gs.compressThreshold)
+		if err != nil {
+			return frameSegment{}, err
+		}
+		packet = compressed
+	}
+
+	segment := frameSegment{packetType: packet.Header().PacketType}
+	if gs.cipher == nil {
+		frame, err := encodePacket(buf, packet, gs.byteOrder, gs.codec)
+		if err != nil {
+			return frameSegment{}, err
+		}
+		segment.end = buf.Size()
+		segment.start = segment.end - len(frame)
+		return segment, nil
+	}
+
+	sealed, header, err := gs.sealPacket(packet)
+	if err != nil {
+		return frameSegment{}, err
+	}
+	var fixed [maxFrameHeaderSize]byte
+	segment.start = buf.Size()
+	buf.AppendBytes(fixed[:gs.codec.PutHeader(fixed[:], &header, sealed, gs.byteOrder)])
+	buf.AppendBytes(sealed)
+	segment.end = buf.Size()
+
+	return segment, nil
+}
+
+// ReadPacket 读取单个包 超出限速的包按配置丢弃、延迟或断开
 func (gs *connBroker) ReadPacket() (ControlPacket, error) {
+	for {
+		packet, err := gs.readPacket()
+		if err != nil || gs.limiter == nil {
+			return packet, err
+		}
+		pass, err := gs.limit(packet.Header().PacketType)
+		if err != nil {
+			return nil, err
+		}
+		if pass {
+			return packet, nil
+		}
+	}
+}
+
+// limit 收包限速 返回false时丢弃该包
+func (gs *connBroker) limit(packetType PacketType) (bool, error) {
+	if packetType == DisConnect {
+		return true, nil
+	}
+	delay := gs.limiter.wait(packetType, time.Now())
+	if delay == 0 {
+		gs.limiter.take(packetType)
+		return true, nil
+	}
+
+	action := gs.limiter.action
+	if action == RateLimitDelay && delay > gs.limiter.maxDelay {
+		action = RateLimitDrop
+	}
+	gs.statistics.OnRateLimited(packetType, action)
+
+	switch action {
+	case RateLimitDelay:
+		gslog.Debug("[connBroker] rate limit exceeded, delay read", "connID", gs.connectionID, "packet", packetType.String(), "delay", delay)
+		time.Sleep(delay)
+		gs.limiter.wait(packetType, time.Now())
+		gs.limiter.take(packetType)
+		return true, nil
+	case RateLimitDisconnect:
+		gslog.Warn("[connBroker] rate limit exceeded, disconnect", "connID", gs.connectionID, "remoteAddr", gs.RemoteAddr(), "packet", packetType.String())
+		// 由连接层在写协程中发送 DISCONNECT 读协程不直接写连接
+		return false, &DisconnectError{Reason: DisconnectRateLimited, Err: ErrRateLimitExceeded}
+	default:
+		gslog.Debug("[connBroker] rate limit exceeded, drop packet", "connID", gs.connectionID, "packet", packetType.String(), "wait", delay)
+		return false, nil
+	}
+}
+
+func (gs *connBroker) readPacket() (ControlPacket, error) {
 	if gs.readTimeout > 0 {
 		_ = gs.conn.SetReadDeadline(time.Now().Add(gs.readTimeout))
 	}
-	packet, err := ReadPacket(gs.conn, gs.byteOrder)
+	var packet ControlPacket
+	var size int
+	var err error
+	if gs.cipher != nil {
+		packet, size, err = gs.readEncrypted()
+	} else {
+		packet, err = ReadPacketWithCodec(gs.conn, gs.byteOrder, gs.maxPacketSize, gs.codec)
+		if err == nil {
+			size = gs.codec.FrameSize(packet.Header().RemainLength)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 	if gs.readTimeout > 0 {
 		_ = gs.conn.SetReadDeadline(time.Time{})
 	}
+	gs.statistics.OnPacketRead(packet.Header().PacketType, size)
+	if publish, ok := packet.(*PublishPacket); ok {
+		if err = decompressPublish(publish, gs.compressor, DefaultMaxDecompressedSize); err != nil {
+			return nil, err
+		}
+	}
 
 	return packet, nil
 }
 
+// WriteUnreliable 通过不可靠有序通道发送 仅可靠UDP连接可用
+// 协议内加密依赖包序号 丢包会导致后续包无法解密 因此加密连接不支持
+func (gs *connBroker) WriteUnreliable(packet ControlPacket) error {
+	conn, ok := gs.conn.(interface{ WriteUnreliable(p []byte) error })
+	if !ok || gs.cipher != nil {
+		return ErrUnreliableNotSupported
+	}
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	segment, err := gs.appendFrame(buf, packet)
+	if err != nil {
+		return err
+	}
+	data := segment.frame(buf.Bytes())
+	// 不可靠通道同步编码发送 返回后即可归还缓冲区
+	if err = conn.WriteUnreliable(data); err != nil {
+		return err
+	}
+	gs.statistics.OnPacketWritten(segment.packetType, len(data))
+
+	return nil
+}
+
+// sealPacket 加密包体 返回密文和加密后的固定包头 调用方持有写锁
+func (gs *connBroker) sealPacket(packet ControlPacket) ([]byte, FixedHeader, error) {
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	// 只取包体 帧头在加密后按连接的帧格式写入
+	frame, err := encodePacket(buf, packet, gs.byteOrder, VarintFrameCodec)
+	if err != nil {
+		return nil, FixedHeader{}, err
+	}
+	header := *packet.Header()
+	body := frame[len(frame)-header.RemainLength:]
+	header.Flags |= FlagEncrypted
+	sealed := gs.cipher.seal(byte(header.PacketType)|header.Flags, body)
+	header.RemainLength = len(sealed)
+	if header.RemainLength > MaxRemainLength {
+		return nil, FixedHeader{}, ErrPacketTooLarge
+	}
+
+	return sealed, header, nil
+}
+
+// readEncrypted 读取并解密包体 拒绝明文包 同时返回线路上的字节数
+func (gs *connBroker) readEncrypted() (ControlPacket, int, error) {
+	header, body, err := gs.codec.ReadFrame(gs.conn, gs.byteOrder, gs.maxPacketSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := gs.codec.FrameSize(header.RemainLength)
+	if !header.HasFlag(FlagEncrypted) {
+		return nil, size, ErrUnencryptedPacket
+	}
+	plain, err := gs.cipher.open(byte(header.PacketType)|header.Flags, body)
+	if err != nil {
+		return nil, size, err
+	}
+	header.Flags &^= FlagEncrypted
+	header.RemainLength = len(plain)
+	packet, err := decodePacket(header, plain, gs.byteOrder)
+
+	return packet, size, err
+}
+
 func (gs *connBroker) LocalAddr() string {
 	return gs.conn.LocalAddr().String()
 }
@@ -162,9 +540,12 @@ func (gs *connBroker) RemoteAddr() string {
 
 func (gs *connBroker) Close() error {
 	err := gs.conn.Close()
-	if err == nil && gs.closeCallback != nil {
-		gs.closeCallback(gs.connectionID)
-	}
+	// 可靠UDP会话可能已因对端关闭或链路失效自行关闭 此时返回错误 关闭回调仍需执行
+	gs.closeOnce.Do(func() {
+		if gs.closeCallback != nil {
+			gs.closeCallback(gs.connectionID)
+		}
+	})
 
 	return err
 }
@@ -5,12 +5,19 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	// TimeoutWaitInterval 读写超时等待时间
 	TimeoutWaitInterval = 20 * time.Millisecond
+	// DefaultHeartbeatMissBudget 默认允许连续丢失的心跳确认数
+	DefaultHeartbeatMissBudget = 3
+	// MaxWriteBatchSize 写协程单次合并写入的最大包数
+	MaxWriteBatchSize = 64
+	// DefaultCloseTimeout Close 等待发送队列写完的默认时间
+	DefaultCloseTimeout = 5 * time.Second
 
 	ErrOperationCancel       = errors.New("operation cancelled")
 	ErrConnectionLayerClosed = errors.New("connection layer closed")
@@ -18,14 +25,72 @@ var (
 
 type ConnBrokerFactory func(ctx context.Context) ConnectionBroker
 
+// ConnectionState 连接状态
+type ConnectionState int32
+
+const (
+	StateConnecting   ConnectionState = iota // 首次连接中
+	StateConnected                           // 已连接
+	StateReconnecting                        // 断线重连中
+	StateClosed                              // 已关闭
+)
+
+var connectionStateNames = []string{
+	"CONNECTING",
+	"CONNECTED",
+	"RECONNECTING",
+	"CLOSED",
+}
+
+func (gs ConnectionState) String() string {
+	if gs < 0 || int(gs) >= len(connectionStateNames) {
+		return "UNKNOWN"
+	}
+	return connectionStateNames[gs]
+}
+
+// reconnectPolicy 重连策略
+type reconnectPolicy struct {
+	backoff    Backoff
+	maxRetries int
+}
+
 type TcpConnectionKeeper struct {
 	connID string
 
-	readChan  chan ControlPacket
-	writeChan chan ControlPacket
-	stopChan  chan struct{}
-
-	isClosed bool
+	inbound  *packetQueue // 收包队列
+	outbound *packetQueue // 发包队列
+	stopChan chan struct{}
+
+	isClosed   bool
+	state      ConnectionState
+	principal  *Principal
+	broker     ConnectionBroker // 当前连接代理 断线期间为nil
+	statistics FlowStatistics   // 流量统计 取自连接代理 重连后保留
+
+	reconnect           *reconnectPolicy               // 重连策略 nil时断线立即重新获取一次连接代理
+	inboundConf         QueueConf                      // 收包队列配置
+	outboundConf        QueueConf                      // 发包队列配置
+	watermarkCallbacks  []OnQueueHighWatermarkCallback // 队列高水位回调
+	stateCallbacks      []OnConnectionStateCallback    // 状态变化回调
+	disconnectCallbacks []OnDisconnectCallback         // 收到对端 DISCONNECT 回调
+	pendingPackets      []ControlPacket                // 发送失败待重发的包 仅由写协程访问
+	reliableConf        *ReliableConf                  // 可靠投递配置 nil时不启用
+	reliable            *reliableSender                // 可靠投递发送端
+	duplicates          *duplicateFilter               // 接收去重窗口
+	missBudget          int                            // 连续未确认的心跳数达到该值时判定连接失效
+	capture             *CaptureWriter                 // 抓包 nil时不记录
+
+	epoch             time.Time     // 心跳时间戳基准 使用单调时钟
+	keepalive         atomic.Int64  // 当前心跳间隔
+	keepaliveAnnounce atomic.Int64  // 待通知对端的心跳间隔
+	keepaliveChanged  chan struct{} // 心跳间隔变化通知
+	srtt              atomic.Int64  // 平滑往返时间
+	jitter            atomic.Int64  // 往返时间抖动
+
+	disconnectSent chan struct{} // 本端 DISCONNECT 已写出
+	disconnectOnce sync.Once
+	reconnectAfter atomic.Int64 // 对端建议的重连等待时间 下次重连前生效
 
 	ctx       context.Context
 	ctxCancel context.CancelFunc
@@ -33,49 +98,186 @@ type TcpConnectionKeeper struct {
 	lock sync.RWMutex
 }
 
-func NewTcpConnectionKeeper(ctx context.Context, factory ConnBrokerFactory) ConnectionLayer {
+func NewTcpConnectionKeeper(ctx context.Context, factory ConnBrokerFactory, options ...KeeperOption) ConnectionLayer {
+	return newTcpConnectionKeeper(ctx, factory, options...)
+}
+
+func newTcpConnectionKeeper(ctx context.Context, factory ConnBrokerFactory, options ...KeeperOption) *TcpConnectionKeeper {
 	instance := &TcpConnectionKeeper{
-		readChan:  make(chan ControlPacket),
-		writeChan: make(chan ControlPacket),
-		stopChan:  make(chan struct{}),
+		stopChan:         make(chan struct{}),
+		state:            StateConnecting,
+		missBudget:       DefaultHeartbeatMissBudget,
+		epoch:            time.Now(),
+		keepaliveChanged: make(chan struct{}, 1),
+		disconnectSent:   make(chan struct{}),
 	}
 	instance.ctx, instance.ctxCancel = context.WithCancel(ctx)
 
+	for _, option := range options {
+		option.apply(instance)
+	}
+	instance.inbound = instance.newQueue(QueueInbound, instance.inboundConf)
+	instance.outbound = instance.newQueue(QueueOutbound, instance.outboundConf)
+	if instance.duplicates == nil {
+		instance.duplicates = newDuplicateFilter(DefaultDuplicateFilterSize)
+	}
+	if instance.reliableConf != nil {
+		instance.reliable = newReliableSender(instance, *instance.reliableConf)
+		instance.outbound.onDrop = func(packet ControlPacket) {
+			if p, ok := packet.(*PublishPacket); ok && p.MessageID != 0 {
+				instance.reliable.fail(p.MessageID)
+			}
+		}
+	}
+
+	if instance.capture != nil {
+		factory = CaptureBrokerFactory(factory, instance.capture)
+	}
 	instance.loop(factory)
 
 	return instance
 }
 
+func (gs *TcpConnectionKeeper) newQueue(direction QueueDirection, conf QueueConf) *packetQueue {
+	queue := newPacketQueue(direction, conf)
+	if len(gs.watermarkCallbacks) > 0 {
+		queue.onWatermark = func(direction QueueDirection, depth int) {
+			gslog.Warn("[TcpConnectionKeeper] queue reach high watermark", "connID", gs.connID, "direction", direction.String(), "depth", depth)
+			for _, callback := range gs.watermarkCallbacks {
+				callback(gs.connID, direction, depth)
+			}
+		}
+	}
+
+	return queue
+}
+
 func (gs *TcpConnectionKeeper) loop(factory ConnBrokerFactory) {
 	broker := factory(gs.ctx)
-	if broker == nil {
+	if broker == nil && (gs.reconnect == nil || gs.connID == "") {
 		gslog.Error("[TcpConnectionKeeper] create connection broker failed...")
+		gs.ctxCancel()
+		gs.stopReliable()
+		gs.setState(StateClosed)
+		close(gs.stopChan)
+		close(gs.inbound.ch)
 		return
 	}
-	gs.connID = broker.ConnectionID()
+	if broker != nil {
+		gs.connID = broker.ConnectionID()
+		// 返回前置为已连接 调用方拿到连接层时即可观察到已连接状态
+		gs.setState(StateConnected)
+	}
 
 	go func() {
 		defer func() {
 			gs.ctxCancel()
+			gs.stopReliable()
+			gs.setState(StateClosed)
 			close(gs.stopChan)
-			close(gs.writeChan)
-			close(gs.readChan)
+			// 发包队列不关闭 写入方通过连接上下文感知关闭 避免向已关闭的通道发送
+			close(gs.inbound.ch)
 		}()
 
-		for broker != nil {
-			gs.startBroker(broker)
-			if gs.IsClosed() {
+		for {
+			if broker != nil {
+				gs.setState(StateConnected)
+				gs.startBroker(broker)
+			}
+			if gs.IsClosed() || gs.ctx.Err() != nil {
 				break
 			}
-			broker = factory(gs.ctx)
+			gs.setState(StateReconnecting)
+			broker = gs.reconnectBroker(factory)
+			if broker == nil {
+				break
+			}
+			broker.Statistics().OnReconnect()
 		}
 	}()
 }
 
+// reconnectBroker 断线后重新获取连接代理
+func (gs *TcpConnectionKeeper) reconnectBroker(factory ConnBrokerFactory) ConnectionBroker {
+	// 对端停机时建议的等待时间
+	if hint := time.Duration(gs.reconnectAfter.Swap(0)); hint > 0 {
+		gslog.Info("[TcpConnectionKeeper] wait before reconnect as peer suggested", "connID", gs.connID, "reconnectAfter", hint)
+		timer := time.NewTimer(hint)
+		select {
+		case <-gs.ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+	if gs.reconnect == nil {
+		return factory(gs.ctx)
+	}
+
+	for attempt := 0; gs.reconnect.maxRetries <= 0 || attempt < gs.reconnect.maxRetries; attempt++ {
+		delay := gs.reconnect.backoff.Next(attempt)
+		timer := time.NewTimer(delay)
+		select {
+		case <-gs.ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		broker := factory(gs.ctx)
+		if broker != nil {
+			gslog.Info("[TcpConnectionKeeper] reconnect success", "connID", gs.connID, "attempt", attempt+1)
+			return broker
+		}
+		gslog.Warn("[TcpConnectionKeeper] reconnect failed", "connID", gs.connID, "attempt", attempt+1, "delay", delay)
+	}
+	gslog.Error("[TcpConnectionKeeper] reconnect retries exhausted", "connID", gs.connID, "maxRetries", gs.reconnect.maxRetries)
+
+	return nil
+}
+
+// State 当前连接状态
+func (gs *TcpConnectionKeeper) State() ConnectionState {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+	return gs.state
+}
+
+func (gs *TcpConnectionKeeper) setState(state ConnectionState) {
+	gs.lock.Lock()
+	if gs.state == state {
+		gs.lock.Unlock()
+		return
+	}
+	gs.state = state
+	connID := gs.connID
+	gs.lock.Unlock()
+
+	gslog.Debug("[TcpConnectionKeeper] connection state changed", "connID", connID, "state", state.String())
+	for _, callback := range gs.stateCallbacks {
+		callback(connID, state)
+	}
+}
+
+func (gs *TcpConnectionKeeper) stopReliable() {
+	if gs.reliable != nil {
+		gs.reliable.stop()
+	}
+}
+
+// InflightCount 已发送未确认的包数量
+func (gs *TcpConnectionKeeper) InflightCount() int {
+	if gs.reliable == nil {
+		return 0
+	}
+	return gs.reliable.inflightCount()
+}
+
+// IsClosed 已关闭 被踢或重连结束后同样视为关闭
 func (gs *TcpConnectionKeeper) IsClosed() bool {
 	gs.lock.RLock()
 	defer gs.lock.RUnlock()
-	return gs.isClosed
+	return gs.isClosed || gs.state == StateClosed
 }
 
 func (gs *TcpConnectionKeeper) startBroker(broker ConnectionBroker) {
@@ -86,12 +288,30 @@ func (gs *TcpConnectionKeeper) startBroker(broker ConnectionBroker) {
 		gslog.Error("[tcpConnectionKeeper] broker connection identify error", "cid", cid)
 		return
 	}
+	gs.lock.Lock()
+	gs.principal = broker.Principal()
+	gs.broker = broker
+	gs.statistics = broker.Statistics()
+	gs.lock.Unlock()
+	defer func() {
+		gs.lock.Lock()
+		gs.broker = nil
+		gs.lock.Unlock()
+	}()
 
-	heartbeatAckChan := make(chan ControlPacket)
-	defer close(heartbeatAckChan)
+	if gs.reliable != nil && !broker.Capabilities().Has(CapAckMode) {
+		gslog.Warn("[TcpConnectionKeeper] ack mode not negotiated, publish sent without delivery guarantee", "connID", gs.connID, "capabilities", broker.Capabilities().String())
+	}
+	gs.keepalive.Store(int64(broker.Keepalive()))
+	heartbeatAckChan := make(chan *HeartbeatAckPacket, 1)
 
 	ctxTask, ctxTaskCancel := context.WithCancel(gs.ctx)
 	defer ctxTaskCancel()
+	// 结束时关闭连接 唤醒阻塞在写入慢连接上的写协程
+	stopClose := context.AfterFunc(ctxTask, func() {
+		_ = broker.Close()
+	})
+	defer stopClose()
 
 	wg := sync.WaitGroup{}
 
@@ -106,51 +326,144 @@ func (gs *TcpConnectionKeeper) startBroker(broker ConnectionBroker) {
 	go func() {
 		defer wg.Done()
 		defer ctxTaskCancel()
+		// 写协程退出后关闭连接 唤醒阻塞在读取上的读协程
+		defer broker.Close()
 		gs.writeLoop(ctxTask, broker)
 	}()
 
-	heartbeat := broker.Keepalive()
-	if heartbeat > 0 {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer ctxTaskCancel()
-			gs.keepaliveLoop(ctxTask, heartbeat, heartbeatAckChan)
-		}()
-	}
+	// 心跳间隔为0时同样运行 等待对端调整心跳间隔
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ctxTaskCancel()
+		gs.keepaliveLoop(ctxTask, broker, heartbeatAckChan)
+	}()
 
 	wg.Wait()
 }
 
-func (gs *TcpConnectionKeeper) keepaliveLoop(ctx context.Context, heartbeat time.Duration, heartbeatAckChan chan ControlPacket) {
-	ticker := time.NewTicker(heartbeat)
-	defer ticker.Stop()
+// keepaliveLoop 按心跳间隔发送心跳 连续 missBudget 个心跳未确认时退出
+func (gs *TcpConnectionKeeper) keepaliveLoop(ctx context.Context, broker ConnectionBroker, heartbeatAckChan chan *HeartbeatAckPacket) {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	resetTicker := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if interval := time.Duration(gs.keepalive.Load()); interval > 0 {
+			ticker = time.NewTicker(interval)
+			tick = ticker.C
+		}
+	}
+	resetTicker()
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
 
+	missed := 0
+	outstanding := false
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			packet := NewControlPacket(Heartbeat)
-			select {
-			case gs.writeChan <- packet:
-				ctxHb, ctxHbCancel := context.WithTimeout(ctx, heartbeat)
-				select {
-				case <-ctxHb.Done():
-					ctxHbCancel()
-					gslog.Error("[TcpConnectionKeeper] wait heartbeat ack timeout", "connID", gs.connID, "heartbeat", heartbeat)
+		case <-gs.keepaliveChanged:
+			resetTicker()
+		case ack := <-heartbeatAckChan:
+			missed = 0
+			outstanding = false
+			if ack.Timestamp > 0 {
+				rtt := time.Since(gs.epoch) - time.Duration(ack.Timestamp)
+				gs.updateRTT(rtt)
+				broker.Statistics().OnHeartbeatRTT(rtt)
+			}
+		case <-tick:
+			if outstanding {
+				missed++
+				gslog.Warn("[TcpConnectionKeeper] heartbeat ack missed", "connID", gs.connID, "missed", missed, "missBudget", gs.missBudget)
+				if missed >= gs.missBudget {
+					gslog.Error("[TcpConnectionKeeper] wait heartbeat ack timeout", "connID", gs.connID, "heartbeat", time.Duration(gs.keepalive.Load()))
 					return
-				case heartbeatAckChan <- packet:
-					ctxHbCancel()
 				}
-			case <-ctx.Done():
+			}
+			packet := NewControlPacket(Heartbeat).(*HeartbeatPacket)
+			packet.Timestamp = int64(time.Since(gs.epoch))
+			packet.Interval = gs.takeKeepaliveAnnounce()
+			if err := gs.outbound.pushWait(ctx, gs.ctx, packet); err != nil {
 				return
 			}
+			outstanding = true
 		}
 	}
 }
 
-func (gs *TcpConnectionKeeper) readLoop(ctx context.Context, broker ConnectionBroker, heartbeatAckChan chan ControlPacket) {
+// updateRTT 平滑往返时间和抖动 RFC6298
+func (gs *TcpConnectionKeeper) updateRTT(rtt time.Duration) {
+	if rtt < 0 {
+		return
+	}
+	srtt := time.Duration(gs.srtt.Load())
+	if srtt == 0 {
+		gs.srtt.Store(int64(rtt))
+		gs.jitter.Store(int64(rtt / 2))
+		return
+	}
+	delta := rtt - srtt
+	if delta < 0 {
+		delta = -delta
+	}
+	jitter := time.Duration(gs.jitter.Load())
+	gs.jitter.Store(int64((3*jitter + delta) / 4))
+	gs.srtt.Store(int64((7*srtt + rtt) / 8))
+}
+
+// RTT 心跳测得的平滑往返时间 未测得时为0
+func (gs *TcpConnectionKeeper) RTT() time.Duration {
+	return time.Duration(gs.srtt.Load())
+}
+
+// Jitter 往返时间抖动
+func (gs *TcpConnectionKeeper) Jitter() time.Duration {
+	return time.Duration(gs.jitter.Load())
+}
+
+// Keepalive 当前心跳间隔
+func (gs *TcpConnectionKeeper) Keepalive() time.Duration {
+	return time.Duration(gs.keepalive.Load())
+}
+
+// SetKeepalive 调整心跳间隔 并通过后续的心跳或心跳确认通知对端采用相同的间隔
+// 重连后恢复为握手协商的间隔
+func (gs *TcpConnectionKeeper) SetKeepalive(interval time.Duration) {
+	if interval < time.Millisecond {
+		return
+	}
+	gs.applyKeepalive(interval)
+	gs.keepaliveAnnounce.Store(int64(interval))
+}
+
+// applyKeepalive 采用新的心跳间隔
+func (gs *TcpConnectionKeeper) applyKeepalive(interval time.Duration) {
+	if gs.keepalive.Swap(int64(interval)) == int64(interval) {
+		return
+	}
+	gslog.Info("[TcpConnectionKeeper] keepalive interval changed", "connID", gs.connID, "interval", interval)
+	select {
+	case gs.keepaliveChanged <- struct{}{}:
+	default:
+	}
+}
+
+// takeKeepaliveAnnounce 取出待通知的心跳间隔 毫秒
+func (gs *TcpConnectionKeeper) takeKeepaliveAnnounce() int32 {
+	interval := time.Duration(gs.keepaliveAnnounce.Swap(0))
+	return int32(interval / time.Millisecond)
+}
+
+func (gs *TcpConnectionKeeper) readLoop(ctx context.Context, broker ConnectionBroker, heartbeatAckChan chan *HeartbeatAckPacket) {
+	ackMode := broker.Capabilities().Has(CapAckMode)
 	for {
 		packet, err := broker.ReadPacket()
 		if err != nil {
@@ -158,75 +471,261 @@ func (gs *TcpConnectionKeeper) readLoop(ctx context.Context, broker ConnectionBr
 				time.Sleep(TimeoutWaitInterval)
 				continue
 			}
+			var decodeErr *PacketDecodeError
+			if errors.As(err, &decodeErr) {
+				gslog.Warn("[TcpConnectionKeeper] decode packet failed, close connection", "connID", gs.connID, "err", err)
+			}
+			var disconnectErr *DisconnectError
+			if errors.As(err, &disconnectErr) {
+				gslog.Warn("[TcpConnectionKeeper] disconnect peer", "connID", gs.connID, "reason", disconnectErr.Reason.String(), "err", err)
+				// 读协程退出会立即断开连接 等待 DISCONNECT 写出后关闭
+				go func() {
+					closeCtx, cancel := context.WithTimeout(context.Background(), DefaultCloseTimeout)
+					defer cancel()
+					_ = gs.CloseWithReason(closeCtx, disconnectErr.Reason, 0)
+				}()
+				<-ctx.Done()
+			}
 			break
 		}
 		gslog.Trace("[TcpConnectionKeeper] readLoop receiver packet", "connID", gs.connID, "packet", packet.String())
 
-		switch packet.(type) {
+		switch p := packet.(type) {
 		case *HeartbeatPacket:
+			if p.Interval > 0 {
+				gs.applyKeepalive(time.Duration(p.Interval) * time.Millisecond)
+			}
 			// send heartbeat ack
-			heartbeatAck := NewControlPacket(HeartbeatAck)
-			select {
-			case <-ctx.Done():
+			heartbeatAck := NewControlPacket(HeartbeatAck).(*HeartbeatAckPacket)
+			heartbeatAck.Timestamp = p.Timestamp
+			heartbeatAck.Interval = gs.takeKeepaliveAnnounce()
+			if err := gs.outbound.pushWait(ctx, gs.ctx, heartbeatAck); err != nil {
 				return
-			case gs.writeChan <- heartbeatAck:
 			}
 		case *HeartbeatAckPacket:
-			// dispatch to heart ack chan
+			if p.Interval > 0 {
+				gs.applyKeepalive(time.Duration(p.Interval) * time.Millisecond)
+			}
+			// 交给心跳协程 未确认的心跳只关心最近一次确认
 			select {
-			case <-ctx.Done():
+			case heartbeatAckChan <- p:
+			default:
+			}
+		case *PublishPacket:
+			if p.MessageID != 0 && ackMode {
+				// 需要确认的包 重复包同样回复确认 避免对端持续重发
+				publishAck := NewControlPacket(PublishAck).(*PublishAckPacket)
+				publishAck.MessageID = p.MessageID
+				if err := gs.outbound.pushWait(ctx, gs.ctx, publishAck); err != nil {
+					return
+				}
+				if gs.duplicates.Duplicated(p.MessageID) {
+					gslog.Debug("[TcpConnectionKeeper] drop duplicated publish", "connID", gs.connID, "messageID", p.MessageID)
+					continue
+				}
+			}
+			if !gs.deliver(ctx, packet) {
+				return
+			}
+		case *PublishAckPacket:
+			if gs.reliable != nil && gs.reliable.ack(p.MessageID) {
+				continue
+			}
+			if !gs.deliver(ctx, packet) {
 				return
-			case gs.readChan <- packet:
 			}
 		case *DisConnectPacket:
 			// fin
+			gs.onDisconnect(p)
 			return
 		default:
-			select {
-			case <-ctx.Done():
+			// 自定义包类型注册的处理函数
+			if handler := packetHandler(packet.Header().PacketType); handler != nil && handler(gs, packet) {
+				continue
+			}
+			if !gs.deliver(ctx, packet) {
 				return
-			case gs.readChan <- packet:
 			}
 		}
 	}
 }
 
+// onDisconnect 对端主动断开 记录重连建议 被踢时不再重连
+func (gs *TcpConnectionKeeper) onDisconnect(packet *DisConnectPacket) {
+	reconnectAfter := time.Duration(packet.ReconnectAfter) * time.Millisecond
+	gslog.Info("[TcpConnectionKeeper] peer disconnect", "connID", gs.connID, "reason", packet.Reason.String(), "reconnectAfter", reconnectAfter)
+
+	if !packet.Reason.Reconnectable() {
+		gs.lock.Lock()
+		gs.isClosed = true
+		gs.lock.Unlock()
+	} else if reconnectAfter > 0 {
+		gs.reconnectAfter.Store(int64(reconnectAfter))
+	}
+	for _, callback := range gs.disconnectCallbacks {
+		callback(gs.connID, packet.Reason, reconnectAfter)
+	}
+}
+
+// deliver 投递到收包队列 返回false时读协程退出
+func (gs *TcpConnectionKeeper) deliver(ctx context.Context, packet ControlPacket) bool {
+	err := gs.inbound.push(ctx, gs.ctx, packet)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrQueueFull):
+		gslog.Debug("[TcpConnectionKeeper] inbound queue full, drop packet", "connID", gs.connID, "packet", packet.String())
+		return true
+	case errors.Is(err, ErrQueueOverflow):
+		gslog.Warn("[TcpConnectionKeeper] inbound queue overflow, disconnect", "connID", gs.connID)
+		gs.abort()
+	}
+
+	return false
+}
+
 func (gs *TcpConnectionKeeper) writeLoop(ctx context.Context, broker ConnectionBroker) {
 	var err error
+	ackMode := broker.Capabilities().Has(CapAckMode)
+	// 重连后优先重发已发送未确认的包
+	if gs.reliable != nil {
+		for _, packet := range gs.reliable.sentPackets() {
+			if err = broker.WritePacket(packet); err != nil {
+				return
+			}
+			gs.afterWrite(packet, ackMode)
+		}
+	}
+	// 重发上一个连接发送失败的包
+	if len(gs.pendingPackets) > 0 {
+		if err = broker.WritePackets(gs.pendingPackets); err != nil {
+			return
+		}
+		for _, packet := range gs.pendingPackets {
+			gs.afterWrite(packet, ackMode)
+		}
+		gs.pendingPackets = nil
+	}
+	batch := make([]ControlPacket, 0, MaxWriteBatchSize)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case packet, ok := <-gs.writeChan:
+		case packet, ok := <-gs.outbound.ch:
 			if !ok {
 				// write chan close
 				return
 			}
-			err = broker.WritePacket(packet)
+			// 合并队列中已就绪的包 一次写出
+			batch = gs.drainOutbound(append(batch[:0], packet))
+			err = broker.WritePackets(batch)
 			if err != nil {
 				if IsNetTimeout(err) {
 					time.Sleep(TimeoutWaitInterval)
 					continue
 				}
+				// 业务包保留到重连后重发 无法确定已写出的部分 可能重复发送
+				if gs.reconnect != nil {
+					for _, packet = range batch {
+						if isPublish(packet) {
+							gs.pendingPackets = append(gs.pendingPackets, packet)
+						}
+					}
+				}
 				return
 			}
 			// write success...
-			gslog.Trace("[TcpConnectionKeeper] write packet success...", "connID", gs.connID, "packet", packet.String())
+			for _, packet = range batch {
+				gs.afterWrite(packet, ackMode)
+				gslog.Trace("[TcpConnectionKeeper] write packet success...", "connID", gs.connID, "packet", packet.String())
+			}
 		}
 	}
 }
 
+// drainOutbound 不阻塞地取出发送队列中已就绪的包
+func (gs *TcpConnectionKeeper) drainOutbound(batch []ControlPacket) []ControlPacket {
+	for len(batch) < MaxWriteBatchSize {
+		select {
+		case packet, ok := <-gs.outbound.ch:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, packet)
+		default:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+// afterWrite 写入成功后处理 可靠投递的包开始等待确认
+// 未协商确认模式时对端不会确认 写出即释放
+func (gs *TcpConnectionKeeper) afterWrite(packet ControlPacket, ackMode bool) {
+	switch p := packet.(type) {
+	case *PublishPacket:
+		if gs.reliable == nil || p.MessageID == 0 {
+			break
+		}
+		if ackMode {
+			gs.reliable.markSent(p.MessageID)
+		} else {
+			gs.reliable.untrack(p.MessageID)
+		}
+	case *DisConnectPacket:
+		gs.disconnectOnce.Do(func() {
+			close(gs.disconnectSent)
+		})
+	}
+}
+
 func (gs *TcpConnectionKeeper) ConnectionID() string {
 	gs.lock.RLock()
 	defer gs.lock.RUnlock()
 	return gs.connID
 }
 
+func (gs *TcpConnectionKeeper) Statistics() FlowStatistics {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+	return gs.statistics
+}
+
+func (gs *TcpConnectionKeeper) Principal() *Principal {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+	return gs.principal
+}
+
+// Close 正常关闭 最多等待 DefaultCloseTimeout
 func (gs *TcpConnectionKeeper) Close() error {
-	// send disconnect
-	ctxDisconnect, ctxDisconnectCancel := context.WithTimeout(gs.ctx, 5*time.Second)
-	err := gs.WritePacket(ctxDisconnect, NewControlPacket(DisConnect))
-	ctxDisconnectCancel()
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCloseTimeout)
+	defer cancel()
+
+	return gs.CloseWithReason(ctx, DisconnectNormal, 0)
+}
+
+// CloseWithReason 优雅关闭
+// 不再接受新的写入 等待已发送的可靠包确认 再发送携带原因和重连建议的 DISCONNECT
+// DISCONNECT 写出即表示之前的包都已写出 随后关闭 ctx结束时强制关闭
+func (gs *TcpConnectionKeeper) CloseWithReason(ctx context.Context, reason DisconnectReason, reconnectAfter time.Duration) error {
+	gs.lock.Lock()
+	if gs.isClosed {
+		gs.lock.Unlock()
+		return ErrConnectionLayerClosed
+	}
+	gs.isClosed = true
+	gs.lock.Unlock()
+
+	var err error
+	// 断线重连期间没有可写出的连接 直接关闭
+	if gs.State() == StateConnected {
+		err = gs.flush(ctx, NewDisConnectPacket(reason, reconnectAfter))
+		if err != nil {
+			gslog.Warn("[TcpConnectionKeeper] flush before close failed, force close", "connID", gs.connID, "reason", reason.String(), "err", err)
+		}
+	}
 
 	gs.ctxCancel()
 
@@ -236,8 +735,76 @@ func (gs *TcpConnectionKeeper) Close() error {
 	return err
 }
 
+// flush 等待可靠包确认后发送 DISCONNECT 并等待其写出
+func (gs *TcpConnectionKeeper) flush(ctx context.Context, disconnect *DisConnectPacket) error {
+	if gs.reliable != nil && gs.reliable.inflightCount() > 0 {
+		ticker := time.NewTicker(TimeoutWaitInterval)
+		defer ticker.Stop()
+		for gs.reliable.inflightCount() > 0 {
+			select {
+			case <-ctx.Done():
+				return ErrOperationCancel
+			case <-gs.ctx.Done():
+				return ErrConnectionLayerClosed
+			case <-ticker.C:
+			}
+		}
+	}
+
+	if err := gs.outbound.pushWait(ctx, gs.ctx, disconnect); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ErrOperationCancel
+	case <-gs.ctx.Done():
+		return ErrConnectionLayerClosed
+	case <-gs.disconnectSent:
+	}
+
+	return nil
+}
+
+// WriteUnreliable 绕过发送队列直接通过不可靠有序通道发送 断线期间直接丢弃
+func (gs *TcpConnectionKeeper) WriteUnreliable(packet ControlPacket) error {
+	gs.lock.RLock()
+	broker := gs.broker
+	gs.lock.RUnlock()
+
+	if broker == nil {
+		return ErrConnectionLayerClosed
+	}
+	writer, ok := broker.(UnreliableWriter)
+	if !ok {
+		return ErrUnreliableNotSupported
+	}
+
+	return writer.WriteUnreliable(packet)
+}
+
+// abort 队列溢出等原因直接关闭连接 不发送 DISCONNECT 也不再重连
+func (gs *TcpConnectionKeeper) abort() {
+	gs.markClosed()
+	gs.ctxCancel()
+}
+
+// markClosed 标记为已关闭 当前连接代理结束后不再重连
+func (gs *TcpConnectionKeeper) markClosed() {
+	gs.lock.Lock()
+	gs.isClosed = true
+	gs.lock.Unlock()
+}
+
 func (gs *TcpConnectionKeeper) Read() chan ControlPacket {
-	return gs.readChan
+	return gs.inbound.ch
+}
+
+// QueueStats 收发队列统计
+func (gs *TcpConnectionKeeper) QueueStats(direction QueueDirection) QueueStats {
+	if direction == QueueInbound {
+		return gs.inbound.stats()
+	}
+	return gs.outbound.stats()
 }
 
 func (gs *TcpConnectionKeeper) WritePacket(ctx context.Context, packet ControlPacket) error {
@@ -247,13 +814,44 @@ func (gs *TcpConnectionKeeper) WritePacket(ctx context.Context, packet ControlPa
 		}
 	}()
 
-	select {
-	case <-ctx.Done():
-		return ErrOperationCancel
-	case <-gs.ctx.Done():
+	if gs.IsClosed() {
 		return ErrConnectionLayerClosed
-	case gs.readChan <- packet:
+	}
+
+	var tracked *PublishPacket
+	if p, ok := packet.(*PublishPacket); ok && gs.reliable != nil {
+		if err := gs.reliable.track(ctx, p); err != nil {
+			return err
+		}
+		tracked = p
+	}
+
+	err := gs.outbound.push(ctx, gs.ctx, packet)
+	if err != nil {
+		if tracked != nil {
+			gs.reliable.untrack(tracked.MessageID)
+		}
+		if errors.Is(err, ErrQueueOverflow) {
+			gslog.Warn("[TcpConnectionKeeper] outbound queue overflow, disconnect", "connID", gs.connID)
+			gs.abort()
+		}
+		return err
 	}
 
 	return nil
 }
+
+// TryWritePacket 非阻塞写入 发送队列满时按溢出策略处理 阻塞策略下丢弃新包
+// 不参与可靠投递 用于广播等不能被慢连接拖住的场景
+func (gs *TcpConnectionKeeper) TryWritePacket(packet ControlPacket) error {
+	if gs.IsClosed() {
+		return ErrConnectionLayerClosed
+	}
+	err := gs.outbound.tryPush(gs.ctx, packet)
+	if errors.Is(err, ErrQueueOverflow) {
+		gslog.Warn("[TcpConnectionKeeper] outbound queue overflow, disconnect", "connID", gs.connID)
+		gs.abort()
+	}
+
+	return err
+}
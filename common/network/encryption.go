@@ -0,0 +1,142 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// 协议内加密
+// 适用于不方便使用TLS的客户端 需要TLS时直接使用 TlsDialBrokerFactory/WithTLSConfig
+// 客户端请求 CapEncryption 时在 CONNECT 中携带 X25519 公钥 服务端同意后在 CONNECT_ACK 中回复公钥
+// 双方由共享密钥派生两个方向的 AES-256-GCM 密钥 此后每个包体单独加密并在固定包头中标记 FlagEncrypted
+// nonce 为各方向独立递增的包序号 不在线路上传输 依赖底层连接保序
+// 客户端请求加密而服务端未同意时握手失败 不降级为明文
+
+const (
+	packetKeySize   = 32
+	packetKeyLabel  = "game-server packet keys"
+	packetNonceSize = 12
+)
+
+var (
+	ErrUnencryptedPacket = errors.New("unencrypted packet on encrypted connection")
+	ErrDecryptFailed     = errors.New("packet decrypt failed")
+)
+
+// packetCipher 连接的包体加解密状态
+// seal 由写协程调用 open 由读协程调用
+type packetCipher struct {
+	sealer  cipher.AEAD
+	opener  cipher.AEAD
+	sealSeq uint64
+	openSeq uint64
+}
+
+// newExchangeKey 生成密钥交换私钥
+func newExchangeKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// acceptKeyExchange 服务端完成密钥交换 返回加密状态和服务端公钥
+func acceptKeyExchange(clientPublic []byte) (*packetCipher, []byte, error) {
+	private, err := newExchangeKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	serverPublic := private.PublicKey().Bytes()
+	sessionCipher, err := newPacketCipher(private, clientPublic, clientPublic, serverPublic, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sessionCipher, serverPublic, nil
+}
+
+// newPacketCipher 由双方公钥和本端私钥派生加密状态
+func newPacketCipher(private *ecdh.PrivateKey, peerPublic, clientPublic, serverPublic []byte, isClient bool) (*packetCipher, error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 0, len(clientPublic)+len(serverPublic))
+	salt = append(salt, clientPublic...)
+	salt = append(salt, serverPublic...)
+	keys := hkdfSHA256(shared, salt, []byte(packetKeyLabel), 2*packetKeySize)
+
+	clientToServer, err := newPacketAEAD(keys[:packetKeySize])
+	if err != nil {
+		return nil, err
+	}
+	serverToClient, err := newPacketAEAD(keys[packetKeySize:])
+	if err != nil {
+		return nil, err
+	}
+	if isClient {
+		return &packetCipher{sealer: clientToServer, opener: serverToClient}, nil
+	}
+	return &packetCipher{sealer: serverToClient, opener: clientToServer}, nil
+}
+
+func newPacketAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal 加密包体 包头首字节作为附加数据 防止篡改包类型和标记
+func (gs *packetCipher) seal(headerByte byte, body []byte) []byte {
+	nonce := gs.nonce(gs.sealSeq)
+	gs.sealSeq++
+
+	return gs.sealer.Seal(nil, nonce, body, []byte{headerByte})
+}
+
+// open 解密包体
+func (gs *packetCipher) open(headerByte byte, body []byte) ([]byte, error) {
+	nonce := gs.nonce(gs.openSeq)
+	plain, err := gs.opener.Open(nil, nonce, body, []byte{headerByte})
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	gs.openSeq++
+
+	return plain, nil
+}
+
+func (gs *packetCipher) nonce(seq uint64) []byte {
+	nonce := make([]byte, packetNonceSize)
+	binary.BigEndian.PutUint64(nonce[packetNonceSize-8:], seq)
+	return nonce
+}
+
+// hkdfSHA256 RFC5869 HKDF 密钥派生
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	out := make([]byte, 0, length+sha256.Size)
+	var prev []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(prev)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+
+	return out[:length]
+}
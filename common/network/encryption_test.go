@@ -0,0 +1,230 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// tapConn 可截留写入的帧 截留时帧不发往对端 由测试篡改或重放
+type tapConn struct {
+	net.Conn
+	hold   atomic.Bool
+	frames chan []byte
+}
+
+func newTapConn(conn net.Conn) *tapConn {
+	return &tapConn{Conn: conn, frames: make(chan []byte, 16)}
+}
+
+func (gs *tapConn) Write(p []byte) (int, error) {
+	if gs.hold.Load() {
+		gs.frames <- append([]byte{}, p...)
+		return len(p), nil
+	}
+	return gs.Conn.Write(p)
+}
+
+// captureFrame 截留客户端写出的下一帧
+func (gs *tapConn) captureFrame(t *testing.T, broker ConnectionBroker, packet ControlPacket) []byte {
+	t.Helper()
+	gs.hold.Store(true)
+	defer gs.hold.Store(false)
+	if err := broker.WritePacket(packet); err != nil {
+		t.Fatalf("write packet: %v", err)
+	}
+
+	return <-gs.frames
+}
+
+// inject 绕过连接代理直接写入帧 服务端读取
+func (gs *tapConn) inject(t *testing.T, server ConnectionBroker, frame []byte) (ControlPacket, error) {
+	t.Helper()
+	written := make(chan error, 1)
+	go func() {
+		_, err := gs.Conn.Write(frame)
+		written <- err
+	}()
+	packet, err := server.ReadPacket()
+	if err != nil {
+		// 读取失败时帧可能未读完
+		_ = server.Close()
+	}
+	if writeErr := <-written; writeErr != nil && err == nil {
+		t.Fatalf("inject frame: %v", writeErr)
+	}
+
+	return packet, err
+}
+
+// newEncryptedPair 在 net.Pipe 上完成加密握手
+func newEncryptedPair(t *testing.T, codec FrameCodec) (ConnectionBroker, ConnectionBroker, *tapConn) {
+	t.Helper()
+	clientSide, serverSide := net.Pipe()
+	tap := newTapConn(clientSide)
+
+	serverConf := &BrokerConf{ConnectionID: "s", ByteOrder: binary.BigEndian, Capabilities: CapEncryption, FrameCodec: codec}
+	accepted := make(chan error, 1)
+	var server ConnectionBroker
+	go func() {
+		var err error
+		server, err = AcceptBroker(serverSide, serverConf)
+		accepted <- err
+	}()
+	clientConf := &BrokerConf{ConnectionID: "c", ByteOrder: binary.BigEndian, Capabilities: CapEncryption, FrameCodec: codec}
+	client, err := ConnectBroker(tap, clientConf)
+	if err != nil {
+		t.Fatalf("connect broker: %v", err)
+	}
+	if err = <-accepted; err != nil {
+		t.Fatalf("accept broker: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return client, server, tap
+}
+
+func newPublish(messageID uint32, payload string) *PublishPacket {
+	packet := NewControlPacket(Publish).(*PublishPacket)
+	packet.MessageID = messageID
+	packet.Payload = []byte(payload)
+	return packet
+}
+
+// reframe 篡改包体后按帧格式重新封装 定长校验格式的校验和同时更新
+func reframe(t *testing.T, codec FrameCodec, frame []byte, tamper func(header *FixedHeader, body []byte)) []byte {
+	t.Helper()
+	header, body, err := codec.ReadFrame(bytes.NewReader(frame), binary.BigEndian, 0)
+	if err != nil {
+		t.Fatalf("read captured frame: %v", err)
+	}
+	tamper(&header, body)
+	head := make([]byte, maxFrameHeaderSize)
+	n := codec.PutHeader(head, &header, body, binary.BigEndian)
+
+	return append(head[:n], body...)
+}
+
+var frameCodecs = []FrameCodec{VarintFrameCodec, FixedLengthFrameCodec, CRC32FrameCodec}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	for _, codec := range frameCodecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			client, server, tap := newEncryptedPair(t, codec)
+			if !client.Capabilities().Has(CapEncryption) || !server.Capabilities().Has(CapEncryption) {
+				t.Fatalf("encryption not negotiated")
+			}
+
+			// 线路上不出现明文
+			frame := tap.captureFrame(t, client, newPublish(1, "secret item purchase"))
+			if bytes.Contains(frame, []byte("secret")) {
+				t.Fatalf("plaintext payload on the wire")
+			}
+			packet, err := tap.inject(t, server, frame)
+			if err != nil {
+				t.Fatalf("read packet: %v", err)
+			}
+			if publish, ok := packet.(*PublishPacket); !ok || string(publish.Payload) != "secret item purchase" || publish.HasFlag(FlagEncrypted) {
+				t.Fatalf("received %v", packet)
+			}
+
+			// 双向多包 序号保持同步
+			for i := 0; i < 10; i++ {
+				received, err := transfer(t, client, server, newPublish(uint32(i+2), "client"))
+				if err != nil {
+					t.Fatalf("client to server: %v", err)
+				}
+				if received.(*PublishPacket).MessageID != uint32(i+2) {
+					t.Fatalf("received %v", received)
+				}
+				if _, err = transfer(t, server, client, NewControlPacket(Heartbeat)); err != nil {
+					t.Fatalf("server to client: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptionRejectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		attack  func(t *testing.T, codec FrameCodec, frame []byte) [][]byte
+		wantErr error
+	}{
+		{
+			name: "flipped ciphertext",
+			attack: func(t *testing.T, codec FrameCodec, frame []byte) [][]byte {
+				return [][]byte{reframe(t, codec, frame, func(_ *FixedHeader, body []byte) {
+					body[len(body)/2] ^= 0x01
+				})}
+			},
+			wantErr: ErrDecryptFailed,
+		},
+		{
+			name: "modified header",
+			attack: func(t *testing.T, codec FrameCodec, frame []byte) [][]byte {
+				return [][]byte{reframe(t, codec, frame, func(header *FixedHeader, _ []byte) {
+					header.PacketType = PublishAck
+				})}
+			},
+			wantErr: ErrDecryptFailed,
+		},
+		{
+			name: "replayed frame",
+			attack: func(_ *testing.T, _ FrameCodec, frame []byte) [][]byte {
+				return [][]byte{frame, frame}
+			},
+			wantErr: ErrDecryptFailed,
+		},
+		{
+			name: "plaintext frame",
+			attack: func(t *testing.T, codec FrameCodec, _ []byte) [][]byte {
+				var buf bytes.Buffer
+				if _, err := WritePacketWithCodec(&buf, newPublish(1, "plain"), binary.BigEndian, codec); err != nil {
+					t.Fatalf("encode plaintext: %v", err)
+				}
+				return [][]byte{buf.Bytes()}
+			},
+			wantErr: ErrUnencryptedPacket,
+		},
+	}
+	for _, codec := range frameCodecs {
+		for _, tt := range tests {
+			t.Run(codec.Name()+"/"+tt.name, func(t *testing.T) {
+				client, server, tap := newEncryptedPair(t, codec)
+				frames := tt.attack(t, codec, tap.captureFrame(t, client, newPublish(1, "item purchase")))
+
+				var err error
+				for _, frame := range frames {
+					if _, err = tap.inject(t, server, frame); err != nil {
+						break
+					}
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("read packet: %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptionRequiredByClient(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+	go func() {
+		_, _ = AcceptBroker(serverSide, &BrokerConf{ConnectionID: "s", ByteOrder: binary.BigEndian})
+	}()
+
+	// 服务端未同意加密时不降级为明文
+	_, err := ConnectBroker(clientSide, &BrokerConf{ConnectionID: "c", ByteOrder: binary.BigEndian, Capabilities: CapEncryption})
+	if !errors.Is(err, ErrKeyExchangeFailed) {
+		t.Fatalf("connect broker: %v, want %v", err, ErrKeyExchangeFailed)
+	}
+}
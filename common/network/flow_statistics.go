@@ -1,4 +1,195 @@
 package network
 
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 流量统计
+// 每个连接一份计数器 同时累加到进程级全局计数器
+// 计数全部使用原子操作 读写协程更新时无锁竞争
+
 type FlowStatistics interface {
+	// OnPacketRead 读取一个包 size为线路上的字节数
+	OnPacketRead(packetType PacketType, size int)
+	// OnPacketWritten 写入一个包
+	OnPacketWritten(packetType PacketType, size int)
+	// OnHandshakeFailed 握手失败
+	OnHandshakeFailed()
+	// OnReconnect 重连成功
+	OnReconnect()
+	// OnHeartbeatRTT 收到心跳确认
+	OnHeartbeatRTT(rtt time.Duration)
+	// OnWriteTimeout 写超时
+	OnWriteTimeout()
+	// OnRateLimited 收包超出限速
+	OnRateLimited(packetType PacketType, action RateLimitAction)
+	// Snapshot 当前统计快照
+	Snapshot() FlowSnapshot
+}
+
+// PacketFlow 单个包类型的流量
+type PacketFlow struct {
+	PacketsIn  uint64
+	PacketsOut uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	Limited    uint64 // 超出限速的包数
+}
+
+// FlowSnapshot 统计快照
+type FlowSnapshot struct {
+	Packets           map[string]PacketFlow // 包名 => 流量 只包含有流量的包类型
+	PacketsIn         uint64
+	PacketsOut        uint64
+	BytesIn           uint64
+	BytesOut          uint64
+	HandshakeFailures uint64
+	Reconnects        uint64
+	WriteTimeouts     uint64
+	Heartbeats        uint64        // 收到心跳确认次数
+	LastRTT           time.Duration // 最近一次心跳往返时间
+	AvgRTT            time.Duration
+	MaxRTT            time.Duration
+	RateLimited       map[string]uint64 // 处理方式 => 超出限速的包数 只包含发生过的处理方式
+}
+
+type packetCounter struct {
+	packetsIn  atomic.Uint64
+	packetsOut atomic.Uint64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+	limited    atomic.Uint64
+}
+
+// flowCounter FlowStatistics 的原子计数实现
+type flowCounter struct {
+	parent            *flowCounter
+	packets           [PacketTypeMask + 1]packetCounter
+	handshakeFailures atomic.Uint64
+	reconnects        atomic.Uint64
+	writeTimeouts     atomic.Uint64
+	rateLimited       [RateLimitDisconnect + 1]atomic.Uint64
+	heartbeats        atomic.Uint64
+	rttSum            atomic.Int64
+	rttLast           atomic.Int64
+	rttMax            atomic.Int64
+}
+
+var globalFlowCounter = &flowCounter{}
+
+// GlobalFlowStatistics 进程级统计 汇总所有连接
+func GlobalFlowStatistics() FlowStatistics {
+	return globalFlowCounter
+}
+
+// NewFlowStatistics 创建连接级统计 同时累加到全局统计
+func NewFlowStatistics() FlowStatistics {
+	return &flowCounter{parent: globalFlowCounter}
+}
+
+func (gs *flowCounter) OnPacketRead(packetType PacketType, size int) {
+	counter := &gs.packets[byte(packetType)&PacketTypeMask]
+	counter.packetsIn.Add(1)
+	counter.bytesIn.Add(uint64(size))
+	if gs.parent != nil {
+		gs.parent.OnPacketRead(packetType, size)
+	}
+}
+
+func (gs *flowCounter) OnPacketWritten(packetType PacketType, size int) {
+	counter := &gs.packets[byte(packetType)&PacketTypeMask]
+	counter.packetsOut.Add(1)
+	counter.bytesOut.Add(uint64(size))
+	if gs.parent != nil {
+		gs.parent.OnPacketWritten(packetType, size)
+	}
+}
+
+func (gs *flowCounter) OnHandshakeFailed() {
+	gs.handshakeFailures.Add(1)
+	if gs.parent != nil {
+		gs.parent.OnHandshakeFailed()
+	}
+}
+
+func (gs *flowCounter) OnReconnect() {
+	gs.reconnects.Add(1)
+	if gs.parent != nil {
+		gs.parent.OnReconnect()
+	}
+}
+
+func (gs *flowCounter) OnHeartbeatRTT(rtt time.Duration) {
+	gs.heartbeats.Add(1)
+	gs.rttSum.Add(int64(rtt))
+	gs.rttLast.Store(int64(rtt))
+	for {
+		current := gs.rttMax.Load()
+		if int64(rtt) <= current || gs.rttMax.CompareAndSwap(current, int64(rtt)) {
+			break
+		}
+	}
+	if gs.parent != nil {
+		gs.parent.OnHeartbeatRTT(rtt)
+	}
+}
+
+func (gs *flowCounter) OnWriteTimeout() {
+	gs.writeTimeouts.Add(1)
+	if gs.parent != nil {
+		gs.parent.OnWriteTimeout()
+	}
+}
+
+func (gs *flowCounter) OnRateLimited(packetType PacketType, action RateLimitAction) {
+	gs.packets[byte(packetType)&PacketTypeMask].limited.Add(1)
+	if action >= 0 && int(action) < len(gs.rateLimited) {
+		gs.rateLimited[action].Add(1)
+	}
+	if gs.parent != nil {
+		gs.parent.OnRateLimited(packetType, action)
+	}
+}
+
+// Snapshot 各计数分别读取 快照内的计数之间不保证严格一致
+func (gs *flowCounter) Snapshot() FlowSnapshot {
+	snapshot := FlowSnapshot{
+		Packets:           make(map[string]PacketFlow),
+		RateLimited:       make(map[string]uint64),
+		HandshakeFailures: gs.handshakeFailures.Load(),
+		Reconnects:        gs.reconnects.Load(),
+		WriteTimeouts:     gs.writeTimeouts.Load(),
+		Heartbeats:        gs.heartbeats.Load(),
+		LastRTT:           time.Duration(gs.rttLast.Load()),
+		MaxRTT:            time.Duration(gs.rttMax.Load()),
+	}
+	for i := range gs.rateLimited {
+		if count := gs.rateLimited[i].Load(); count > 0 {
+			snapshot.RateLimited[RateLimitAction(i).String()] = count
+		}
+	}
+	if snapshot.Heartbeats > 0 {
+		snapshot.AvgRTT = time.Duration(gs.rttSum.Load() / int64(snapshot.Heartbeats))
+	}
+	for i := range gs.packets {
+		counter := &gs.packets[i]
+		flow := PacketFlow{
+			PacketsIn:  counter.packetsIn.Load(),
+			PacketsOut: counter.packetsOut.Load(),
+			BytesIn:    counter.bytesIn.Load(),
+			BytesOut:   counter.bytesOut.Load(),
+			Limited:    counter.limited.Load(),
+		}
+		if flow.PacketsIn == 0 && flow.PacketsOut == 0 {
+			continue
+		}
+		snapshot.Packets[PacketType(i).String()] = flow
+		snapshot.PacketsIn += flow.PacketsIn
+		snapshot.PacketsOut += flow.PacketsOut
+		snapshot.BytesIn += flow.BytesIn
+		snapshot.BytesOut += flow.BytesOut
+	}
+
+	return snapshot
 }
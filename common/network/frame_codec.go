@@ -0,0 +1,227 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"GameServer/utils"
+)
+
+// 帧编解码
+// 决定固定包头(包类型和包体长度)在线路上的格式 包体编码与帧格式无关
+// 同一组控制报文可以使用任一帧格式收发 帧格式不参与握手协商 双方需配置相同的格式
+//
+//	VarintFrameCodec      [类型 1字节][变长长度 1~4字节][包体]
+//	FixedLengthFrameCodec [长度 4字节][类型 1字节][包体]           长度为其后的字节数
+//	CRC32FrameCodec       [长度 4字节][CRC32 4字节][类型 1字节][包体] 校验覆盖类型和包体
+//
+// 定长格式的长度和校验按连接的字节序编码
+
+const (
+	// maxFrameHeaderSize 所有帧格式中最大的帧头长度
+	maxFrameHeaderSize = 9
+	// lengthFieldSize 定长格式的长度字段
+	lengthFieldSize = 4
+	// checksumFieldSize 校验字段
+	checksumFieldSize = 4
+)
+
+var (
+	ErrChecksumMismatch = errors.New("frame checksum mismatch")
+
+	VarintFrameCodec      FrameCodec = varintFrameCodec{}
+	FixedLengthFrameCodec FrameCodec = fixedLengthFrameCodec{}
+	CRC32FrameCodec       FrameCodec = crc32FrameCodec{}
+)
+
+// FrameCodec 帧格式
+type FrameCodec interface {
+	// Name 帧格式名
+	Name() string
+	// PutHeader 根据固定包头和包体写入帧头 返回帧头长度 dst至少 maxFrameHeaderSize 字节
+	PutHeader(dst []byte, header *FixedHeader, body []byte, order binary.ByteOrder) int
+	// ReadFrame 读取一帧 包头校验通过后才按包体长度分配内存
+	ReadFrame(r io.Reader, order binary.ByteOrder, maxSize int) (FixedHeader, []byte, error)
+	// FrameSize 包体长度为remainLength时整帧在线路上的字节数
+	FrameSize(remainLength int) int
+}
+
+// frameCodec 连接使用的帧格式
+func frameCodec(cfg *BrokerConf) FrameCodec {
+	if cfg.FrameCodec == nil {
+		return VarintFrameCodec
+	}
+	return cfg.FrameCodec
+}
+
+// ReadPacketWithCodec 以指定帧格式读取单个包
+func ReadPacketWithCodec(r io.Reader, order binary.ByteOrder, maxSize int, codec FrameCodec) (ControlPacket, error) {
+	fixedHeader, body, err := codec.ReadFrame(r, order, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePacket(fixedHeader, body, order)
+}
+
+// WritePacketWithCodec 以指定帧格式写入单个包
+func WritePacketWithCodec(w io.Writer, packet ControlPacket, order binary.ByteOrder, codec FrameCodec) (int64, error) {
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	frame, err := encodePacket(buf, packet, order, codec)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(frame)
+
+	return int64(n), err
+}
+
+// checkFrameHeader 分配包体前校验包类型和长度
+func checkFrameHeader(header FixedHeader, maxSize int) error {
+	if !isKnownPacketType(header.PacketType) {
+		return newPacketDecodeError(header, ErrInvalidPacketType, nil)
+	}
+	// 32位平台上超大的定长长度转换后为负数
+	if header.RemainLength < 0 || header.RemainLength > MaxRemainLength || (maxSize > 0 && header.RemainLength > maxSize) {
+		return newPacketDecodeError(header, ErrPacketTooLarge, nil)
+	}
+	return nil
+}
+
+// readBody 读取完整包体
+func readBody(r io.Reader, remainLength int) ([]byte, error) {
+	body := make([]byte, remainLength)
+	n, err := io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+	if n != remainLength {
+		return nil, ErrReadExpectedDataFailed
+	}
+
+	return body, nil
+}
+
+// splitHeaderByte 首字节拆分为包类型和标记位
+func splitHeaderByte(b byte) FixedHeader {
+	return FixedHeader{
+		PacketType: PacketType(b & PacketTypeMask),
+		Flags:      b &^ PacketTypeMask,
+	}
+}
+
+func headerByte(header *FixedHeader) byte {
+	return byte(header.PacketType)&PacketTypeMask | header.Flags&^PacketTypeMask
+}
+
+// varintFrameCodec 默认帧格式 类型后跟MQTT风格的变长长度
+type varintFrameCodec struct{}
+
+func (gs varintFrameCodec) Name() string {
+	return "varint"
+}
+
+func (gs varintFrameCodec) PutHeader(dst []byte, header *FixedHeader, body []byte, order binary.ByteOrder) int {
+	return putFixedHeader(dst, header)
+}
+
+func (gs varintFrameCodec) ReadFrame(r io.Reader, order binary.ByteOrder, maxSize int) (FixedHeader, []byte, error) {
+	return readFrame(r, maxSize)
+}
+
+func (gs varintFrameCodec) FrameSize(remainLength int) int {
+	return 1 + len(utils.EncodeVariableInt(int64(remainLength))) + remainLength
+}
+
+// fixedLengthFrameCodec 4字节长度前缀
+type fixedLengthFrameCodec struct{}
+
+func (gs fixedLengthFrameCodec) Name() string {
+	return "fixed"
+}
+
+func (gs fixedLengthFrameCodec) PutHeader(dst []byte, header *FixedHeader, body []byte, order binary.ByteOrder) int {
+	order.PutUint32(dst, uint32(1+len(body)))
+	dst[lengthFieldSize] = headerByte(header)
+
+	return lengthFieldSize + 1
+}
+
+func (gs fixedLengthFrameCodec) ReadFrame(r io.Reader, order binary.ByteOrder, maxSize int) (FixedHeader, []byte, error) {
+	var head [lengthFieldSize + 1]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return FixedHeader{}, nil, err
+	}
+	header := splitHeaderByte(head[lengthFieldSize])
+	length := order.Uint32(head[:lengthFieldSize])
+	if length < 1 {
+		return header, nil, newPacketDecodeError(header, ErrMalformedPacket, nil)
+	}
+	header.RemainLength = int(length - 1)
+	if err := checkFrameHeader(header, maxSize); err != nil {
+		return header, nil, err
+	}
+	body, err := readBody(r, header.RemainLength)
+
+	return header, body, err
+}
+
+func (gs fixedLengthFrameCodec) FrameSize(remainLength int) int {
+	return lengthFieldSize + 1 + remainLength
+}
+
+// crc32FrameCodec 4字节长度前缀 + CRC32(IEEE)校验
+type crc32FrameCodec struct{}
+
+func (gs crc32FrameCodec) Name() string {
+	return "crc32"
+}
+
+func (gs crc32FrameCodec) PutHeader(dst []byte, header *FixedHeader, body []byte, order binary.ByteOrder) int {
+	typeByte := headerByte(header)
+	order.PutUint32(dst, uint32(checksumFieldSize+1+len(body)))
+	order.PutUint32(dst[lengthFieldSize:], frameChecksum(typeByte, body))
+	dst[lengthFieldSize+checksumFieldSize] = typeByte
+
+	return lengthFieldSize + checksumFieldSize + 1
+}
+
+func (gs crc32FrameCodec) ReadFrame(r io.Reader, order binary.ByteOrder, maxSize int) (FixedHeader, []byte, error) {
+	var head [lengthFieldSize + checksumFieldSize + 1]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return FixedHeader{}, nil, err
+	}
+	typeByte := head[lengthFieldSize+checksumFieldSize]
+	header := splitHeaderByte(typeByte)
+	length := order.Uint32(head[:lengthFieldSize])
+	if length < checksumFieldSize+1 {
+		return header, nil, newPacketDecodeError(header, ErrMalformedPacket, nil)
+	}
+	header.RemainLength = int(length - checksumFieldSize - 1)
+	if err := checkFrameHeader(header, maxSize); err != nil {
+		return header, nil, err
+	}
+	body, err := readBody(r, header.RemainLength)
+	if err != nil {
+		return header, nil, err
+	}
+	if frameChecksum(typeByte, body) != order.Uint32(head[lengthFieldSize:]) {
+		return header, nil, newPacketDecodeError(header, ErrMalformedPacket, ErrChecksumMismatch)
+	}
+
+	return header, body, nil
+}
+
+func (gs crc32FrameCodec) FrameSize(remainLength int) int {
+	return lengthFieldSize + checksumFieldSize + 1 + remainLength
+}
+
+// frameChecksum 类型字节和包体的CRC32
+func frameChecksum(typeByte byte, body []byte) uint32 {
+	checksum := crc32.Update(0, crc32.IEEETable, []byte{typeByte})
+	return crc32.Update(checksum, crc32.IEEETable, body)
+}
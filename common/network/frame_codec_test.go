@@ -0,0 +1,117 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"GameServer/utils"
+)
+
+// fuzzMaxSize 较小的包体上限 覆盖超长拒绝的分支
+const fuzzMaxSize = 256
+
+// builtinPackets 每种内置报文各一个 字段均有取值
+func builtinPackets() []ControlPacket {
+	connect := NewControlPacket(Connect).(*ConnectPacket)
+	connect.ProtocolVersion = 1
+	connect.Keepalive = 5000
+	connect.Capabilities = CapCompression | CapCompressLZ
+	connect.ClientIdentifier = "client-1"
+	connect.AuthMethod = "token"
+	connect.AuthData = []byte("signed-token")
+	connect.PublicKey = bytes.Repeat([]byte{0xAB}, 32)
+
+	connectAck := NewControlPacket(ConnectAck).(*ConnectAckPacket)
+	connectAck.ReturnCode = Accepted
+
+	heartbeat := NewControlPacket(Heartbeat).(*HeartbeatPacket)
+	heartbeat.Timestamp = 123456789
+	heartbeat.Interval = 3000
+
+	heartbeatAck := NewControlPacket(HeartbeatAck).(*HeartbeatAckPacket)
+	heartbeatAck.Timestamp = 123456789
+
+	publishAck := NewControlPacket(PublishAck).(*PublishAckPacket)
+	publishAck.MessageID = 7
+
+	disconnect := NewDisConnectPacket(DisconnectServerShutdown, 0)
+
+	return []ControlPacket{connect, connectAck, heartbeat, heartbeatAck, newPublish(7, "fuzz payload"), publishAck, disconnect}
+}
+
+func FuzzReadPacket(f *testing.F) {
+	for i, codec := range frameCodecs {
+		for _, packet := range builtinPackets() {
+			var buf bytes.Buffer
+			if _, err := WritePacketWithCodec(&buf, packet, binary.BigEndian, codec); err != nil {
+				f.Fatalf("encode %s: %v", packet.Name(), err)
+			}
+			f.Add(byte(i), buf.Bytes())
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, index byte, data []byte) {
+		codec := frameCodecs[int(index)%len(frameCodecs)]
+		packet, err := ReadPacketWithCodec(bytes.NewReader(data), binary.BigEndian, fuzzMaxSize, codec)
+		if err != nil {
+			if packet != nil {
+				t.Fatalf("packet %v returned with error %v", packet, err)
+			}
+			return
+		}
+		if packet.Header().RemainLength > fuzzMaxSize {
+			t.Fatalf("body of %d bytes accepted, limit %d", packet.Header().RemainLength, fuzzMaxSize)
+		}
+
+		// 解码成功的包重新编码后可再次解码
+		var buf bytes.Buffer
+		if _, err = WritePacketWithCodec(&buf, packet, binary.BigEndian, codec); err != nil {
+			if errors.Is(err, ErrPacketTooLarge) {
+				return
+			}
+			t.Fatalf("encode %v: %v", packet, err)
+		}
+		decoded, err := ReadPacketWithCodec(&buf, binary.BigEndian, 0, codec)
+		if err != nil {
+			t.Fatalf("decode re-encoded %v: %v", packet, err)
+		}
+		if decoded.String() != packet.String() {
+			t.Fatalf("re-encoded packet %v, want %v", decoded, packet)
+		}
+	})
+}
+
+// FuzzDecodeReaderBytes 包体中的字节字段由 utils.DecodeReaderBytes 解码
+func FuzzDecodeReaderBytes(f *testing.F) {
+	for _, packet := range builtinPackets() {
+		var buf bytes.Buffer
+		if _, err := WritePacketWithCodec(&buf, packet, binary.BigEndian, VarintFrameCodec); err != nil {
+			f.Fatalf("encode %s: %v", packet.Name(), err)
+		}
+		// 以帧中每个位置起始 覆盖各个字段的长度前缀
+		frame := buf.Bytes()
+		for i := range frame {
+			f.Add(frame[i:])
+		}
+	}
+	f.Add(utils.EncodeBytes(nil, binary.BigEndian))
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF, 1, 2, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		field, err := utils.DecodeReaderBytes(bytes.NewReader(data), binary.BigEndian)
+		// 无法获知剩余长度的读取器结果一致
+		unsized, unsizedErr := utils.DecodeReaderBytes(struct{ io.Reader }{bytes.NewReader(data)}, binary.BigEndian)
+		if (err == nil) != (unsizedErr == nil) || !bytes.Equal(field, unsized) {
+			t.Fatalf("sized reader %d bytes %v, unsized reader %d bytes %v", len(field), err, len(unsized), unsizedErr)
+		}
+		if err != nil {
+			return
+		}
+		if encoded := utils.EncodeBytes(field, binary.BigEndian); !bytes.Equal(encoded, data[:len(encoded)]) {
+			t.Fatalf("decoded field of %d bytes does not match input", len(field))
+		}
+	})
+}
@@ -9,19 +9,47 @@ import (
 )
 
 type (
+	OnConnectionOpenCallback  func(conn ConnectionLayer)
 	OnConnectionCloseCallback func(connectionID string)
-	ConnectionBrokerFactory   func(conn net.Conn, cfg *BrokerConf)
+	OnConnectionStateCallback func(connectionID string, state ConnectionState)
+	OnDeliveryFailedCallback  func(connectionID string, packet *PublishPacket)
+	OnDisconnectCallback      func(connectionID string, reason DisconnectReason, reconnectAfter time.Duration)
+	// OnQueueHighWatermarkCallback 队列深度达到高水位 在入队协程中调用 不可阻塞
+	OnQueueHighWatermarkCallback func(connectionID string, direction QueueDirection, depth int)
+	ConnectionBrokerFactory      func(conn net.Conn, cfg *BrokerConf)
 )
 
 type (
 	// ApplicationLayer 应用层
-	// 最顶层业务 表示一个服务
+	// 最顶层业务 表示一个服务 接收会话生命周期事件
 	ApplicationLayer interface {
+		// OnOpen 会话建立 一般在此启动路由分发
+		OnOpen(session SessionLayer)
+		// OnClose 会话关闭
+		OnClose(session SessionLayer)
+		// OnIdle 会话超过空闲时间未收到消息 每次空闲只回调一次
+		OnIdle(session SessionLayer, idle time.Duration)
 	}
 
 	//SessionLayer 会话层
 	// 管理&控制两个通信之间的会话,数据交换同步
 	SessionLayer interface {
+		// SessionID 会话ID
+		SessionID() string
+		// UserID 玩家ID 未登录时为空
+		UserID() string
+		// Conn 会话所属连接层
+		Conn() ConnectionLayer
+		// Attributes 会话属性
+		Attributes() *Attributes
+		// ConnectedAt 连接建立时间
+		ConnectedAt() time.Time
+		// LastActive 最近活跃时间
+		LastActive() time.Time
+		// Send 经表示层编码后以指定路由发送
+		Send(ctx context.Context, route uint32, msg any) error
+		// Close 关闭会话
+		Close() error
 	}
 
 	// PresentationLayer 表示层
@@ -35,12 +63,30 @@ type (
 	ConnectionLayer interface {
 		// ConnectionID 连接ID
 		ConnectionID() string
+		// Principal 当前连接鉴权通过的身份信息 未鉴权时为nil
+		Principal() *Principal
 		// Close 关闭
 		Close() error
+		// CloseWithReason 发送携带原因的 DISCONNECT 写完待发送的包后关闭
+		CloseWithReason(ctx context.Context, reason DisconnectReason, reconnectAfter time.Duration) error
+		// IsClosed 已关闭或不再重连
+		IsClosed() bool
 		// Read 收包队列
 		Read() chan ControlPacket
 		// WritePacket 写入包
 		WritePacket(ctx context.Context, packet ControlPacket) error
+		// TryWritePacket 非阻塞写入 发送队列满时丢弃
+		TryWritePacket(packet ControlPacket) error
+		// Statistics 连接流量统计 首次连接成功前为nil
+		Statistics() FlowStatistics
+		// RTT 心跳测得的平滑往返时间
+		RTT() time.Duration
+		// Jitter 往返时间抖动
+		Jitter() time.Duration
+		// SetKeepalive 调整心跳间隔并通知对端
+		SetKeepalive(interval time.Duration)
+		// QueueStats 收发队列统计
+		QueueStats(direction QueueDirection) QueueStats
 	}
 
 	//ConnectionBroker 连接代理
@@ -49,8 +95,18 @@ type (
 		ConnectionID() string
 		// Keepalive 心跳时间
 		Keepalive() time.Duration
+		// Version 协商后的协议版本
+		Version() int
+		// Capabilities 协商后的能力
+		Capabilities() Capability
+		// Principal 鉴权通过的身份信息 未鉴权时为nil
+		Principal() *Principal
+		// Statistics 流量统计
+		Statistics() FlowStatistics
 		// WritePacket 发包
 		WritePacket(packet ControlPacket) error
+		// WritePackets 批量发包 合并为一次写入
+		WritePackets(packets []ControlPacket) error
 		// ReadPacket 读取单个包
 		ReadPacket() (ControlPacket, error)
 		// LocalAddr 本地地址
@@ -61,8 +117,15 @@ type (
 		Close() error
 	}
 
+	// UnreliableWriter 不可靠有序通道 丢失不重发 过期的包被对端丢弃
+	// 可靠UDP连接代理和基于它的连接层实现
+	UnreliableWriter interface {
+		WriteUnreliable(packet ControlPacket) error
+	}
+
 	// ControlPacket 连接层控制报文
 	ControlPacket interface {
+		Header() *FixedHeader
 		Name() string
 		String() string
 		Validate() int
@@ -0,0 +1,78 @@
+package network
+
+import "strings"
+
+// 协议版本协商
+// 客户端在 CONNECT 中携带其支持的最高版本和期望的能力
+// 服务端取双方最高版本的较小值 不在服务端支持范围内时拒绝连接
+// 能力取双方交集 通过 CONNECT_ACK 返回协商结果
+
+const (
+	ProtocolVersionV1 = 1
+	// CurrentProtocolVersion 当前协议版本
+	CurrentProtocolVersion = ProtocolVersionV1
+)
+
+// Capability 连接能力标记
+type Capability uint32
+
+const (
+	CapCompression  Capability = 1 << iota // 负载压缩
+	CapEncryption                          // 加密传输
+	CapAckMode                             // PUBLISH 确认模式
+	CapCompressGzip                        // gzip压缩算法
+	CapCompressZlib                        // zlib压缩算法
+	CapCompressLZ                          // LZ压缩算法
+)
+
+var capabilityNames = []string{
+	"COMPRESSION",
+	"ENCRYPTION",
+	"ACK_MODE",
+	"GZIP",
+	"ZLIB",
+	"LZ",
+}
+
+// Has 是否包含指定能力
+func (gs Capability) Has(capability Capability) bool {
+	return gs&capability == capability
+}
+
+func (gs Capability) String() string {
+	if gs == 0 {
+		return "NONE"
+	}
+	names := make([]string, 0, len(capabilityNames))
+	for i, name := range capabilityNames {
+		if gs.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// requestVersion 客户端请求的协议版本
+func requestVersion(cfg *BrokerConf) int {
+	if cfg.MaxVersion > 0 {
+		return cfg.MaxVersion
+	}
+	if cfg.Version > 0 {
+		return cfg.Version
+	}
+	return CurrentProtocolVersion
+}
+
+// negotiateVersion 服务端协商协议版本
+// 服务端未配置版本范围时接受客户端版本
+func negotiateVersion(cfg *BrokerConf, clientVersion int) (int, bool) {
+	version := clientVersion
+	if cfg.MaxVersion > 0 && version > cfg.MaxVersion {
+		version = cfg.MaxVersion
+	}
+	if version < cfg.MinVersion {
+		return 0, false
+	}
+
+	return version, true
+}
@@ -0,0 +1,338 @@
+package nettest
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"GameServer/common/network"
+)
+
+// 测试环境
+// 在内存监听器上启动服务端 客户端经 ConnectBroker/AcceptBroker 完成真实握手
+// 记录客户端的状态变化和收到的 DISCONNECT 提供重连、心跳超时和断开原因的场景断言
+// 业务方可通过服务端选项注册处理函数 无需网络即可测试
+
+var (
+	// DefaultWaitTimeout 断言等待的默认超时
+	DefaultWaitTimeout = 5 * time.Second
+	// DefaultCloseTimeout 测试结束时关闭服务端的超时
+	DefaultCloseTimeout = 2 * time.Second
+	// serverConnPollInterval 等待服务端注册连接的轮询间隔
+	serverConnPollInterval = 5 * time.Millisecond
+)
+
+// Harness 服务端和若干客户端组成的测试环境 测试结束时自动关闭
+type Harness struct {
+	Listener    *Listener
+	Server      *network.Server
+	ClientConf  network.BrokerConf // Dial 使用的客户端配置模板
+	WaitTimeout time.Duration      // 断言等待的超时 心跳超时场景需大于 心跳间隔*(容忍次数+1)
+
+	tb        testing.TB
+	clients   []*Client
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	closeOnce sync.Once
+	lock      sync.Mutex
+}
+
+// New 创建测试环境并启动服务端 cfg为nil时使用大端字节序的默认配置
+func New(tb testing.TB, cfg *network.BrokerConf, options ...network.ServerOption) *Harness {
+	tb.Helper()
+	if cfg == nil {
+		cfg = &network.BrokerConf{ByteOrder: binary.BigEndian}
+	}
+	instance := &Harness{
+		Listener: NewListener(),
+		Server:   network.NewServer("nettest", cfg, options...),
+		ClientConf: network.BrokerConf{
+			KeepaliveInterval: cfg.KeepaliveInterval,
+			Capabilities:      cfg.Capabilities,
+			ByteOrder:         cfg.ByteOrder,
+			FrameCodec:        cfg.FrameCodec,
+		},
+		WaitTimeout: DefaultWaitTimeout,
+		tb:          tb,
+	}
+	instance.ctx, instance.ctxCancel = context.WithCancel(context.Background())
+	go func() {
+		_ = instance.Server.Serve(instance.Listener)
+	}()
+	tb.Cleanup(instance.Close)
+
+	return instance
+}
+
+// Close 关闭所有客户端和服务端
+func (gs *Harness) Close() {
+	gs.closeOnce.Do(func() {
+		gs.lock.Lock()
+		clients := gs.clients
+		gs.lock.Unlock()
+
+		for _, client := range clients {
+			_ = client.Close()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultCloseTimeout)
+		defer cancel()
+		_ = gs.Server.Shutdown(ctx)
+		_ = gs.Listener.Close()
+		gs.ctxCancel()
+	})
+}
+
+// Dial 以客户端配置模板连接服务端
+func (gs *Harness) Dial(connectionID string, options ...network.KeeperOption) *Client {
+	gs.tb.Helper()
+	cfg := gs.ClientConf
+	cfg.ConnectionID = connectionID
+
+	return gs.DialConf(&cfg, options...)
+}
+
+// DialN 连接n个客户端 连接ID为 prefix0 ~ prefix(n-1)
+func (gs *Harness) DialN(prefix string, n int, options ...network.KeeperOption) []*Client {
+	gs.tb.Helper()
+	clients := make([]*Client, 0, n)
+	for i := 0; i < n; i++ {
+		clients = append(clients, gs.Dial(fmt.Sprintf("%s%d", prefix, i), options...))
+	}
+
+	return clients
+}
+
+// DialConf 以指定配置连接服务端 每个客户端使用独立的链路
+// 不等待连接完成 需要时调用 WaitState
+func (gs *Harness) DialConf(cfg *network.BrokerConf, options ...network.KeeperOption) *Client {
+	gs.tb.Helper()
+	client := &Client{
+		Link:    gs.Listener.NewLink(),
+		harness: gs,
+		changed: make(chan struct{}),
+	}
+	options = append(options,
+		network.WithStateCallback(client.onState),
+		network.WithDisconnectCallback(client.onDisconnect))
+	client.ConnectionLayer = network.NewClient(gs.ctx, cfg, network.DialBrokerFactory("nettest", cfg, client.Link.Dial), options...)
+
+	gs.lock.Lock()
+	gs.clients = append(gs.clients, client)
+	gs.lock.Unlock()
+
+	return client
+}
+
+// ServerConn 等待服务端注册指定连接
+func (gs *Harness) ServerConn(connectionID string) network.ConnectionLayer {
+	gs.tb.Helper()
+	deadline := time.Now().Add(gs.WaitTimeout)
+	for {
+		if conn, ok := gs.Server.Connection(connectionID); ok {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			gs.tb.Fatalf("nettest: server connection %s not registered in %s", connectionID, gs.WaitTimeout)
+			return nil
+		}
+		time.Sleep(serverConnPollInterval)
+	}
+}
+
+// AssertReconnect 突然断开链路 客户端应重连成功并重新注册到服务端
+// 客户端需配置 network.WithReconnect
+func (gs *Harness) AssertReconnect(client *Client) {
+	gs.tb.Helper()
+	client.ensureConnected()
+	dials := client.Link.Dials()
+
+	client.Link.Break()
+	client.WaitState(network.StateReconnecting)
+	client.WaitState(network.StateConnected)
+	if client.Link.Dials() <= dials {
+		gs.tb.Fatalf("nettest: %s connected without redial", client.ConnectionID())
+	}
+	gs.ServerConn(client.ConnectionID())
+}
+
+// AssertHeartbeatTimeout 链路变为黑洞 客户端应在心跳容忍次数内判定连接失效
+// 之后恢复链路 配置了重连时等待重连成功
+func (gs *Harness) AssertHeartbeatTimeout(client *Client) {
+	gs.tb.Helper()
+	client.ensureConnected()
+
+	client.Link.SetFaults(Faults{LossRate: 1})
+	state := client.WaitState(network.StateReconnecting, network.StateClosed)
+	client.Link.SetFaults(Faults{})
+	// 黑洞期间发起的握手等不到应答 断开后立即重新拨号 不必等待握手超时
+	client.Link.Break()
+	if state == network.StateReconnecting {
+		client.WaitState(network.StateConnected)
+	}
+}
+
+// AssertDisconnect 服务端携带原因关闭连接 客户端应收到相同的原因和重连建议
+// 不可重连的原因客户端应停止重连
+func (gs *Harness) AssertDisconnect(client *Client, reason network.DisconnectReason, reconnectAfter time.Duration) {
+	gs.tb.Helper()
+	client.ensureConnected()
+	conn := gs.ServerConn(client.ConnectionID())
+
+	ctx, cancel := context.WithTimeout(context.Background(), gs.WaitTimeout)
+	defer cancel()
+	if err := conn.CloseWithReason(ctx, reason, reconnectAfter); err != nil {
+		gs.tb.Fatalf("nettest: close %s with reason %s failed: %v", client.ConnectionID(), reason.String(), err)
+	}
+	gotReason, gotAfter := client.WaitDisconnect()
+	if gotReason != reason || gotAfter != reconnectAfter {
+		gs.tb.Fatalf("nettest: %s got disconnect %s after %s, want %s after %s",
+			client.ConnectionID(), gotReason.String(), gotAfter, reason.String(), reconnectAfter)
+	}
+	if !reason.Reconnectable() {
+		client.WaitState(network.StateClosed)
+	}
+}
+
+type disconnectEvent struct {
+	reason         network.DisconnectReason
+	reconnectAfter time.Duration
+}
+
+// Client 测试客户端 记录状态变化和收到的 DISCONNECT
+type Client struct {
+	network.ConnectionLayer
+	Link *Link
+
+	harness          *Harness
+	states           []network.ConnectionState
+	disconnects      []disconnectEvent
+	stateCursor      int
+	disconnectCursor int
+	changed          chan struct{} // 有新事件时关闭并替换
+	lock             sync.Mutex
+}
+
+func (gs *Client) onState(_ string, state network.ConnectionState) {
+	gs.lock.Lock()
+	gs.states = append(gs.states, state)
+	gs.notify()
+	gs.lock.Unlock()
+}
+
+func (gs *Client) onDisconnect(_ string, reason network.DisconnectReason, reconnectAfter time.Duration) {
+	gs.lock.Lock()
+	gs.disconnects = append(gs.disconnects, disconnectEvent{reason: reason, reconnectAfter: reconnectAfter})
+	gs.notify()
+	gs.lock.Unlock()
+}
+
+// notify 调用方持有锁
+func (gs *Client) notify() {
+	close(gs.changed)
+	gs.changed = make(chan struct{})
+}
+
+// wait 等待match在未消费的事件中找到结果
+func (gs *Client) wait(what string, match func() bool) {
+	gs.harness.tb.Helper()
+	timer := time.NewTimer(gs.harness.WaitTimeout)
+	defer timer.Stop()
+	for {
+		gs.lock.Lock()
+		found := match()
+		changed := gs.changed
+		gs.lock.Unlock()
+		if found {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			gs.harness.tb.Fatalf("nettest: %s wait %s timeout after %s", gs.ConnectionID(), what, gs.harness.WaitTimeout)
+			return
+		}
+	}
+}
+
+// WaitState 等待下一次变为states中任一状态 返回该状态
+// 状态变化按顺序消费 连续调用可断言状态序列
+func (gs *Client) WaitState(states ...network.ConnectionState) network.ConnectionState {
+	gs.harness.tb.Helper()
+	var got network.ConnectionState
+	gs.wait(fmt.Sprint("state ", states), func() bool {
+		for gs.stateCursor < len(gs.states) {
+			state := gs.states[gs.stateCursor]
+			gs.stateCursor++
+			for _, want := range states {
+				if state == want {
+					got = state
+					return true
+				}
+			}
+		}
+		return false
+	})
+
+	return got
+}
+
+// WaitDisconnect 等待下一个收到的 DISCONNECT
+func (gs *Client) WaitDisconnect() (network.DisconnectReason, time.Duration) {
+	gs.harness.tb.Helper()
+	var got disconnectEvent
+	gs.wait("disconnect", func() bool {
+		if gs.disconnectCursor >= len(gs.disconnects) {
+			return false
+		}
+		got = gs.disconnects[gs.disconnectCursor]
+		gs.disconnectCursor++
+		return true
+	})
+
+	return got.reason, got.reconnectAfter
+}
+
+// ensureConnected 已连接时跳过之前的状态变化 否则等待连接成功
+func (gs *Client) ensureConnected() {
+	gs.harness.tb.Helper()
+	gs.lock.Lock()
+	connected := len(gs.states) > 0 && gs.states[len(gs.states)-1] == network.StateConnected
+	if connected {
+		gs.stateCursor = len(gs.states)
+	}
+	gs.lock.Unlock()
+	if !connected {
+		gs.WaitState(network.StateConnected)
+	}
+}
+
+// ReadPacket 等待收包队列中的下一个包
+func (gs *Client) ReadPacket() network.ControlPacket {
+	gs.harness.tb.Helper()
+	select {
+	case packet, ok := <-gs.Read():
+		if !ok {
+			gs.harness.tb.Fatalf("nettest: %s read queue closed", gs.ConnectionID())
+		}
+		return packet
+	case <-time.After(gs.harness.WaitTimeout):
+		gs.harness.tb.Fatalf("nettest: %s read packet timeout after %s", gs.ConnectionID(), gs.harness.WaitTimeout)
+		return nil
+	}
+}
+
+// Publish 发送 PUBLISH 失败时终止测试
+func (gs *Client) Publish(payload []byte) {
+	gs.harness.tb.Helper()
+	packet := network.NewControlPacket(network.Publish).(*network.PublishPacket)
+	packet.Payload = payload
+
+	ctx, cancel := context.WithTimeout(context.Background(), gs.harness.WaitTimeout)
+	defer cancel()
+	if err := gs.WritePacket(ctx, packet); err != nil {
+		gs.harness.tb.Fatalf("nettest: %s publish failed: %v", gs.ConnectionID(), err)
+	}
+}
@@ -0,0 +1,61 @@
+package nettest
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"GameServer/common/network"
+)
+
+// testBackoff 测试中快速重连
+var testBackoff = network.Backoff{Initial: 10 * time.Millisecond, Max: 100 * time.Millisecond, Multiplier: 2}
+
+func TestAssertReconnect(t *testing.T) {
+	harness := New(t, nil)
+	client := harness.Dial("c1", network.WithReconnect(testBackoff, 0))
+
+	harness.AssertReconnect(client)
+	// 重连多次均可恢复
+	harness.AssertReconnect(client)
+}
+
+func TestAssertHeartbeatTimeout(t *testing.T) {
+	harness := New(t, &network.BrokerConf{ByteOrder: binary.BigEndian, KeepaliveInterval: 50})
+	client := harness.Dial("c1", network.WithReconnect(testBackoff, 0), network.WithHeartbeatMissBudget(2))
+
+	harness.AssertHeartbeatTimeout(client)
+	harness.ServerConn("c1")
+}
+
+func TestAssertDisconnect(t *testing.T) {
+	tests := []struct {
+		name           string
+		reason         network.DisconnectReason
+		reconnectAfter time.Duration
+	}{
+		{name: "reconnectable", reason: network.DisconnectServerShutdown, reconnectAfter: 20 * time.Millisecond},
+		{name: "kicked", reason: network.DisconnectKicked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			harness := New(t, nil)
+			client := harness.Dial("c1", network.WithReconnect(testBackoff, 0))
+
+			harness.AssertDisconnect(client, tt.reason, tt.reconnectAfter)
+			if tt.reason.Reconnectable() {
+				// 按对端建议等待后重连
+				client.WaitState(network.StateConnected)
+				harness.ServerConn("c1")
+				return
+			}
+
+			// 不可重连的原因 客户端不再拨号
+			dials := client.Link.Dials()
+			time.Sleep(10 * testBackoff.Initial)
+			if !client.IsClosed() || client.Link.Dials() != dials {
+				t.Fatalf("kicked client closed %v, dials %d -> %d", client.IsClosed(), dials, client.Link.Dials())
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package nettest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 带缓冲的内存管道
+// net.Pipe 没有缓冲 对端未读取时写入一直阻塞 与TCP的行为差异会导致测试中出现真实网络下不存在的死锁
+// 每个方向一个有界缓冲区模拟套接字缓冲 支持读写超时
+
+// DefaultPipeBufferSize 单方向的缓冲区大小
+var DefaultPipeBufferSize = 256 << 10
+
+// pipeBuffer 单方向的有界字节缓冲
+type pipeBuffer struct {
+	data     []byte
+	capacity int
+	closed   bool
+	changed  chan struct{} // 状态变化时关闭并替换
+	lock     sync.Mutex
+}
+
+func newPipeBuffer(capacity int) *pipeBuffer {
+	return &pipeBuffer{
+		capacity: capacity,
+		changed:  make(chan struct{}),
+	}
+}
+
+// notify 调用方持有锁
+func (gs *pipeBuffer) notify() {
+	close(gs.changed)
+	gs.changed = make(chan struct{})
+}
+
+func (gs *pipeBuffer) close() {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if !gs.closed {
+		gs.closed = true
+		gs.notify()
+	}
+}
+
+// deadline 读或写的超时时间 变化时唤醒等待中的读写
+type deadline struct {
+	at      atomic.Int64 // UnixNano 0不超时
+	changed chan struct{}
+	lock    sync.Mutex
+}
+
+func newDeadline() *deadline {
+	return &deadline{changed: make(chan struct{})}
+}
+
+func (gs *deadline) set(t time.Time) {
+	if t.IsZero() {
+		gs.at.Store(0)
+	} else {
+		gs.at.Store(t.UnixNano())
+	}
+
+	gs.lock.Lock()
+	close(gs.changed)
+	gs.changed = make(chan struct{})
+	gs.lock.Unlock()
+}
+
+func (gs *deadline) wait() (<-chan struct{}, time.Duration, bool) {
+	gs.lock.Lock()
+	changed := gs.changed
+	gs.lock.Unlock()
+
+	at := gs.at.Load()
+	if at == 0 {
+		return changed, 0, false
+	}
+	return changed, time.Until(time.Unix(0, at)), true
+}
+
+// pipeConn 管道的一端
+type pipeConn struct {
+	in            *pipeBuffer // 从该缓冲读取
+	out           *pipeBuffer // 向该缓冲写入
+	readDeadline  *deadline
+	writeDeadline *deadline
+	done          chan struct{}
+	once          sync.Once
+}
+
+// newPipe 创建一对相连的内存连接
+func newPipe(capacity int) (net.Conn, net.Conn) {
+	upstream, downstream := newPipeBuffer(capacity), newPipeBuffer(capacity)
+	client := &pipeConn{in: downstream, out: upstream, readDeadline: newDeadline(), writeDeadline: newDeadline(), done: make(chan struct{})}
+	server := &pipeConn{in: upstream, out: downstream, readDeadline: newDeadline(), writeDeadline: newDeadline(), done: make(chan struct{})}
+
+	return client, server
+}
+
+// await 等待缓冲变化 超时或本端关闭时返回错误
+func (gs *pipeConn) await(changed <-chan struct{}, deadline *deadline) error {
+	deadlineChanged, remain, ok := deadline.wait()
+	var timeout <-chan time.Time
+	if ok {
+		if remain <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(remain)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-changed:
+	case <-deadlineChanged:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-gs.done:
+		return net.ErrClosed
+	}
+	return nil
+}
+
+func (gs *pipeConn) Read(p []byte) (int, error) {
+	for {
+		select {
+		case <-gs.done:
+			return 0, net.ErrClosed
+		default:
+		}
+
+		buffer := gs.in
+		buffer.lock.Lock()
+		if len(buffer.data) > 0 {
+			n := copy(p, buffer.data)
+			buffer.data = buffer.data[n:]
+			buffer.notify()
+			buffer.lock.Unlock()
+			return n, nil
+		}
+		if buffer.closed {
+			buffer.lock.Unlock()
+			return 0, io.EOF
+		}
+		changed := buffer.changed
+		buffer.lock.Unlock()
+
+		if err := gs.await(changed, gs.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (gs *pipeConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		select {
+		case <-gs.done:
+			return written, net.ErrClosed
+		default:
+		}
+
+		buffer := gs.out
+		buffer.lock.Lock()
+		if buffer.closed {
+			buffer.lock.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if space := buffer.capacity - len(buffer.data); space > 0 {
+			n := min(space, len(p)-written)
+			buffer.data = append(buffer.data, p[written:written+n]...)
+			written += n
+			buffer.notify()
+			buffer.lock.Unlock()
+			continue
+		}
+		changed := buffer.changed
+		buffer.lock.Unlock()
+
+		if err := gs.await(changed, gs.writeDeadline); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Close 对端读完缓冲后读到 io.EOF 对端写入失败
+func (gs *pipeConn) Close() error {
+	gs.once.Do(func() {
+		close(gs.done)
+		gs.out.close()
+		gs.in.close()
+	})
+	return nil
+}
+
+func (gs *pipeConn) LocalAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (gs *pipeConn) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (gs *pipeConn) SetDeadline(t time.Time) error {
+	gs.readDeadline.set(t)
+	gs.writeDeadline.set(t)
+	return nil
+}
+
+func (gs *pipeConn) SetReadDeadline(t time.Time) error {
+	gs.readDeadline.set(t)
+	return nil
+}
+
+func (gs *pipeConn) SetWriteDeadline(t time.Time) error {
+	gs.writeDeadline.set(t)
+	return nil
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "nettest"
+}
+
+func (pipeAddr) String() string {
+	return "nettest"
+}
@@ -0,0 +1,214 @@
+package nettest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 内存传输层
+// 基于内存管道的监听器 每个客户端通过独立的链路拨号 链路可注入延迟、丢包、分段写入和突然断开
+// 故障作用于单次 Write 调用 连接代理每次写入完整的帧 丢弃整次写入即丢弃整包 不破坏帧边界
+// 每个方向的首次写入(握手的 CONNECT/CONNECT_ACK)不注入故障 握手丢失要等到拨号超时才会重连 拖慢测试
+// 加密连接丢包后对端解密失败会断开重连
+
+var (
+	ErrListenerClosed = errors.New("nettest listener closed")
+	ErrLinkOffline    = errors.New("nettest link offline")
+)
+
+// Faults 单方向的故障配置 零值为无故障
+type Faults struct {
+	Latency  time.Duration // 每次写入前的固定延迟
+	Jitter   time.Duration // 额外的随机延迟 [0, Jitter)
+	LossRate float64       // 丢弃整次写入的概率 [0, 1] 1时为黑洞
+	MaxChunk int           // >0 时每次写入拆成不超过该长度的随机分段 用于验证分段读取
+}
+
+// Listener 内存监听器 实现 net.Listener
+type Listener struct {
+	accept chan net.Conn
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewListener 创建内存监听器
+func NewListener() *Listener {
+	return &Listener{
+		accept: make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+}
+
+func (gs *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-gs.accept:
+		return conn, nil
+	case <-gs.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (gs *Listener) Close() error {
+	gs.once.Do(func() {
+		close(gs.done)
+	})
+	return nil
+}
+
+func (gs *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// NewLink 创建一条客户端链路
+func (gs *Listener) NewLink() *Link {
+	return &Link{
+		listener: gs,
+		conns:    make(map[*faultConn]struct{}),
+	}
+}
+
+// Link 客户端链路 同一客户端重连时复用 故障配置对当前和之后的连接生效
+type Link struct {
+	listener   *Listener
+	upstream   atomic.Pointer[Faults] // 客户端到服务端
+	downstream atomic.Pointer[Faults] // 服务端到客户端
+	offline    atomic.Bool
+	dials      atomic.Int32
+	conns      map[*faultConn]struct{}
+	lock       sync.Mutex
+}
+
+// SetFaults 双向设置相同的故障
+func (gs *Link) SetFaults(faults Faults) {
+	gs.SetUpstreamFaults(faults)
+	gs.SetDownstreamFaults(faults)
+}
+
+// SetUpstreamFaults 客户端到服务端方向的故障
+func (gs *Link) SetUpstreamFaults(faults Faults) {
+	gs.upstream.Store(&faults)
+}
+
+// SetDownstreamFaults 服务端到客户端方向的故障
+func (gs *Link) SetDownstreamFaults(faults Faults) {
+	gs.downstream.Store(&faults)
+}
+
+// SetOffline 离线时拨号失败 已建立的连接不受影响
+func (gs *Link) SetOffline(offline bool) {
+	gs.offline.Store(offline)
+}
+
+// Dials 拨号成功的次数 首次连接和每次重连各计一次
+func (gs *Link) Dials() int {
+	return int(gs.dials.Load())
+}
+
+// Break 突然断开链路上的所有连接 不发送 DISCONNECT
+func (gs *Link) Break() {
+	gs.lock.Lock()
+	conns := make([]*faultConn, 0, len(gs.conns))
+	for conn := range gs.conns {
+		conns = append(conns, conn)
+	}
+	gs.lock.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// Dial 建立一条到监听器的连接 用作 network.DialBrokerFactory 的拨号函数
+func (gs *Link) Dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	if gs.offline.Load() {
+		return nil, ErrLinkOffline
+	}
+	clientSide, serverSide := newPipe(DefaultPipeBufferSize)
+	client := gs.wrap(clientSide, &gs.upstream)
+	server := gs.wrap(serverSide, &gs.downstream)
+
+	select {
+	case gs.listener.accept <- server:
+		gs.dials.Add(1)
+		return client, nil
+	case <-gs.listener.done:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, ErrListenerClosed
+	case <-ctx.Done():
+		_ = client.Close()
+		_ = server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (gs *Link) wrap(conn net.Conn, faults *atomic.Pointer[Faults]) *faultConn {
+	instance := &faultConn{Conn: conn, link: gs, faults: faults}
+	gs.lock.Lock()
+	gs.conns[instance] = struct{}{}
+	gs.lock.Unlock()
+
+	return instance
+}
+
+func (gs *Link) remove(conn *faultConn) {
+	gs.lock.Lock()
+	delete(gs.conns, conn)
+	gs.lock.Unlock()
+}
+
+// faultConn 按链路的故障配置写入
+type faultConn struct {
+	net.Conn
+	link       *Link
+	faults     *atomic.Pointer[Faults]
+	handshaken atomic.Bool
+	once       sync.Once
+}
+
+func (gs *faultConn) Write(p []byte) (int, error) {
+	faults := gs.faults.Load()
+	if !gs.handshaken.Swap(true) || faults == nil {
+		return gs.Conn.Write(p)
+	}
+	if delay := faults.Latency + randDuration(faults.Jitter); delay > 0 {
+		time.Sleep(delay)
+	}
+	if faults.LossRate > 0 && rand.Float64() < faults.LossRate {
+		return len(p), nil
+	}
+	if faults.MaxChunk <= 0 {
+		return gs.Conn.Write(p)
+	}
+
+	written := 0
+	for written < len(p) {
+		chunk := min(len(p)-written, 1+rand.Intn(faults.MaxChunk))
+		n, err := gs.Conn.Write(p[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (gs *faultConn) Close() error {
+	gs.once.Do(func() {
+		gs.link.remove(gs)
+	})
+	return gs.Conn.Close()
+}
+
+func randDuration(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
@@ -0,0 +1,223 @@
+package network
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"time"
+)
+
+type ServerOption interface {
+	apply(server *Server)
+}
+
+type ServerOptionFunc func(server *Server)
+
+func (f ServerOptionFunc) apply(server *Server) {
+	f(server)
+}
+
+// WithMaxConnections 最大连接数 <=0 不限制
+func WithMaxConnections(maxConnections int) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.maxConnections = maxConnections
+	})
+}
+
+// WithHandshakeTimeout 握手超时时间
+func WithHandshakeTimeout(timeout time.Duration) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.handshakeTimeout = timeout
+	})
+}
+
+// WithOnConnectionOpen 连接建立回调
+func WithOnConnectionOpen(callback OnConnectionOpenCallback) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.openCallbacks = append(server.openCallbacks, callback)
+	})
+}
+
+// WithOnConnectionClose 连接关闭回调
+func WithOnConnectionClose(callback OnConnectionCloseCallback) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.closeCallbacks = append(server.closeCallbacks, callback)
+	})
+}
+
+// WithKeeperOptions 服务端连接层配置
+func WithKeeperOptions(options ...KeeperOption) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.keeperOptions = append(server.keeperOptions, options...)
+	})
+}
+
+// WithTLSConfig 在TLS之上接受连接 TLS握手计入握手超时
+func WithTLSConfig(config *tls.Config) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.tlsConfig = config
+	})
+}
+
+// WithAcceptFilters 接入过滤器 按添加顺序在读取 CONNECT 之前执行
+func WithAcceptFilters(filters ...AcceptFilter) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.acceptFilters = append(server.acceptFilters, filters...)
+	})
+}
+
+// WithRateLimit 每个连接的收包限速
+func WithRateLimit(conf RateLimitConf) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.brokerConf.RateLimit = &conf
+	})
+}
+
+// WithSessionManager 连接建立/关闭时由会话管理器创建/移除会话
+func WithSessionManager(manager *SessionManager) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.openCallbacks = append(server.openCallbacks, func(conn ConnectionLayer) {
+			manager.Open(conn)
+		})
+		server.closeCallbacks = append(server.closeCallbacks, manager.OnConnectionClose)
+	})
+}
+
+// WithGroupManager 连接关闭时自动退出所有广播分组
+func WithGroupManager(manager *GroupManager) ServerOption {
+	return ServerOptionFunc(func(server *Server) {
+		server.closeCallbacks = append(server.closeCallbacks, manager.OnConnectionClose)
+	})
+}
+
+type KeeperOption interface {
+	apply(keeper *TcpConnectionKeeper)
+}
+
+type KeeperOptionFunc func(keeper *TcpConnectionKeeper)
+
+func (f KeeperOptionFunc) apply(keeper *TcpConnectionKeeper) {
+	f(keeper)
+}
+
+// WithReconnect 连接断开后按退避策略重连
+// maxRetries 单次断线最大重试次数 <=0 不限制
+func WithReconnect(backoff Backoff, maxRetries int) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.reconnect = &reconnectPolicy{
+			backoff:    backoff,
+			maxRetries: maxRetries,
+		}
+	})
+}
+
+// WithOutboundQueueSize 发送队列大小
+// 断线期间写入的包在队列中保留 重连后继续发送
+func WithOutboundQueueSize(size int) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.outboundConf.Size = size
+	})
+}
+
+// WithOutboundQueue 发送队列配置
+func WithOutboundQueue(conf QueueConf) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.outboundConf = conf
+	})
+}
+
+// WithInboundQueue 收包队列配置 默认无缓冲阻塞
+func WithInboundQueue(conf QueueConf) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.inboundConf = conf
+	})
+}
+
+// WithQueueHighWatermark 队列深度达到高水位回调
+func WithQueueHighWatermark(callback OnQueueHighWatermarkCallback) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.watermarkCallbacks = append(keeper.watermarkCallbacks, callback)
+	})
+}
+
+// WithHeartbeatMissBudget 连续未确认的心跳数达到n时判定连接失效
+func WithHeartbeatMissBudget(n int) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		if n > 0 {
+			keeper.missBudget = n
+		}
+	})
+}
+
+// WithStateCallback 连接状态变化回调
+func WithStateCallback(callback OnConnectionStateCallback) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.stateCallbacks = append(keeper.stateCallbacks, callback)
+	})
+}
+
+// WithDisconnectCallback 收到对端 DISCONNECT 时回调
+func WithDisconnectCallback(callback OnDisconnectCallback) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.disconnectCallbacks = append(keeper.disconnectCallbacks, callback)
+	})
+}
+
+// WithReliableDelivery 启用 PUBLISH 至少一次投递 需在 BrokerConf.Capabilities 中请求 CapAckMode
+func WithReliableDelivery(conf ReliableConf) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.reliableConf = &conf
+	})
+}
+
+// WithCapture 记录连接读写的每个包 用于排查 可通过 netcap 工具查看和回放
+func WithCapture(capture *CaptureWriter) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.capture = capture
+	})
+}
+
+// withDuplicateFilter 使用外部保留的去重窗口 服务端用于跨重连去重
+func withDuplicateFilter(filter *duplicateFilter) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.duplicates = filter
+	})
+}
+
+// withConnectionID 预设连接ID 用于首次连接失败仍需重连的客户端
+func withConnectionID(connectionID string) KeeperOption {
+	return KeeperOptionFunc(func(keeper *TcpConnectionKeeper) {
+		keeper.connID = connectionID
+	})
+}
+
+type SessionManagerOption interface {
+	apply(manager *SessionManager)
+}
+
+type SessionManagerOptionFunc func(manager *SessionManager)
+
+func (f SessionManagerOptionFunc) apply(manager *SessionManager) {
+	f(manager)
+}
+
+// WithManagerPresentation 会话发送消息使用的表示层和字节序
+func WithManagerPresentation(presentation PresentationLayer, order binary.ByteOrder) SessionManagerOption {
+	return SessionManagerOptionFunc(func(manager *SessionManager) {
+		manager.presentation = presentation
+		manager.byteOrder = order
+	})
+}
+
+// WithIdleTimeout 会话超过该时间未收到消息时回调 OnIdle <=0 不检测
+func WithIdleTimeout(timeout time.Duration) SessionManagerOption {
+	return SessionManagerOptionFunc(func(manager *SessionManager) {
+		manager.idleTimeout = timeout
+	})
+}
+
+// WithDuplicateLoginPolicy 重复登录策略 默认踢掉旧会话
+func WithDuplicateLoginPolicy(policy DuplicateLoginPolicy) SessionManagerOption {
+	return SessionManagerOptionFunc(func(manager *SessionManager) {
+		manager.duplicatePolicy = policy
+	})
+}
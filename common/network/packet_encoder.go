@@ -0,0 +1,239 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"GameServer/common/pool"
+)
+
+// 包编码
+// 控制报文直接编码到对象池中的缓冲区 缓冲区头部预留固定包头的位置
+// 包体编码完成后回填固定包头 包体不再二次拷贝
+
+const (
+	// maxFixedHeaderSize 固定包头最大长度 1字节类型 + 最多4字节变长长度
+	maxFixedHeaderSize = 5
+	// MaxRemainLength 4字节变长长度可表示的最大包体长度
+	MaxRemainLength = 1<<28 - 1
+)
+
+var (
+	ErrPacketTooLarge = errors.New("packet too large")
+
+	packetBufferPool = pool.NewBufferPool()
+)
+
+// bodyEncoder 内置报文实现 包体直接追加到缓冲区
+type bodyEncoder interface {
+	encodeBody(buf *pool.Buffer, order binary.ByteOrder)
+}
+
+// encodePacket 将完整的包按帧格式追加到buf 返回buf中该包的帧
+// 返回的帧引用buf的底层数组 buf继续追加或归还后不可再使用
+func encodePacket(buf *pool.Buffer, packet ControlPacket, order binary.ByteOrder, codec FrameCodec) ([]byte, error) {
+	encoder, ok := packet.(bodyEncoder)
+	if !ok {
+		return appendPackedPacket(buf, packet, order, codec)
+	}
+
+	start := buf.Size()
+	var reserved [maxFrameHeaderSize]byte
+	buf.AppendBytes(reserved[:])
+	encoder.encodeBody(buf, order)
+
+	header := *packet.Header()
+	header.RemainLength = buf.Size() - start - maxFrameHeaderSize
+	if header.RemainLength > MaxRemainLength {
+		return nil, ErrPacketTooLarge
+	}
+	// 长度不变时不回写 预编码的包被多个写协程同时编码时只读
+	if packet.Header().RemainLength != header.RemainLength {
+		packet.Header().RemainLength = header.RemainLength
+	}
+	var fixed [maxFrameHeaderSize]byte
+	n := codec.PutHeader(fixed[:], &header, buf.Bytes()[start+maxFrameHeaderSize:], order)
+	// 帧头紧贴包体写入预留区的尾部
+	frame := buf.Bytes()[start+maxFrameHeaderSize-n:]
+	copy(frame, fixed[:n])
+
+	return frame, nil
+}
+
+// appendPackedPacket 外部实现的报文 使用其自身的打包 非默认帧格式时拆出包体重新组帧
+func appendPackedPacket(buf *pool.Buffer, packet ControlPacket, order binary.ByteOrder, codec FrameCodec) ([]byte, error) {
+	data, err := packet.Pack(order)
+	if err != nil {
+		return nil, err
+	}
+	start := buf.Size()
+	if codec == VarintFrameCodec {
+		buf.AppendBytes(data)
+		return buf.Bytes()[start:], nil
+	}
+
+	header, body, err := readFrame(bytes.NewReader(data), 0)
+	if err != nil {
+		return nil, err
+	}
+	var fixed [maxFrameHeaderSize]byte
+	buf.AppendBytes(fixed[:codec.PutHeader(fixed[:], &header, body, order)])
+	buf.AppendBytes(body)
+
+	return buf.Bytes()[start:], nil
+}
+
+// putFixedHeader 写入固定包头 返回长度 dst至少 maxFixedHeaderSize 字节
+func putFixedHeader(dst []byte, header *FixedHeader) int {
+	dst[0] = byte(header.PacketType)&PacketTypeMask | header.Flags&^PacketTypeMask
+	n := 1
+	length := header.RemainLength
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		dst[n] = digit
+		n++
+		if length == 0 {
+			return n
+		}
+	}
+}
+
+// packPacket 以默认帧格式编码为独立的字节数组
+func packPacket(packet ControlPacket, order binary.ByteOrder) ([]byte, error) {
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	frame, err := encodePacket(buf, packet, order, VarintFrameCodec)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(frame))
+	copy(data, frame)
+
+	return data, nil
+}
+
+// writePacket 以默认帧格式编码到池化缓冲区后写入
+func writePacket(w io.Writer, packet ControlPacket, order binary.ByteOrder) (int64, error) {
+	return WritePacketWithCodec(w, packet, order, VarintFrameCodec)
+}
+
+func appendUint32(buf *pool.Buffer, order binary.ByteOrder, v uint32) {
+	var b [4]byte
+	order.PutUint32(b[:], v)
+	buf.AppendBytes(b[:])
+}
+
+func appendUint64(buf *pool.Buffer, order binary.ByteOrder, v uint64) {
+	var b [8]byte
+	order.PutUint64(b[:], v)
+	buf.AppendBytes(b[:])
+}
+
+// appendBytesField 4字节长度前缀 与 utils.EncodeBytes 格式一致
+func appendBytesField(buf *pool.Buffer, order binary.ByteOrder, field []byte) {
+	appendUint32(buf, order, uint32(len(field)))
+	buf.AppendBytes(field)
+}
+
+func appendStringField(buf *pool.Buffer, order binary.ByteOrder, field string) {
+	appendUint32(buf, order, uint32(len(field)))
+	buf.AppendString(field)
+}
+
+// EncodedPacket 预编码的包
+// 编码一次后可写入多个连接 未加密、字节序和帧格式一致的连接直接共享同一份帧
+// 加密或需要压缩的连接退回到原始包逐个编码
+// 预编码的包不参与可靠投递
+type EncodedPacket struct {
+	packet ControlPacket
+	order  binary.ByteOrder
+	codec  FrameCodec
+	frame  []byte
+}
+
+// PreEncode 以默认帧格式预编码包 之后不可再修改原始包
+func PreEncode(packet ControlPacket, order binary.ByteOrder) (*EncodedPacket, error) {
+	return PreEncodeWithCodec(packet, order, VarintFrameCodec)
+}
+
+// PreEncodeWithCodec 以指定帧格式预编码包
+func PreEncodeWithCodec(packet ControlPacket, order binary.ByteOrder, codec FrameCodec) (*EncodedPacket, error) {
+	buf := packetBufferPool.Get()
+	defer buf.Free()
+
+	encoded, err := encodePacket(buf, packet, order, codec)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, len(encoded))
+	copy(frame, encoded)
+
+	return &EncodedPacket{
+		packet: packet,
+		order:  order,
+		codec:  codec,
+		frame:  frame,
+	}, nil
+}
+
+// Packet 原始包
+func (gs *EncodedPacket) Packet() ControlPacket {
+	return gs.packet
+}
+
+// Frame 编码后的帧 只读
+func (gs *EncodedPacket) Frame() []byte {
+	return gs.frame
+}
+
+func (gs *EncodedPacket) Header() *FixedHeader {
+	return gs.packet.Header()
+}
+
+func (gs *EncodedPacket) Name() string {
+	return gs.packet.Name()
+}
+
+func (gs *EncodedPacket) String() string {
+	return gs.packet.String()
+}
+
+func (gs *EncodedPacket) Validate() int {
+	return gs.packet.Validate()
+}
+
+func (gs *EncodedPacket) Pack(order binary.ByteOrder) ([]byte, error) {
+	if order != gs.order || gs.codec != VarintFrameCodec {
+		return gs.packet.Pack(order)
+	}
+	data := make([]byte, len(gs.frame))
+	copy(data, gs.frame)
+
+	return data, nil
+}
+
+// Unpack 预编码的包只用于发送
+func (gs *EncodedPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
+	return ErrInvalidPacketType
+}
+
+func (gs *EncodedPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
+	if order != gs.order || gs.codec != VarintFrameCodec {
+		return gs.packet.WriteTo(w, order)
+	}
+	n, err := w.Write(gs.frame)
+
+	return int64(n), err
+}
+
+// isPublish 是否为 PUBLISH 包括预编码的 PUBLISH
+func isPublish(packet ControlPacket) bool {
+	return packet.Header().PacketType == Publish
+}
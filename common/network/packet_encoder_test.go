@@ -0,0 +1,186 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"GameServer/gslog"
+	"GameServer/utils"
+)
+
+// discardConn 丢弃写入的数据 读取阻塞到关闭
+type discardConn struct {
+	net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newDiscardConn() *discardConn {
+	return &discardConn{closed: make(chan struct{})}
+}
+
+func (gs *discardConn) Read([]byte) (int, error) {
+	<-gs.closed
+	return 0, io.EOF
+}
+
+func (gs *discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (gs *discardConn) Close() error {
+	gs.once.Do(func() { close(gs.closed) })
+	return nil
+}
+
+func (gs *discardConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (gs *discardConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (gs *discardConn) SetDeadline(time.Time) error      { return nil }
+func (gs *discardConn) SetReadDeadline(time.Time) error  { return nil }
+func (gs *discardConn) SetWriteDeadline(time.Time) error { return nil }
+
+// legacyPack 池化编码之前的打包方式 包体和包头各自写入 bytes.Buffer 后拼接
+func legacyPack(packet *PublishPacket, order binary.ByteOrder) []byte {
+	var body bytes.Buffer
+	_ = binary.Write(&body, order, packet.MessageID)
+	body.Write(utils.EncodeBytes(packet.Payload, order))
+	packet.RemainLength = body.Len()
+	data := packet.FixedHeader.Pack()
+	data.Write(body.Bytes())
+
+	return data.Bytes()
+}
+
+const benchmarkBatchSize = 16
+
+func benchmarkPackets() []ControlPacket {
+	packets := make([]ControlPacket, benchmarkBatchSize)
+	for i := range packets {
+		packets[i] = newPublish(uint32(i+1), string(bytes.Repeat([]byte{'x'}, 256)))
+	}
+	return packets
+}
+
+// BenchmarkWritePacket 逐个打包后写入 每次操作写出一批包
+func BenchmarkWritePacket(b *testing.B) {
+	conn := newDiscardConn()
+	packets := benchmarkPackets()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, packet := range packets {
+			if _, err := conn.Write(legacyPack(packet.(*PublishPacket), binary.BigEndian)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkWritePackets 一批包编码到池化缓冲区后一次写出
+func BenchmarkWritePackets(b *testing.B) {
+	broker := NewConnectionBroker(newDiscardConn(), &BrokerConf{ConnectionID: "bench", ByteOrder: binary.BigEndian})
+	packets := benchmarkPackets()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := broker.WritePackets(packets); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBroadcast 向多个连接发送同一个包 每个连接各自编码与预编码一次后共享
+func BenchmarkBroadcast(b *testing.B) {
+	const connections = 100
+	order := binary.BigEndian
+	brokers := make([]ConnectionBroker, connections)
+	for i := range brokers {
+		brokers[i] = NewConnectionBroker(newDiscardConn(), &BrokerConf{ConnectionID: strconv.Itoa(i), ByteOrder: order})
+	}
+	packet := newPublish(0, string(bytes.Repeat([]byte{'x'}, 256)))
+
+	b.Run("Pack", func(b *testing.B) {
+		conns := make([]net.Conn, connections)
+		for i := range conns {
+			conns[i] = newDiscardConn()
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, conn := range conns {
+				if _, err := conn.Write(legacyPack(packet, order)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("PreEncode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encoded, err := PreEncode(packet, order)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, broker := range brokers {
+				if err = broker.WritePacket(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkMulticast 通过连接层广播 计入写协程的编码和写出
+func BenchmarkMulticast(b *testing.B) {
+	const connections = 100
+	// 写协程的逐包日志不计入
+	logger := gslog.Default()
+	gslog.SetDefault(gslog.NewLogger(gslog.NewTextHandler(io.Discard, gslog.WithLevelEnabler(gslog.ErrorLevel))))
+	defer gslog.SetDefault(logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conns := make([]ConnectionLayer, connections)
+	for i := range conns {
+		broker := NewConnectionBroker(newDiscardConn(), &BrokerConf{ConnectionID: strconv.Itoa(i), ByteOrder: binary.BigEndian})
+		conns[i] = NewTcpConnectionKeeper(ctx, oneShotBrokerFactory(broker), WithOutboundQueueSize(1024))
+	}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	packet := newPublish(0, string(bytes.Repeat([]byte{'x'}, 256)))
+
+	// waitDrained 等待写协程取空发送队列
+	waitDrained := func() {
+		for _, conn := range conns {
+			for conn.QueueStats(QueueOutbound).Depth > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	b.Run("TryWritePacket", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, conn := range conns {
+				_ = conn.TryWritePacket(packet)
+			}
+			waitDrained()
+		}
+	})
+	b.Run("Multicast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Multicast(conns, packet, binary.BigEndian); err != nil {
+				b.Fatal(err)
+			}
+			waitDrained()
+		}
+	})
+}
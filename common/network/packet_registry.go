@@ -0,0 +1,140 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// 包类型注册表
+// 内置控制报文和业务自定义的控制报文(如踢人原因、服务器公告、对时)统一在此注册
+// 解包、包名和连接层分发都通过注册表查找 注册一般在 init 中完成
+
+var (
+	ErrPacketTypeRegistered = errors.New("packet type already registered")
+	ErrPacketNameRegistered = errors.New("packet name already registered")
+	ErrPacketTypeOutOfRange = errors.New("packet type out of range")
+	ErrInvalidPacketInfo    = errors.New("invalid packet type info")
+)
+
+// PacketFactory 根据固定包头创建待解包的空包
+type PacketFactory func(header FixedHeader) ControlPacket
+
+// PacketHandler 连接层读协程收到该类型的包时调用 不可阻塞
+// 返回true表示已处理 不再投递到收包队列
+type PacketHandler func(conn ConnectionLayer, packet ControlPacket) bool
+
+// PacketTypeInfo 包类型注册信息
+type PacketTypeInfo struct {
+	Type    PacketType
+	Name    string
+	Factory PacketFactory
+	Handler PacketHandler // 可为nil 未处理的包投递到收包队列
+}
+
+type packetRegistry struct {
+	types [PacketTypeMask + 1]atomic.Pointer[PacketTypeInfo] // 查找无锁
+	names map[string]PacketType
+	lock  sync.Mutex // 注册互斥
+}
+
+var registry = &packetRegistry{
+	names: make(map[string]PacketType),
+}
+
+func init() {
+	builtin := []PacketTypeInfo{
+		{Type: Connect, Factory: func(header FixedHeader) ControlPacket { return &ConnectPacket{FixedHeader: header} }},
+		{Type: ConnectAck, Factory: func(header FixedHeader) ControlPacket { return &ConnectAckPacket{FixedHeader: header} }},
+		{Type: Heartbeat, Factory: func(header FixedHeader) ControlPacket { return &HeartbeatPacket{FixedHeader: header} }},
+		{Type: HeartbeatAck, Factory: func(header FixedHeader) ControlPacket { return &HeartbeatAckPacket{FixedHeader: header} }},
+		{Type: Publish, Factory: func(header FixedHeader) ControlPacket { return &PublishPacket{FixedHeader: header} }},
+		{Type: PublishAck, Factory: func(header FixedHeader) ControlPacket { return &PublishAckPacket{FixedHeader: header} }},
+		{Type: DisConnect, Factory: func(header FixedHeader) ControlPacket { return &DisConnectPacket{FixedHeader: header} }},
+	}
+	for _, info := range builtin {
+		info.Name = PacketNames[info.Type]
+		MustRegisterPacketType(info)
+	}
+}
+
+// RegisterPacketType 注册包类型 类型或包名已注册时返回错误
+func RegisterPacketType(info PacketTypeInfo) error {
+	if info.Type == Invalid || byte(info.Type) > PacketTypeMask {
+		return fmt.Errorf("%w: %d", ErrPacketTypeOutOfRange, info.Type)
+	}
+	if info.Name == "" || info.Factory == nil {
+		return ErrInvalidPacketInfo
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if exist := registry.types[info.Type].Load(); exist != nil {
+		return fmt.Errorf("%w: %d is %s", ErrPacketTypeRegistered, info.Type, exist.Name)
+	}
+	if exist, ok := registry.names[info.Name]; ok {
+		return fmt.Errorf("%w: %s is %d", ErrPacketNameRegistered, info.Name, exist)
+	}
+	registry.names[info.Name] = info.Type
+	registry.types[info.Type].Store(&info)
+
+	return nil
+}
+
+// MustRegisterPacketType 注册包类型 冲突时panic 用于 init
+func MustRegisterPacketType(info PacketTypeInfo) {
+	if err := RegisterPacketType(info); err != nil {
+		panic(err)
+	}
+}
+
+// LookupPacketType 查找已注册的包类型
+func LookupPacketType(packetType PacketType) (PacketTypeInfo, bool) {
+	info := registry.lookup(packetType)
+	if info == nil {
+		return PacketTypeInfo{}, false
+	}
+	return *info, true
+}
+
+// PacketTypeByName 根据包名查找包类型
+func PacketTypeByName(name string) (PacketType, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	packetType, ok := registry.names[name]
+	return packetType, ok
+}
+
+func (gs *packetRegistry) lookup(packetType PacketType) *PacketTypeInfo {
+	if byte(packetType) > PacketTypeMask {
+		return nil
+	}
+	return gs.types[packetType].Load()
+}
+
+// String 包名 未注册的类型返回编号
+func (gs PacketType) String() string {
+	if info := registry.lookup(gs); info != nil {
+		return info.Name
+	}
+	if gs == Invalid {
+		return PacketNames[Invalid]
+	}
+	return fmt.Sprintf("UNKNOWN_%d", byte(gs))
+}
+
+// isKnownPacketType 是否为可解码的包类型
+func isKnownPacketType(packetType PacketType) bool {
+	return registry.lookup(packetType) != nil
+}
+
+// packetHandler 包类型注册的连接层处理函数
+func packetHandler(packetType PacketType) PacketHandler {
+	if info := registry.lookup(packetType); info != nil {
+		return info.Handler
+	}
+	return nil
+}
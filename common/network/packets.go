@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
+	"GameServer/common/pool"
 	"GameServer/utils"
 )
 
@@ -23,13 +25,70 @@ const (
 	DisConnect
 )
 
+const (
+	// DefaultMaxPacketSize 默认最大包体长度
+	DefaultMaxPacketSize = 4 << 20
+)
+
+const (
+	// PacketTypeMask 首字节低6位为包类型 高2位为标记位
+	PacketTypeMask byte = 0x3F
+	// FlagCompressed 负载已压缩
+	FlagCompressed byte = 0x80
+	// FlagEncrypted 包体已加密
+	FlagEncrypted byte = 0x40
+)
+
 const (
 	Accepted                  = 0
 	RefusedBadProtocolVersion = 1
 	RefusedInvalidIdentifier  = 2
+	RefusedServerUnavailable  = 3
+	RefusedBadCredentials     = 4
+	RefusedBanned             = 5
+	RefusedNotAuthorized      = 6
+	RefusedKeyExchangeFailed  = 7
 )
 
+// DisconnectReason DISCONNECT 携带的断开原因
+type DisconnectReason byte
+
+const (
+	DisconnectNormal         DisconnectReason = iota // 正常关闭
+	DisconnectServerShutdown                         // 服务器停机或滚动更新
+	DisconnectDuplicateLogin                         // 重复登录被踢
+	DisconnectKicked                                 // 业务踢人
+	DisconnectIdleTimeout                            // 空闲超时
+	DisconnectProtocolError                          // 协议错误
+	DisconnectServerBusy                             // 服务器繁忙
+	DisconnectRateLimited                            // 发包超出限速
+)
+
+var disconnectReasonNames = []string{
+	"NORMAL",
+	"SERVER_SHUTDOWN",
+	"DUPLICATE_LOGIN",
+	"KICKED",
+	"IDLE_TIMEOUT",
+	"PROTOCOL_ERROR",
+	"SERVER_BUSY",
+	"RATE_LIMITED",
+}
+
+func (gs DisconnectReason) String() string {
+	if int(gs) >= len(disconnectReasonNames) {
+		return fmt.Sprintf("UNKNOWN_%d", byte(gs))
+	}
+	return disconnectReasonNames[gs]
+}
+
+// Reconnectable 该原因断开后客户端是否应当重连
+func (gs DisconnectReason) Reconnectable() bool {
+	return gs != DisconnectDuplicateLogin && gs != DisconnectKicked
+}
+
 var (
+	// PacketNames 内置包名 自定义包名见 RegisterPacketType
 	PacketNames = []string{
 		"INVALID",
 		"CONNECT",
@@ -44,34 +103,70 @@ var (
 	ErrInvalidPacketType        = errors.New("invalid packet type")
 	ErrBadProtocolVersion       = errors.New("connection refused: bad protocol version")
 	ErrRefusedInvalidIdentifier = errors.New("connection refused: invalid client identifier")
+	ErrServerUnavailable        = errors.New("connection refused: server unavailable")
+	ErrBadCredentials           = errors.New("connection refused: bad credentials")
+	ErrBanned                   = errors.New("connection refused: banned")
+	ErrNotAuthorized            = errors.New("connection refused: not authorized")
+	ErrKeyExchangeFailed        = errors.New("connection refused: key exchange failed")
+	ErrConnectionRefused        = errors.New("connection refused")
 	ErrReadExpectedDataFailed   = errors.New("read expected data failed")
+	ErrUnexpectedCompression    = errors.New("compressed packet without negotiated compression")
+	ErrMalformedPacket          = errors.New("malformed packet")
+	ErrTrailingBytes            = errors.New("trailing bytes after packet body")
 
 	RetCodeErrors = map[int]error{
 		Accepted:                  nil,
 		RefusedBadProtocolVersion: ErrBadProtocolVersion,
 		RefusedInvalidIdentifier:  ErrRefusedInvalidIdentifier,
+		RefusedServerUnavailable:  ErrServerUnavailable,
+		RefusedBadCredentials:     ErrBadCredentials,
+		RefusedBanned:             ErrBanned,
+		RefusedNotAuthorized:      ErrNotAuthorized,
+		RefusedKeyExchangeFailed:  ErrKeyExchangeFailed,
 	}
 )
 
+// RetCodeError 返回码对应的错误 未知的拒绝码返回 ErrConnectionRefused
+func RetCodeError(returnCode int) error {
+	if err, ok := RetCodeErrors[returnCode]; ok {
+		return err
+	}
+	return ErrConnectionRefused
+}
+
 // FixedHeader 固定包头 所有控制报文都含有
 type FixedHeader struct {
 	PacketType   PacketType
+	Flags        byte // 标记位 与包类型共用首字节
 	RemainLength int
 }
 
 func (gs *FixedHeader) String() string {
-	return fmt.Sprintf("[%s] RemainLength: %d", PacketNames[gs.PacketType], gs.RemainLength)
+	if gs.Flags != 0 {
+		return fmt.Sprintf("[%s] Flags: 0x%02X, RemainLength: %d", gs.PacketType.String(), gs.Flags, gs.RemainLength)
+	}
+	return fmt.Sprintf("[%s] RemainLength: %d", gs.PacketType.String(), gs.RemainLength)
+}
+
+// Header 固定包头 Pack 后 RemainLength 为包体长度
+func (gs *FixedHeader) Header() *FixedHeader {
+	return gs
+}
+
+// HasFlag 是否包含指定标记
+func (gs *FixedHeader) HasFlag(flag byte) bool {
+	return gs.Flags&flag == flag
 }
 
 func (gs *FixedHeader) Name() string {
-	return PacketNames[gs.PacketType]
+	return gs.PacketType.String()
 }
 
 // Pack 打包固定包头
 func (gs *FixedHeader) Pack() bytes.Buffer {
 	var header bytes.Buffer
 
-	header.WriteByte(byte(gs.PacketType))
+	header.WriteByte(byte(gs.PacketType)&PacketTypeMask | gs.Flags&^PacketTypeMask)
 	header.Write(utils.EncodeVariableInt(int64(gs.RemainLength)))
 
 	return header
@@ -86,60 +181,31 @@ func (gs *FixedHeader) UnPack(packetType PacketType, r io.Reader) error {
 	return err
 }
 
-// NewControlPacket 创建协议包
+// NewControlPacket 创建协议包 未注册的类型返回nil
 func NewControlPacket(packetType PacketType) ControlPacket {
-	fixedHeader := FixedHeader{PacketType: packetType}
-
-	switch packetType {
-	case Connect:
-		return &ConnectPacket{FixedHeader: fixedHeader}
-	case ConnectAck:
-		return &ConnectAckPacket{FixedHeader: fixedHeader}
-	case Heartbeat:
-		return &HeartbeatPacket{FixedHeader: fixedHeader}
-	case HeartbeatAck:
-		return &HeartbeatAckPacket{FixedHeader: fixedHeader}
-	case Publish:
-		return &PublishPacket{FixedHeader: fixedHeader}
-	case PublishAck:
-		return &PublishAckPacket{FixedHeader: fixedHeader}
-	case DisConnect:
-		return &DisConnectPacket{FixedHeader: fixedHeader}
-	case Invalid:
-		fallthrough
-	default:
-		return nil
-	}
+	packet, _ := NewControlPacketWithHeader(FixedHeader{PacketType: packetType})
+	return packet
 }
 
+// NewControlPacketWithHeader 根据固定包头创建协议包
 func NewControlPacketWithHeader(fh FixedHeader) (ControlPacket, error) {
-	switch fh.PacketType {
-	case Connect:
-		return &ConnectPacket{FixedHeader: fh}, nil
-	case ConnectAck:
-		return &ConnectAckPacket{FixedHeader: fh}, nil
-	case Heartbeat:
-		return &HeartbeatPacket{FixedHeader: fh}, nil
-	case HeartbeatAck:
-		return &HeartbeatAckPacket{FixedHeader: fh}, nil
-	case Publish:
-		return &PublishPacket{FixedHeader: fh}, nil
-	case PublishAck:
-		return &PublishAckPacket{FixedHeader: fh}, nil
-	case DisConnect:
-		return &DisConnectPacket{FixedHeader: fh}, nil
-	case Invalid:
-		fallthrough
-	default:
+	info := registry.lookup(fh.PacketType)
+	if info == nil {
 		return nil, ErrInvalidPacketType
 	}
+
+	return info.Factory(fh), nil
 }
 
 type ConnectPacket struct {
 	FixedHeader
-	ProtocolVersion  int    // 协议版本
-	Keepalive        int    // 心跳时间
-	ClientIdentifier string // 客户端唯一标识
+	ProtocolVersion  int        // 协议版本
+	Keepalive        int        // 心跳间隔 毫秒
+	Capabilities     Capability // 期望的能力
+	ClientIdentifier string     // 客户端唯一标识
+	AuthMethod       string     // 鉴权方式
+	AuthData         []byte     // 鉴权数据 如签名令牌
+	PublicKey        []byte     // 密钥交换公钥 请求加密时携带
 }
 
 func (gs *ConnectPacket) Validate() int {
@@ -152,46 +218,65 @@ func (gs *ConnectPacket) Validate() int {
 }
 
 func (gs *ConnectPacket) String() string {
-	return fmt.Sprintf("%s ,protocolVersion:%d, keepalive:%d, clientIdentifier:%s",
-		gs.FixedHeader.String(), gs.ProtocolVersion, gs.Keepalive, gs.ClientIdentifier)
+	return fmt.Sprintf("%s ,protocolVersion:%d, keepalive:%d, capabilities:%s, clientIdentifier:%s, authMethod:%s",
+		gs.FixedHeader.String(), gs.ProtocolVersion, gs.Keepalive, gs.Capabilities.String(), gs.ClientIdentifier, gs.AuthMethod)
 }
 
 func (gs *ConnectPacket) Pack(order binary.ByteOrder) ([]byte, error) {
-	var body bytes.Buffer
-	var err error
-
-	err = binary.Write(&body, order, gs.ProtocolVersion)
-	err = binary.Write(&body, order, gs.Keepalive)
-	err = binary.Write(&body, order, utils.EncodeString(gs.ClientIdentifier, order))
-
-	gs.FixedHeader.RemainLength = body.Len()
-	packet := gs.FixedHeader.Pack()
-	packet.Write(body.Bytes())
+	return packPacket(gs, order)
+}
 
-	return packet.Bytes(), err
+func (gs *ConnectPacket) encodeBody(buf *pool.Buffer, order binary.ByteOrder) {
+	// int 非定长类型 统一按int32写入
+	appendUint32(buf, order, uint32(int32(gs.ProtocolVersion)))
+	appendUint32(buf, order, uint32(int32(gs.Keepalive)))
+	appendUint32(buf, order, uint32(gs.Capabilities))
+	appendStringField(buf, order, gs.ClientIdentifier)
+	appendStringField(buf, order, gs.AuthMethod)
+	appendBytesField(buf, order, gs.AuthData)
+	appendBytesField(buf, order, gs.PublicKey)
 }
 
 func (gs *ConnectPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
 	var err error
-	err = binary.Read(r, order, &gs.ProtocolVersion)
-	err = binary.Read(r, order, &gs.Keepalive)
-	gs.ClientIdentifier, err = utils.DecodeReaderString(r, order)
+	var protocolVersion, keepalive int32
+	var capabilities uint32
+	if err = binary.Read(r, order, &protocolVersion); err != nil {
+		return err
+	}
+	if err = binary.Read(r, order, &keepalive); err != nil {
+		return err
+	}
+	if err = binary.Read(r, order, &capabilities); err != nil {
+		return err
+	}
+	gs.ProtocolVersion = int(protocolVersion)
+	gs.Keepalive = int(keepalive)
+	gs.Capabilities = Capability(capabilities)
+	if gs.ClientIdentifier, err = utils.DecodeReaderString(r, order); err != nil {
+		return err
+	}
+	if gs.AuthMethod, err = utils.DecodeReaderString(r, order); err != nil {
+		return err
+	}
+	if gs.AuthData, err = utils.DecodeReaderBytes(r, order); err != nil {
+		return err
+	}
+	gs.PublicKey, err = utils.DecodeReaderBytes(r, order)
 
 	return err
 }
 
 func (gs *ConnectPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
-	data, err := gs.Pack(order)
-	if err != nil {
-		return 0, err
-	}
-	n, err := w.Write(data)
-	return int64(n), err
+	return writePacket(w, gs, order)
 }
 
 type ConnectAckPacket struct {
 	FixedHeader
-	ReturnCode int
+	ReturnCode      int
+	ProtocolVersion int        // 协商后的协议版本
+	Capabilities    Capability // 协商后的能力
+	PublicKey       []byte     // 密钥交换公钥 协商加密时携带
 }
 
 func (gs *ConnectAckPacket) Validate() int {
@@ -200,117 +285,159 @@ func (gs *ConnectAckPacket) Validate() int {
 }
 
 func (gs *ConnectAckPacket) String() string {
-	return fmt.Sprintf("%s , returnCode:%d", gs.FixedHeader.String(), gs.ReturnCode)
+	return fmt.Sprintf("%s , returnCode:%d, protocolVersion:%d, capabilities:%s",
+		gs.FixedHeader.String(), gs.ReturnCode, gs.ProtocolVersion, gs.Capabilities.String())
 }
 
 func (gs *ConnectAckPacket) Pack(order binary.ByteOrder) ([]byte, error) {
-	var body bytes.Buffer
-	var err error
-
-	err = binary.Write(&body, order, gs.ReturnCode)
-
-	gs.FixedHeader.RemainLength = body.Len()
-	packet := gs.FixedHeader.Pack()
-	packet.Write(body.Bytes())
+	return packPacket(gs, order)
+}
 
-	return packet.Bytes(), err
+func (gs *ConnectAckPacket) encodeBody(buf *pool.Buffer, order binary.ByteOrder) {
+	appendUint32(buf, order, uint32(int32(gs.ReturnCode)))
+	appendUint32(buf, order, uint32(int32(gs.ProtocolVersion)))
+	appendUint32(buf, order, uint32(gs.Capabilities))
+	appendBytesField(buf, order, gs.PublicKey)
 }
 
 func (gs *ConnectAckPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
+	var returnCode, protocolVersion int32
+	var capabilities uint32
+	if err := binary.Read(r, order, &returnCode); err != nil {
+		return err
+	}
+	if err := binary.Read(r, order, &protocolVersion); err != nil {
+		return err
+	}
+	if err := binary.Read(r, order, &capabilities); err != nil {
+		return err
+	}
+	gs.ReturnCode = int(returnCode)
+	gs.ProtocolVersion = int(protocolVersion)
+	gs.Capabilities = Capability(capabilities)
 	var err error
-	err = binary.Read(r, order, &gs.ReturnCode)
+	gs.PublicKey, err = utils.DecodeReaderBytes(r, order)
 
 	return err
 }
 
 func (gs *ConnectAckPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
-	data, err := gs.Pack(order)
-	if err != nil {
-		return 0, err
-	}
-	n, err := w.Write(data)
-	return int64(n), err
+	return writePacket(w, gs, order)
 }
 
 type HeartbeatPacket struct {
 	FixedHeader
+	Timestamp int64 // 发送时间 对端原样回传 单调时钟纳秒
+	Interval  int32 // 通知对端调整后的心跳间隔 毫秒 0不调整
 }
 
 func (gs *HeartbeatPacket) Validate() int {
 	return 0
 }
 
+func (gs *HeartbeatPacket) String() string {
+	return fmt.Sprintf("%s , timestamp:%d, interval:%d", gs.FixedHeader.String(), gs.Timestamp, gs.Interval)
+}
+
 func (gs *HeartbeatPacket) Pack(order binary.ByteOrder) ([]byte, error) {
-	packet := gs.FixedHeader.Pack()
+	return packPacket(gs, order)
+}
 
-	return packet.Bytes(), nil
+func (gs *HeartbeatPacket) encodeBody(buf *pool.Buffer, order binary.ByteOrder) {
+	appendUint64(buf, order, uint64(gs.Timestamp))
+	appendUint32(buf, order, uint32(gs.Interval))
 }
 
 func (gs *HeartbeatPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
-	return nil
+	if err := binary.Read(r, order, &gs.Timestamp); err != nil {
+		return err
+	}
+	return binary.Read(r, order, &gs.Interval)
 }
 
 func (gs *HeartbeatPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
-	data, err := gs.Pack(order)
-	if err != nil {
-		return 0, err
-	}
-	n, err := w.Write(data)
-	return int64(n), err
+	return writePacket(w, gs, order)
 }
 
 type HeartbeatAckPacket struct {
 	FixedHeader
+	Timestamp int64 // 回传的心跳发送时间 单调时钟纳秒
+	Interval  int32 // 通知对端调整后的心跳间隔 毫秒 0不调整
 }
 
 func (gs *HeartbeatAckPacket) Validate() int {
 	return 0
 }
 
+func (gs *HeartbeatAckPacket) String() string {
+	return fmt.Sprintf("%s , timestamp:%d, interval:%d", gs.FixedHeader.String(), gs.Timestamp, gs.Interval)
+}
+
 func (gs *HeartbeatAckPacket) Pack(order binary.ByteOrder) ([]byte, error) {
-	packet := gs.FixedHeader.Pack()
+	return packPacket(gs, order)
+}
 
-	return packet.Bytes(), nil
+func (gs *HeartbeatAckPacket) encodeBody(buf *pool.Buffer, order binary.ByteOrder) {
+	appendUint64(buf, order, uint64(gs.Timestamp))
+	appendUint32(buf, order, uint32(gs.Interval))
 }
 
 func (gs *HeartbeatAckPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
-	return nil
+	if err := binary.Read(r, order, &gs.Timestamp); err != nil {
+		return err
+	}
+	return binary.Read(r, order, &gs.Interval)
 }
 
 func (gs *HeartbeatAckPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
-	data, err := gs.Pack(order)
-	if err != nil {
-		return 0, err
-	}
-	n, err := w.Write(data)
-	return int64(n), err
+	return writePacket(w, gs, order)
 }
 
 type DisConnectPacket struct {
 	FixedHeader
+	Reason         DisconnectReason
+	ReconnectAfter int32 // 建议客户端等待多久后重连 毫秒 0不限制
+}
+
+// NewDisConnectPacket 创建携带断开原因的 DISCONNECT
+func NewDisConnectPacket(reason DisconnectReason, reconnectAfter time.Duration) *DisConnectPacket {
+	packet := NewControlPacket(DisConnect).(*DisConnectPacket)
+	packet.Reason = reason
+	packet.ReconnectAfter = int32(reconnectAfter / time.Millisecond)
+
+	return packet
 }
 
 func (gs *DisConnectPacket) Validate() int {
 	return 0
 }
 
+func (gs *DisConnectPacket) String() string {
+	return fmt.Sprintf("%s , reason:%s, reconnectAfter:%d", gs.FixedHeader.String(), gs.Reason.String(), gs.ReconnectAfter)
+}
+
 func (gs *DisConnectPacket) Pack(order binary.ByteOrder) ([]byte, error) {
-	packet := gs.FixedHeader.Pack()
+	return packPacket(gs, order)
+}
 
-	return packet.Bytes(), nil
+func (gs *DisConnectPacket) encodeBody(buf *pool.Buffer, order binary.ByteOrder) {
+	buf.AppendByte(byte(gs.Reason))
+	appendUint32(buf, order, uint32(gs.ReconnectAfter))
 }
 
+// Unpack 兼容不带原因的空包体
 func (gs *DisConnectPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
-	return nil
+	if gs.RemainLength == 0 {
+		return nil
+	}
+	if err := binary.Read(r, order, &gs.Reason); err != nil {
+		return err
+	}
+	return binary.Read(r, order, &gs.ReconnectAfter)
 }
 
 func (gs *DisConnectPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
-	data, err := gs.Pack(order)
-	if err != nil {
-		return 0, err
-	}
-	n, err := w.Write(data)
-	return int64(n), err
+	return writePacket(w, gs, order)
 }
 
 type PublishPacket struct {
@@ -328,34 +455,26 @@ func (gs *PublishPacket) String() string {
 }
 
 func (gs *PublishPacket) Pack(order binary.ByteOrder) ([]byte, error) {
-	var body bytes.Buffer
-	var err error
-
-	err = binary.Write(&body, order, gs.MessageID)
-	err = binary.Write(&body, order, utils.EncodeBytes(gs.Payload, order))
-
-	gs.FixedHeader.RemainLength = body.Len()
-	packet := gs.FixedHeader.Pack()
-	packet.Write(body.Bytes())
+	return packPacket(gs, order)
+}
 
-	return packet.Bytes(), err
+func (gs *PublishPacket) encodeBody(buf *pool.Buffer, order binary.ByteOrder) {
+	appendUint32(buf, order, gs.MessageID)
+	appendBytesField(buf, order, gs.Payload)
 }
 
 func (gs *PublishPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
 	var err error
-	err = binary.Read(r, order, &gs.MessageID)
+	if err = binary.Read(r, order, &gs.MessageID); err != nil {
+		return err
+	}
 	gs.Payload, err = utils.DecodeReaderBytes(r, order)
 
 	return err
 }
 
 func (gs *PublishPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
-	data, err := gs.Pack(order)
-	if err != nil {
-		return 0, err
-	}
-	n, err := w.Write(data)
-	return int64(n), err
+	return writePacket(w, gs, order)
 }
 
 type PublishAckPacket struct {
@@ -368,20 +487,15 @@ func (gs *PublishAckPacket) Validate() int {
 }
 
 func (gs *PublishAckPacket) String() string {
-	return fmt.Sprintf("%s , MessageID:%d, Payload:%s", gs.FixedHeader.String(), gs.MessageID)
+	return fmt.Sprintf("%s , MessageID:%d", gs.FixedHeader.String(), gs.MessageID)
 }
 
 func (gs *PublishAckPacket) Pack(order binary.ByteOrder) ([]byte, error) {
-	var body bytes.Buffer
-	var err error
-
-	err = binary.Write(&body, order, gs.MessageID)
-
-	gs.FixedHeader.RemainLength = body.Len()
-	packet := gs.FixedHeader.Pack()
-	packet.Write(body.Bytes())
+	return packPacket(gs, order)
+}
 
-	return packet.Bytes(), err
+func (gs *PublishAckPacket) encodeBody(buf *pool.Buffer, order binary.ByteOrder) {
+	appendUint32(buf, order, gs.MessageID)
 }
 
 func (gs *PublishAckPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
@@ -392,40 +506,102 @@ func (gs *PublishAckPacket) Unpack(r io.Reader, order binary.ByteOrder) error {
 }
 
 func (gs *PublishAckPacket) WriteTo(w io.Writer, order binary.ByteOrder) (int64, error) {
-	data, err := gs.Pack(order)
-	if err != nil {
-		return 0, err
-	}
-	n, err := w.Write(data)
-	return int64(n), err
+	return writePacket(w, gs, order)
 }
 
+// ReadPacket 读取单个包 包体长度上限为 DefaultMaxPacketSize
 func ReadPacket(r io.Reader, order binary.ByteOrder) (ControlPacket, error) {
-	var fixedHeader FixedHeader
-	var err error
+	return ReadPacketLimit(r, order, DefaultMaxPacketSize)
+}
+
+// ReadPacketLimit 读取单个包 包体超过maxSize时不读取包体直接返回 ErrPacketTooLarge
+// 格式错误返回 *PacketDecodeError 调用方应关闭连接
+func ReadPacketLimit(r io.Reader, order binary.ByteOrder, maxSize int) (ControlPacket, error) {
+	return ReadPacketWithCodec(r, order, maxSize, VarintFrameCodec)
+}
+
+// readFrame 读取固定包头和完整包体 默认帧格式
+// 包头校验通过后才按包体长度分配内存
+func readFrame(r io.Reader, maxSize int) (FixedHeader, []byte, error) {
 	buf := make([]byte, 1)
 
-	if _, err = io.ReadFull(r, buf); err != nil {
-		return nil, err
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return FixedHeader{}, nil, err
 	}
-	err = fixedHeader.UnPack(PacketType(buf[0]), r)
-	if err != nil {
-		return nil, err
+	fixedHeader := splitHeaderByte(buf[0])
+	if err := fixedHeader.UnPack(fixedHeader.PacketType, r); err != nil {
+		if errors.Is(err, utils.ErrVariableIntOverflow) {
+			return fixedHeader, nil, newPacketDecodeError(fixedHeader, ErrMalformedPacket, err)
+		}
+		return fixedHeader, nil, err
 	}
+	if err := checkFrameHeader(fixedHeader, maxSize); err != nil {
+		return fixedHeader, nil, err
+	}
+	body, err := readBody(r, fixedHeader.RemainLength)
+
+	return fixedHeader, body, err
+}
+
+// decodePacket 根据固定包头从包体解包
+// 包体不足或解包后有剩余数据均视为格式错误
+func decodePacket(fixedHeader FixedHeader, body []byte, order binary.ByteOrder) (ControlPacket, error) {
 	packet, err := NewControlPacketWithHeader(fixedHeader)
 	if err != nil {
-		return nil, err
+		return nil, newPacketDecodeError(fixedHeader, ErrInvalidPacketType, nil)
 	}
-	bodySize := make([]byte, fixedHeader.RemainLength)
-	n, err := io.ReadFull(r, bodySize)
-	if err != nil {
-		return nil, err
+	// 包体已经完整读出 从包体缓冲区解包 避免再次读取连接
+	reader := bytes.NewReader(body)
+	if err = packet.Unpack(reader, order); err != nil {
+		return nil, newPacketDecodeError(fixedHeader, ErrMalformedPacket, err)
+	}
+	if reader.Len() > 0 {
+		return nil, newPacketDecodeError(fixedHeader, ErrMalformedPacket, ErrTrailingBytes)
+	}
+
+	return packet, nil
+}
+
+// PacketDecodeError 包解码失败
+// Err 为 ErrMalformedPacket/ErrPacketTooLarge/ErrInvalidPacketType 之一 可通过 errors.Is 判断
+type PacketDecodeError struct {
+	Header FixedHeader
+	Err    error
+	Cause  error // 底层错误 可为nil
+}
+
+func newPacketDecodeError(header FixedHeader, err, cause error) *PacketDecodeError {
+	return &PacketDecodeError{
+		Header: header,
+		Err:    err,
+		Cause:  cause,
+	}
+}
+
+func (gs *PacketDecodeError) Error() string {
+	if gs.Cause != nil {
+		return fmt.Sprintf("%s: %s remainLength %d: %s", gs.Err, gs.Header.PacketType.String(), gs.Header.RemainLength, gs.Cause)
 	}
-	if n != fixedHeader.RemainLength {
-		return nil, ErrReadExpectedDataFailed
+	return fmt.Sprintf("%s: %s remainLength %d", gs.Err, gs.Header.PacketType.String(), gs.Header.RemainLength)
+}
+
+func (gs *PacketDecodeError) Unwrap() []error {
+	if gs.Cause != nil {
+		return []error{gs.Err, gs.Cause}
 	}
+	return []error{gs.Err}
+}
 
-	err = packet.Unpack(r, order)
+// DisconnectError 读取时发现对端违规 连接层发送携带原因的 DISCONNECT 后关闭
+type DisconnectError struct {
+	Reason DisconnectReason
+	Err    error
+}
+
+func (gs *DisconnectError) Error() string {
+	return fmt.Sprintf("%s: disconnect %s", gs.Err, gs.Reason.String())
+}
 
-	return packet, err
+func (gs *DisconnectError) Unwrap() error {
+	return gs.Err
 }
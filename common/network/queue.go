@@ -0,0 +1,259 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// 有界收发队列
+// 队列满时按溢出策略处理 PUBLISH, 其他控制报文始终阻塞等待
+// 阻塞策略下慢消费者会反压到连接, 其他策略保证单个连接占用的内存和协程有上限
+
+var (
+	ErrQueueFull     = errors.New("queue full, packet dropped")
+	ErrQueueOverflow = errors.New("queue overflow, connection closed")
+)
+
+// OverflowPolicy 队列溢出策略
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // 阻塞等待队列空闲
+	OverflowDropOldest                       // 丢弃队列中最早的 PUBLISH
+	OverflowDropNewest                       // 丢弃新写入的 PUBLISH
+	OverflowDisconnect                       // 断开连接
+)
+
+var overflowPolicyNames = []string{
+	"BLOCK",
+	"DROP_OLDEST",
+	"DROP_NEWEST",
+	"DISCONNECT",
+}
+
+func (gs OverflowPolicy) String() string {
+	if gs < 0 || int(gs) >= len(overflowPolicyNames) {
+		return "UNKNOWN"
+	}
+	return overflowPolicyNames[gs]
+}
+
+// QueueDirection 队列方向
+type QueueDirection int
+
+const (
+	QueueInbound  QueueDirection = iota // 收包队列
+	QueueOutbound                       // 发包队列
+)
+
+func (gs QueueDirection) String() string {
+	if gs == QueueInbound {
+		return "INBOUND"
+	}
+	return "OUTBOUND"
+}
+
+// QueueConf 队列配置
+type QueueConf struct {
+	Size          int            // 队列容量 <=0 时无缓冲且只能阻塞
+	Policy        OverflowPolicy // 溢出策略
+	HighWatermark int            // 深度达到该值时回调 <=0 不回调
+}
+
+// QueueStats 队列统计
+type QueueStats struct {
+	Depth    int    // 当前深度
+	Capacity int    // 容量
+	Peak     int    // 历史最大深度
+	Dropped  uint64 // 丢弃的包数量
+	Overflow uint64 // 队列满的次数
+}
+
+// packetQueue 有界包队列
+type packetQueue struct {
+	direction     QueueDirection
+	ch            chan ControlPacket
+	policy        OverflowPolicy
+	highWatermark int
+	onWatermark   func(direction QueueDirection, depth int)
+	onDrop        func(packet ControlPacket) // 已入队的包被丢弃
+	lock          sync.RWMutex               // 入队持读锁 丢弃最早的包时持写锁重排队列
+
+	peak           atomic.Int64
+	dropped        atomic.Uint64
+	overflow       atomic.Uint64
+	aboveWatermark atomic.Bool
+}
+
+func newPacketQueue(direction QueueDirection, conf QueueConf) *packetQueue {
+	queue := &packetQueue{
+		direction:     direction,
+		ch:            make(chan ControlPacket, max(conf.Size, 0)),
+		policy:        conf.Policy,
+		highWatermark: conf.HighWatermark,
+	}
+	if conf.Size <= 0 {
+		queue.policy = OverflowBlock
+	}
+
+	return queue
+}
+
+// push 入队 ctx为调用方上下文 closeCtx为连接上下文
+// 丢弃时返回 ErrQueueFull 溢出断开时返回 ErrQueueOverflow
+func (gs *packetQueue) push(ctx, closeCtx context.Context, packet ControlPacket) error {
+	if !isPublish(packet) || gs.policy == OverflowBlock {
+		return gs.pushWait(ctx, closeCtx, packet)
+	}
+
+	if gs.offer(packet) {
+		return nil
+	}
+
+	gs.overflow.Add(1)
+	switch gs.policy {
+	case OverflowDropNewest:
+		gs.dropped.Add(1)
+		return ErrQueueFull
+	case OverflowDisconnect:
+		return ErrQueueOverflow
+	}
+
+	// drop oldest 有阻塞等待入队的包时不重排 丢弃新包
+	if !gs.lock.TryLock() {
+		gs.dropped.Add(1)
+		return ErrQueueFull
+	}
+	oldest := gs.replaceOldest(packet)
+	gs.lock.Unlock()
+	if oldest == nil {
+		// 队列中只有控制报文 丢弃新包
+		gs.dropped.Add(1)
+		return ErrQueueFull
+	}
+	gs.drop(oldest)
+	gs.observe()
+
+	return nil
+}
+
+// replaceOldest 移除队列中最早的 PUBLISH 并将新包放入队尾 其余包保持原有顺序
+// 调用方持有写锁 没有其他写入方 放回时不会阻塞 队列中没有 PUBLISH 时返回nil且不入队
+func (gs *packetQueue) replaceOldest(packet ControlPacket) ControlPacket {
+	// 消费方同时取走的包都在取出的包之前 不影响顺序
+	packets := make([]ControlPacket, 0, cap(gs.ch))
+	for drained := false; !drained; {
+		select {
+		case queued, ok := <-gs.ch:
+			if !ok {
+				return nil
+			}
+			packets = append(packets, queued)
+		default:
+			drained = true
+		}
+	}
+
+	var oldest ControlPacket
+	for i, queued := range packets {
+		if isPublish(queued) {
+			oldest = queued
+			packets = append(packets[:i], packets[i+1:]...)
+			packets = append(packets, packet)
+			break
+		}
+	}
+	for _, queued := range packets {
+		gs.ch <- queued
+	}
+
+	return oldest
+}
+
+// pushWait 阻塞入队
+func (gs *packetQueue) pushWait(ctx, closeCtx context.Context, packet ControlPacket) error {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+	select {
+	case <-ctx.Done():
+		return ErrOperationCancel
+	case <-closeCtx.Done():
+		return ErrConnectionLayerClosed
+	case gs.ch <- packet:
+	}
+	gs.observe()
+
+	return nil
+}
+
+// tryPush 非阻塞入队 阻塞策略和非 PUBLISH 包在队列满时直接丢弃新包
+func (gs *packetQueue) tryPush(closeCtx context.Context, packet ControlPacket) error {
+	if closeCtx.Err() != nil {
+		return ErrConnectionLayerClosed
+	}
+	if gs.offer(packet) {
+		return nil
+	}
+	if isPublish(packet) && gs.policy != OverflowBlock {
+		return gs.push(closeCtx, closeCtx, packet)
+	}
+	gs.overflow.Add(1)
+	gs.dropped.Add(1)
+
+	return ErrQueueFull
+}
+
+// offer 非阻塞入队 队列满时返回false
+func (gs *packetQueue) offer(packet ControlPacket) bool {
+	gs.lock.RLock()
+	select {
+	case gs.ch <- packet:
+		gs.lock.RUnlock()
+		gs.observe()
+		return true
+	default:
+		gs.lock.RUnlock()
+		return false
+	}
+}
+
+// drop 丢弃已入队的包
+func (gs *packetQueue) drop(packet ControlPacket) {
+	gs.dropped.Add(1)
+	if gs.onDrop != nil {
+		gs.onDrop(packet)
+	}
+}
+
+// observe 入队后更新峰值 深度越过高水位时回调一次 回落到一半以下后重新触发
+func (gs *packetQueue) observe() {
+	depth := int64(len(gs.ch))
+	for {
+		peak := gs.peak.Load()
+		if depth <= peak || gs.peak.CompareAndSwap(peak, depth) {
+			break
+		}
+	}
+	if gs.highWatermark <= 0 {
+		return
+	}
+	if depth >= int64(gs.highWatermark) {
+		if !gs.aboveWatermark.Swap(true) && gs.onWatermark != nil {
+			gs.onWatermark(gs.direction, int(depth))
+		}
+	} else if depth < int64(gs.highWatermark)/2 {
+		gs.aboveWatermark.Store(false)
+	}
+}
+
+func (gs *packetQueue) stats() QueueStats {
+	return QueueStats{
+		Depth:    len(gs.ch),
+		Capacity: cap(gs.ch),
+		Peak:     int(gs.peak.Load()),
+		Dropped:  gs.dropped.Load(),
+		Overflow: gs.overflow.Load(),
+	}
+}
@@ -0,0 +1,111 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func queuedPackets(queue *packetQueue) []string {
+	packets := make([]string, 0, len(queue.ch))
+	for len(queue.ch) > 0 {
+		packets = append(packets, packetName(<-queue.ch))
+	}
+	return packets
+}
+
+func packetName(packet ControlPacket) string {
+	if p, ok := packet.(*PublishPacket); ok {
+		return string(p.Payload)
+	}
+	return packet.Name()
+}
+
+func TestQueueDropOldestKeepsOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		queued  []ControlPacket
+		wantErr error
+		want    []string
+	}{
+		{
+			name:   "skip control packets",
+			queued: []ControlPacket{NewControlPacket(PublishAck), newPublish(1, "p1"), NewControlPacket(DisConnect), newPublish(2, "p2")},
+			want:   []string{NewControlPacket(PublishAck).Name(), NewControlPacket(DisConnect).Name(), "p2", "new"},
+		},
+		{
+			name:    "only control packets",
+			queued:  []ControlPacket{NewControlPacket(PublishAck), NewControlPacket(HeartbeatAck), NewControlPacket(DisConnect), NewControlPacket(Heartbeat)},
+			wantErr: ErrQueueFull,
+			want:    []string{NewControlPacket(PublishAck).Name(), NewControlPacket(HeartbeatAck).Name(), NewControlPacket(DisConnect).Name(), NewControlPacket(Heartbeat).Name()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := newPacketQueue(QueueOutbound, QueueConf{Size: len(tt.queued), Policy: OverflowDropOldest})
+			var dropped []string
+			queue.onDrop = func(packet ControlPacket) {
+				dropped = append(dropped, packetName(packet))
+			}
+			for _, packet := range tt.queued {
+				if err := queue.tryPush(context.Background(), packet); err != nil {
+					t.Fatalf("push %s: %v", packetName(packet), err)
+				}
+			}
+
+			if err := queue.tryPush(context.Background(), newPublish(3, "new")); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("push new: %v, want %v", err, tt.wantErr)
+			}
+			if got := queuedPackets(queue); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("queued %v, want %v", got, tt.want)
+			}
+			if tt.wantErr == nil && (len(dropped) != 1 || dropped[0] != "p1") {
+				t.Fatalf("dropped %v, want [p1]", dropped)
+			}
+			if stats := queue.stats(); stats.Dropped != 1 || stats.Overflow != 1 {
+				t.Fatalf("stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestQueueTryPushDoesNotBlock(t *testing.T) {
+	queue := newPacketQueue(QueueOutbound, QueueConf{Size: 2, Policy: OverflowDropOldest})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if err := queue.tryPush(ctx, newPublish(uint32(i+1), "p")); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+
+	// 控制报文阻塞等待入队时 新的 PUBLISH 直接丢弃
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- queue.pushWait(ctx, ctx, NewControlPacket(DisConnect))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- queue.tryPush(ctx, newPublish(3, "new"))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("try push: %v, want %v", err, ErrQueueFull)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("try push blocked")
+	}
+
+	<-queue.ch
+	if err := <-blocked; err != nil {
+		t.Fatalf("push wait: %v", err)
+	}
+	if got := queuedPackets(queue); strings.Join(got, ",") != "p,"+NewControlPacket(DisConnect).Name() {
+		t.Fatalf("queued %v", got)
+	}
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"errors"
+	"time"
+)
+
+// 收包限速
+// 每个连接一个令牌桶限制所有包 另可按包类型单独限制
+// 超出限速时按配置丢弃、延迟读取或断开连接 DISCONNECT 不受限制
+
+var (
+	// DefaultRateLimitMaxDelay 延迟处理时默认最多等待的时间
+	DefaultRateLimitMaxDelay = time.Second
+
+	ErrRateLimitExceeded = errors.New("rate limit exceeded")
+)
+
+// RateLimitAction 超出限速时的处理方式
+type RateLimitAction int
+
+const (
+	RateLimitDrop       RateLimitAction = iota // 丢弃该包
+	RateLimitDelay                             // 暂停读取直到有令牌 反压到对端
+	RateLimitDisconnect                        // 发送 DISCONNECT 后断开
+)
+
+var rateLimitActionNames = []string{
+	"DROP",
+	"DELAY",
+	"DISCONNECT",
+}
+
+func (gs RateLimitAction) String() string {
+	if gs < 0 || int(gs) >= len(rateLimitActionNames) {
+		return "UNKNOWN"
+	}
+	return rateLimitActionNames[gs]
+}
+
+// RateLimit 令牌桶参数
+type RateLimit struct {
+	Rate  float64 // 每秒补充的令牌数 <=0 不限制
+	Burst int     // 桶容量 允许的突发包数 <=0 时与每秒速率相同
+}
+
+// RateLimitConf 收包限速配置
+type RateLimitConf struct {
+	Connection RateLimit                // 单连接所有包
+	Packets    map[PacketType]RateLimit // 按包类型
+	Action     RateLimitAction
+	MaxDelay   time.Duration // 延迟读取最多等待的时间 超过时丢弃 <=0 使用默认值
+}
+
+// tokenBucket 令牌桶 仅由读协程访问
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = max(limit.Rate, 1)
+	}
+
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait 补充令牌 返回获得一个令牌还需等待的时间
+func (gs *tokenBucket) wait(now time.Time) time.Duration {
+	if elapsed := now.Sub(gs.last); elapsed > 0 {
+		gs.tokens = min(gs.burst, gs.tokens+elapsed.Seconds()*gs.rate)
+		gs.last = now
+	}
+	if gs.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - gs.tokens) / gs.rate * float64(time.Second))
+}
+
+func (gs *tokenBucket) take() {
+	gs.tokens--
+}
+
+// rateLimiter 单个连接的收包限速
+type rateLimiter struct {
+	connection *tokenBucket
+	packets    [PacketTypeMask + 1]*tokenBucket
+	action     RateLimitAction
+	maxDelay   time.Duration
+}
+
+// newRateLimiter 未配置任何限制时返回nil
+func newRateLimiter(conf *RateLimitConf) *rateLimiter {
+	if conf == nil {
+		return nil
+	}
+	limiter := &rateLimiter{
+		connection: newTokenBucket(conf.Connection),
+		action:     conf.Action,
+		maxDelay:   conf.MaxDelay,
+	}
+	if limiter.maxDelay <= 0 {
+		limiter.maxDelay = DefaultRateLimitMaxDelay
+	}
+	limited := limiter.connection != nil
+	for packetType, limit := range conf.Packets {
+		if byte(packetType) > PacketTypeMask {
+			continue
+		}
+		if bucket := newTokenBucket(limit); bucket != nil {
+			limiter.packets[packetType] = bucket
+			limited = true
+		}
+	}
+	if !limited {
+		return nil
+	}
+
+	return limiter
+}
+
+// wait 连接和包类型两级令牌桶都有令牌时返回0
+func (gs *rateLimiter) wait(packetType PacketType, now time.Time) time.Duration {
+	var delay time.Duration
+	if gs.connection != nil {
+		delay = gs.connection.wait(now)
+	}
+	if bucket := gs.packets[byte(packetType)&PacketTypeMask]; bucket != nil {
+		delay = max(delay, bucket.wait(now))
+	}
+
+	return delay
+}
+
+func (gs *rateLimiter) take(packetType PacketType) {
+	if gs.connection != nil {
+		gs.connection.take()
+	}
+	if bucket := gs.packets[byte(packetType)&PacketTypeMask]; bucket != nil {
+		bucket.take()
+	}
+}
@@ -0,0 +1,411 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"GameServer/common/stl"
+	"GameServer/common/timer"
+	"GameServer/gslog"
+)
+
+// 至少一次(at-least-once)投递
+// 发送端为 PUBLISH 分配 MessageID 并等待 PUBLISH_ACK, 超时重发, 重连后重发已发送未确认的包
+// 接收端对 MessageID 非0的 PUBLISH 回复 PUBLISH_ACK 并在窗口内去重
+// 双方需在握手时协商 CapAckMode 未协商时不回复确认 发送端写出即释放 不保证送达
+// 服务端按连接ID保留去重窗口 对端在连接关闭后 DuplicateFilterRetention 内重连时沿用
+// 超出窗口大小或保留时间的重发包会被重复投递
+// MessageID 从随机值开始分配 避免新连接的ID落入对端保留的去重窗口
+
+var (
+	DefaultReliableWindowSize   = 64
+	DefaultRetransmitInterval   = 3 * time.Second
+	DefaultMaxRetransmits       = 5
+	DefaultDuplicateFilterSize  = 1024
+	DuplicateFilterRetention    = 5 * time.Minute
+	ErrReliableSenderStopped    = errors.New("reliable sender stopped")
+	ErrPublishMessageIDConflict = errors.New("publish message id already in flight")
+)
+
+// duplicateFilterSweepInterval 清理过期去重窗口的间隔
+const duplicateFilterSweepInterval = time.Minute
+
+// ReliableConf 可靠投递配置
+type ReliableConf struct {
+	WindowSize         int                   // 最大未确认包数量
+	RetransmitInterval time.Duration         // 重发间隔
+	MaxRetransmits     int                   // 最大重发次数 0使用默认值 <0 不限制
+	Scheduler          *timer.TimerScheduler // 重发定时器调度器 需已启动自动调度 nil时使用标准库定时器
+	OnDeliveryFailed   OnDeliveryFailedCallback
+}
+
+// inflightMessage 已分配ID未确认的包
+type inflightMessage struct {
+	packet   *PublishPacket
+	sent     bool        // 是否已写入过连接
+	attempts int         // 重发次数
+	timerID  int64       // 调度器定时器ID
+	timer    *time.Timer // 标准库定时器
+}
+
+// reliableSender 可靠投递发送端
+type reliableSender struct {
+	keeper   *TcpConnectionKeeper
+	conf     ReliableConf
+	nextID   uint32
+	window   chan struct{}
+	inflight map[uint32]*inflightMessage
+	stopped  bool
+	lock     sync.Mutex
+}
+
+func newReliableSender(keeper *TcpConnectionKeeper, conf ReliableConf) *reliableSender {
+	if conf.WindowSize <= 0 {
+		conf.WindowSize = DefaultReliableWindowSize
+	}
+	if conf.RetransmitInterval <= 0 {
+		conf.RetransmitInterval = DefaultRetransmitInterval
+	}
+	if conf.MaxRetransmits == 0 {
+		conf.MaxRetransmits = DefaultMaxRetransmits
+	}
+
+	return &reliableSender{
+		keeper:   keeper,
+		conf:     conf,
+		nextID:   rand.Uint32(),
+		window:   make(chan struct{}, conf.WindowSize),
+		inflight: make(map[uint32]*inflightMessage),
+	}
+}
+
+// track 占用发送窗口并分配MessageID
+// 窗口已满时阻塞 直到有包被确认或ctx结束
+func (gs *reliableSender) track(ctx context.Context, packet *PublishPacket) error {
+	select {
+	case <-ctx.Done():
+		return ErrOperationCancel
+	case <-gs.keeper.ctx.Done():
+		return ErrConnectionLayerClosed
+	case gs.window <- struct{}{}:
+	}
+
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.stopped {
+		<-gs.window
+		return ErrReliableSenderStopped
+	}
+	if packet.MessageID == 0 {
+		packet.MessageID = gs.allocID()
+	} else if _, exist := gs.inflight[packet.MessageID]; exist {
+		<-gs.window
+		return ErrPublishMessageIDConflict
+	}
+	gs.inflight[packet.MessageID] = &inflightMessage{packet: packet}
+
+	return nil
+}
+
+// untrack 包未能进入发送队列 释放窗口
+func (gs *reliableSender) untrack(messageID uint32) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	gs.release(messageID)
+}
+
+// fail 已进入发送队列的包被丢弃 按投递失败处理
+func (gs *reliableSender) fail(messageID uint32) {
+	gs.lock.Lock()
+	message, ok := gs.inflight[messageID]
+	if ok {
+		gs.release(messageID)
+	}
+	gs.lock.Unlock()
+
+	if ok && gs.conf.OnDeliveryFailed != nil {
+		go gs.conf.OnDeliveryFailed(gs.keeper.connID, message.packet)
+	}
+}
+
+// allocID 分配MessageID 跳过0和仍在途的ID
+func (gs *reliableSender) allocID() uint32 {
+	for {
+		gs.nextID++
+		if gs.nextID == 0 {
+			continue
+		}
+		if _, exist := gs.inflight[gs.nextID]; !exist {
+			return gs.nextID
+		}
+	}
+}
+
+// markSent 包写入连接成功 启动重发定时器
+func (gs *reliableSender) markSent(messageID uint32) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	message, ok := gs.inflight[messageID]
+	if !ok || gs.stopped {
+		return
+	}
+	message.sent = true
+	gs.schedule(message)
+}
+
+// ack 收到确认 返回是否匹配到在途包
+func (gs *reliableSender) ack(messageID uint32) bool {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if _, ok := gs.inflight[messageID]; !ok {
+		return false
+	}
+	gs.release(messageID)
+
+	return true
+}
+
+// sentPackets 已发送未确认的包 按MessageID排序 用于重连后重发
+func (gs *reliableSender) sentPackets() []*PublishPacket {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	packets := make([]*PublishPacket, 0, len(gs.inflight))
+	for _, message := range gs.inflight {
+		if message.sent {
+			packets = append(packets, message.packet)
+		}
+	}
+	sort.Slice(packets, func(i, j int) bool {
+		return packets[i].MessageID < packets[j].MessageID
+	})
+
+	return packets
+}
+
+// inflightCount 在途包数量
+func (gs *reliableSender) inflightCount() int {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	return len(gs.inflight)
+}
+
+// stop 停止所有重发定时器 未确认的包按投递失败处理
+func (gs *reliableSender) stop() {
+	gs.lock.Lock()
+	gs.stopped = true
+	failed := make([]*PublishPacket, 0, len(gs.inflight))
+	for messageID, message := range gs.inflight {
+		failed = append(failed, message.packet)
+		gs.release(messageID)
+	}
+	gs.lock.Unlock()
+
+	if len(failed) == 0 {
+		return
+	}
+	gslog.Warn("[ReliableSender] stopped with unacknowledged publish", "connID", gs.keeper.connID, "count", len(failed))
+	if gs.conf.OnDeliveryFailed == nil {
+		return
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].MessageID < failed[j].MessageID
+	})
+	go func() {
+		for _, packet := range failed {
+			gs.conf.OnDeliveryFailed(gs.keeper.connID, packet)
+		}
+	}()
+}
+
+// OnTimer 实现 timer.ITimerCallback
+func (gs *reliableSender) OnTimer(identifyID int64, param any) bool {
+	messageID, ok := param.(uint32)
+	if !ok {
+		return false
+	}
+	gs.retransmit(messageID, identifyID)
+
+	return true
+}
+
+// retransmit 重发超时未确认的包
+func (gs *reliableSender) retransmit(messageID uint32, timerID int64) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	message, ok := gs.inflight[messageID]
+	if !ok || gs.stopped || (timerID != 0 && message.timerID != timerID) {
+		return
+	}
+	message.timerID = 0
+	message.timer = nil
+
+	if gs.conf.MaxRetransmits > 0 && message.attempts >= gs.conf.MaxRetransmits {
+		gslog.Error("[ReliableSender] publish delivery failed", "connID", gs.keeper.connID, "messageID", messageID, "attempts", message.attempts)
+		gs.release(messageID)
+		if gs.conf.OnDeliveryFailed != nil {
+			go gs.conf.OnDeliveryFailed(gs.keeper.connID, message.packet)
+		}
+		return
+	}
+
+	// 不阻塞定时器回调 队列满时等待下次重发
+	if !gs.keeper.outbound.offer(message.packet) {
+		gs.schedule(message)
+		return
+	}
+	message.attempts++
+	message.sent = false
+	gslog.Debug("[ReliableSender] retransmit publish", "connID", gs.keeper.connID, "messageID", messageID, "attempts", message.attempts)
+}
+
+// schedule 启动重发定时器 调用方持有锁
+func (gs *reliableSender) schedule(message *inflightMessage) {
+	gs.cancelTimer(message)
+
+	messageID := message.packet.MessageID
+	if gs.conf.Scheduler != nil {
+		message.timerID = gs.conf.Scheduler.AddTimer(gs, messageID, time.Now().Add(gs.conf.RetransmitInterval), 0)
+		return
+	}
+	message.timer = time.AfterFunc(gs.conf.RetransmitInterval, func() {
+		gs.retransmit(messageID, 0)
+	})
+}
+
+func (gs *reliableSender) cancelTimer(message *inflightMessage) {
+	if message.timerID != 0 {
+		gs.conf.Scheduler.CancelTimer(message.timerID)
+		message.timerID = 0
+	}
+	if message.timer != nil {
+		message.timer.Stop()
+		message.timer = nil
+	}
+}
+
+// release 移除在途包并释放窗口 调用方持有锁
+func (gs *reliableSender) release(messageID uint32) {
+	message, ok := gs.inflight[messageID]
+	if !ok {
+		return
+	}
+	gs.cancelTimer(message)
+	delete(gs.inflight, messageID)
+	<-gs.window
+}
+
+// duplicateFilter 接收端去重窗口
+// 记录最近收到的MessageID 重连后可能由新旧连接的读协程访问
+type duplicateFilter struct {
+	seen  *stl.Set[uint32]
+	order *stl.RingQueue[uint32]
+	size  int
+	lock  sync.Mutex
+}
+
+func newDuplicateFilter(size int) *duplicateFilter {
+	return &duplicateFilter{
+		seen:  stl.NewSet[uint32](),
+		order: stl.NewRingQueue[uint32](size),
+		size:  size,
+	}
+}
+
+// Duplicated 是否重复 非重复时记录
+func (gs *duplicateFilter) Duplicated(messageID uint32) bool {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.seen.Contains(messageID) {
+		return true
+	}
+	if gs.order.Length() >= gs.size {
+		gs.seen.Del(gs.order.Pop())
+	}
+	gs.seen.Insert(messageID)
+	gs.order.Push(messageID)
+
+	return false
+}
+
+// retainedFilter 按连接ID保留的去重窗口
+type retainedFilter struct {
+	filter   *duplicateFilter
+	refs     int       // 使用中的连接数
+	closedAt time.Time // 最后一个连接关闭的时间
+}
+
+func (gs *retainedFilter) expired(now time.Time) bool {
+	return gs.refs == 0 && now.Sub(gs.closedAt) > DuplicateFilterRetention
+}
+
+// duplicateFilters 服务端按连接ID保留的去重窗口
+// 每个连接使用新的连接层 去重窗口需跨重连保留 否则对端重连后重发的包会被重复投递
+type duplicateFilters struct {
+	filters   map[string]*retainedFilter
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+func newDuplicateFilters() *duplicateFilters {
+	return &duplicateFilters{
+		filters:   make(map[string]*retainedFilter),
+		lastSweep: time.Now(),
+	}
+}
+
+// acquire 获取连接ID的去重窗口 已过期的窗口不再沿用
+func (gs *duplicateFilters) acquire(connectionID string) *duplicateFilter {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	now := time.Now()
+	gs.sweep(now)
+	retained, ok := gs.filters[connectionID]
+	if !ok || retained.expired(now) {
+		retained = &retainedFilter{filter: newDuplicateFilter(DefaultDuplicateFilterSize)}
+		gs.filters[connectionID] = retained
+	}
+	retained.refs++
+
+	return retained.filter
+}
+
+// release 连接关闭 去重窗口保留 DuplicateFilterRetention
+func (gs *duplicateFilters) release(connectionID string) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	retained, ok := gs.filters[connectionID]
+	if !ok || retained.refs == 0 {
+		return
+	}
+	retained.refs--
+	if retained.refs == 0 {
+		retained.closedAt = time.Now()
+	}
+}
+
+// sweep 定期清理过期的去重窗口 调用方持有锁
+func (gs *duplicateFilters) sweep(now time.Time) {
+	if now.Sub(gs.lastSweep) < duplicateFilterSweepInterval {
+		return
+	}
+	gs.lastSweep = now
+	for id, retained := range gs.filters {
+		if retained.expired(now) {
+			delete(gs.filters, id)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func ackModeConf(connectionID string, capabilities Capability) *BrokerConf {
+	return &BrokerConf{ConnectionID: connectionID, ByteOrder: binary.BigEndian, Capabilities: capabilities}
+}
+
+func TestReliableGiveUpAfterMaxRetransmits(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxRetransmits int
+		wantAttempts   int
+	}{
+		{name: "default", maxRetransmits: 0, wantAttempts: DefaultMaxRetransmits},
+		{name: "configured", maxRetransmits: 2, wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server, _ := newBrokerPair(t, ackModeConf("c", CapAckMode), ackModeConf("s", CapAckMode))
+			// 对端只读不确认
+			received := make(chan uint32, 16)
+			go func() {
+				for {
+					packet, err := server.ReadPacket()
+					if err != nil {
+						return
+					}
+					if publish, ok := packet.(*PublishPacket); ok {
+						received <- publish.MessageID
+					}
+				}
+			}()
+
+			failed := make(chan *PublishPacket, 1)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			conn := NewTcpConnectionKeeper(ctx, oneShotBrokerFactory(client), WithReliableDelivery(ReliableConf{
+				RetransmitInterval: 20 * time.Millisecond,
+				MaxRetransmits:     tt.maxRetransmits,
+				OnDeliveryFailed: func(_ string, packet *PublishPacket) {
+					failed <- packet
+				},
+			}))
+			defer conn.Close()
+
+			if err := conn.WritePacket(ctx, newPublish(0, "lost")); err != nil {
+				t.Fatalf("write packet: %v", err)
+			}
+			var packet *PublishPacket
+			select {
+			case packet = <-failed:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("delivery failure not reported")
+			}
+			// 首次发送加上每次重发
+			if sent := len(received); sent != tt.wantAttempts+1 {
+				t.Fatalf("publish %d sent %d times, want %d", packet.MessageID, sent, tt.wantAttempts+1)
+			}
+			if inflight := conn.(*TcpConnectionKeeper).reliable.inflightCount(); inflight != 0 {
+				t.Fatalf("%d publish still in flight", inflight)
+			}
+		})
+	}
+}
+
+func TestDuplicateFiltersRetention(t *testing.T) {
+	filters := newDuplicateFilters()
+	first := filters.acquire("c1")
+	filters.release("c1")
+	if got := filters.acquire("c1"); got != first {
+		t.Fatalf("filter not kept across reconnect")
+	}
+	filters.release("c1")
+
+	// 过期的窗口在获取时重建
+	filters.filters["c1"].closedAt = time.Now().Add(-DuplicateFilterRetention - time.Second)
+	if got := filters.acquire("c1"); got == first {
+		t.Fatalf("expired filter reused")
+	}
+	filters.release("c1")
+
+	// 其他连接ID过期的窗口只在定期清理时移除
+	filters.acquire("c2")
+	filters.release("c2")
+	filters.filters["c2"].closedAt = time.Now().Add(-DuplicateFilterRetention - time.Second)
+	filters.acquire("c3")
+	if _, ok := filters.filters["c2"]; !ok {
+		t.Fatalf("expired filter removed before the sweep interval")
+	}
+	filters.lastSweep = time.Now().Add(-duplicateFilterSweepInterval)
+	filters.acquire("c4")
+	if _, ok := filters.filters["c2"]; ok {
+		t.Fatalf("expired filter not swept")
+	}
+	if _, ok := filters.filters["c3"]; !ok {
+		t.Fatalf("filter in use swept")
+	}
+}
+
+func TestReliableRequiresAckMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  Capability
+		server  Capability
+		wantAck bool
+	}{
+		{name: "negotiated", client: CapAckMode, server: CapAckMode, wantAck: true},
+		{name: "not requested", client: 0, server: CapAckMode, wantAck: false},
+		{name: "not accepted", client: CapAckMode, server: 0, wantAck: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server, _ := newBrokerPair(t, ackModeConf("c", tt.client), ackModeConf("s", tt.server))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			failed := make(chan *PublishPacket, 1)
+			conn := NewTcpConnectionKeeper(ctx, oneShotBrokerFactory(server), WithReliableDelivery(ReliableConf{
+				RetransmitInterval: 20 * time.Millisecond,
+				MaxRetransmits:     1,
+				OnDeliveryFailed: func(_ string, packet *PublishPacket) {
+					failed <- packet
+				},
+			}))
+			defer conn.Close()
+			go func() {
+				for range conn.Read() {
+				}
+			}()
+
+			packets := make(chan ControlPacket, 16)
+			go func() {
+				for {
+					packet, err := client.ReadPacket()
+					if err != nil {
+						return
+					}
+					packets <- packet
+				}
+			}()
+
+			// 接收端 只对协商了确认模式的连接回复确认
+			if err := client.WritePacket(newPublish(5, "request")); err != nil {
+				t.Fatalf("write publish: %v", err)
+			}
+			if err := client.WritePacket(NewControlPacket(Heartbeat)); err != nil {
+				t.Fatalf("write heartbeat: %v", err)
+			}
+			var packet ControlPacket
+			select {
+			case packet = <-packets:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no response from keeper")
+			}
+			if _, acked := packet.(*PublishAckPacket); acked != tt.wantAck {
+				t.Fatalf("received %v, want ack %v", packet, tt.wantAck)
+			}
+
+			// 发送端 未协商时写出即释放 不重发也不报告失败
+			if err := conn.WritePacket(ctx, newPublish(0, "push")); err != nil {
+				t.Fatalf("write packet: %v", err)
+			}
+			time.Sleep(200 * time.Millisecond)
+			if reported := len(failed) > 0; reported != tt.wantAck {
+				t.Fatalf("delivery failure reported %v, want %v", reported, tt.wantAck)
+			}
+			// 首次发送 确认模式下加上一次重发
+			want := 1
+			if tt.wantAck {
+				want = 2
+			}
+			sent := 0
+			for len(packets) > 0 {
+				if _, ok := (<-packets).(*PublishPacket); ok {
+					sent++
+				}
+			}
+			if sent != want {
+				t.Fatalf("publish sent %d times, want %d", sent, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"GameServer/gslog"
+)
+
+// 消息路由
+// PUBLISH 负载 = 路由头(4字节路由ID) + 业务数据
+// 业务数据由 PresentationLayer 编解码 处理结果以相同路由ID回复
+
+var (
+	ErrRouteRegistered    = errors.New("route already registered")
+	ErrRouteNotFound      = errors.New("route not found")
+	ErrInvalidRoutePacket = errors.New("invalid route packet")
+)
+
+// routeHeaderSize 路由头长度
+const routeHeaderSize = 4
+
+// RouteMessage 路由消息
+type RouteMessage struct {
+	Route uint32
+	Body  []byte
+}
+
+// EncodeRouteMessage 编码路由消息
+func EncodeRouteMessage(route uint32, body []byte, order binary.ByteOrder) []byte {
+	payload := make([]byte, routeHeaderSize, routeHeaderSize+len(body))
+	order.PutUint32(payload, route)
+	return append(payload, body...)
+}
+
+// DecodeRouteMessage 解码路由消息
+func DecodeRouteMessage(payload []byte, order binary.ByteOrder) (*RouteMessage, error) {
+	if len(payload) < routeHeaderSize {
+		return nil, ErrInvalidRoutePacket
+	}
+
+	return &RouteMessage{
+		Route: order.Uint32(payload),
+		Body:  payload[routeHeaderSize:],
+	}, nil
+}
+
+type (
+	// HandlerFunc 路由处理函数 返回值非nil时编码后回复
+	HandlerFunc func(ctx context.Context, session *Session, message *RouteMessage) (any, error)
+	// Middleware 路由中间件
+	Middleware func(next HandlerFunc) HandlerFunc
+)
+
+// Router 消息路由器
+type Router struct {
+	presentation PresentationLayer
+	byteOrder    binary.ByteOrder
+	handlers     map[uint32]HandlerFunc
+	middlewares  []Middleware
+	lock         sync.RWMutex
+}
+
+// NewRouter 创建路由器
+func NewRouter(presentation PresentationLayer, order binary.ByteOrder) *Router {
+	return &Router{
+		presentation: presentation,
+		byteOrder:    order,
+		handlers:     make(map[uint32]HandlerFunc),
+	}
+}
+
+// Use 添加中间件 按添加顺序由外向内执行
+func (gs *Router) Use(middlewares ...Middleware) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	gs.middlewares = append(gs.middlewares, middlewares...)
+}
+
+// Handle 注册路由处理函数
+func (gs *Router) Handle(route uint32, handler HandlerFunc) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if _, exist := gs.handlers[route]; exist {
+		return fmt.Errorf("%w: %d", ErrRouteRegistered, route)
+	}
+	gs.handlers[route] = handler
+
+	return nil
+}
+
+// Presentation 路由使用的表示层
+func (gs *Router) Presentation() PresentationLayer {
+	return gs.presentation
+}
+
+// Register 注册强类型路由处理函数
+// 请求以 PresentationLayer 解码为 Req 响应 Resp 编码后回复 响应为nil时不回复
+func Register[Req, Resp any](router *Router, route uint32, handler func(ctx context.Context, session *Session, req *Req) (*Resp, error)) error {
+	return router.Handle(route, func(ctx context.Context, session *Session, message *RouteMessage) (any, error) {
+		req := new(Req)
+		if err := router.presentation.Decode(message.Body, req); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, session, req)
+		if err != nil || resp == nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// Dispatch 分发单个包 非 PUBLISH 包忽略
+func (gs *Router) Dispatch(ctx context.Context, session *Session, packet ControlPacket) error {
+	publish, ok := packet.(*PublishPacket)
+	if !ok {
+		return nil
+	}
+	session.Touch()
+	message, err := DecodeRouteMessage(publish.Payload, gs.byteOrder)
+	if err != nil {
+		return err
+	}
+
+	gs.lock.RLock()
+	handler, exist := gs.handlers[message.Route]
+	middlewares := gs.middlewares
+	gs.lock.RUnlock()
+	if !exist {
+		return fmt.Errorf("%w: %d", ErrRouteNotFound, message.Route)
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	resp, err := handler(ctx, session, message)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+
+	return gs.Reply(ctx, session, message.Route, resp)
+}
+
+// Reply 以指定路由编码并发送消息
+func (gs *Router) Reply(ctx context.Context, session *Session, route uint32, msg any) error {
+	body, err := gs.presentation.Encode(msg)
+	if err != nil {
+		return err
+	}
+	packet := NewControlPacket(Publish).(*PublishPacket)
+	packet.Payload = EncodeRouteMessage(route, body, gs.byteOrder)
+
+	return session.Conn().WritePacket(ctx, packet)
+}
+
+// Serve 按序分发会话收到的包 直到连接关闭或ctx结束
+func (gs *Router) Serve(ctx context.Context, session *Session) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packet, ok := <-session.Conn().Read():
+			if !ok {
+				return
+			}
+			if err := gs.Dispatch(ctx, session, packet); err != nil {
+				gslog.Warn("[Router] dispatch packet failed", "connID", session.ConnectionID(), "err", err)
+			}
+		}
+	}
+}
+
+// RecoveryMiddleware 捕获处理函数panic
+func RecoveryMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, session *Session, message *RouteMessage) (resp any, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					gslog.Critical("[Router] handler panic", "connID", session.ConnectionID(), "route", message.Route, "err", r, "stack", string(debug.Stack()))
+					err = fmt.Errorf("route %d handler panic: %v", message.Route, r)
+				}
+			}()
+			return next(ctx, session, message)
+		}
+	}
+}
+
+// LoggingMiddleware 记录处理耗时和错误
+func LoggingMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, session *Session, message *RouteMessage) (any, error) {
+			start := time.Now()
+			resp, err := next(ctx, session, message)
+			if err != nil {
+				gslog.Warn("[Router] handle route failed", "connID", session.ConnectionID(), "route", message.Route, "cost", time.Since(start), "err", err)
+			} else {
+				gslog.Debug("[Router] handle route", "connID", session.ConnectionID(), "route", message.Route, "cost", time.Since(start))
+			}
+			return resp, err
+		}
+	}
+}
+
+// AuthMiddleware 鉴权 校验失败时不调用处理函数
+// skipRoutes 不需要鉴权的路由 如登录
+func AuthMiddleware(check func(ctx context.Context, session *Session) error, skipRoutes ...uint32) Middleware {
+	skip := make(map[uint32]struct{}, len(skipRoutes))
+	for _, route := range skipRoutes {
+		skip[route] = struct{}{}
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, session *Session, message *RouteMessage) (any, error) {
+			if _, ok := skip[message.Route]; !ok {
+				if err := check(ctx, session); err != nil {
+					return nil, err
+				}
+			}
+			return next(ctx, session, message)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"GameServer/gslog"
+	"GameServer/utils"
+)
+
+// 请求/响应式RPC
+// 基于 PUBLISH 和路由器实现 请求和响应分别使用保留路由
+// 请求帧携带关联ID和剩余超时 接收方按本地时钟换算截止时间 不受双方时钟偏差影响
+// 响应帧携带关联ID和错误码
+// 服务端每个请求独立协程处理 客户端按关联ID匹配响应 慢调用不会阻塞同连接上的其他调用
+// 单个会话同时处理的请求数有上限 超出时直接回复 RpcUnavailable
+
+const (
+	RpcRequestRoute  uint32 = 0xFFFFFFFE // RPC请求保留路由
+	RpcResponseRoute uint32 = 0xFFFFFFFF // RPC响应保留路由
+)
+
+var (
+	// DefaultRpcTimeout ctx未设置截止时间时的默认调用超时
+	DefaultRpcTimeout = 10 * time.Second
+	// DefaultRpcMaxInflight 单个会话同时处理的请求数上限
+	DefaultRpcMaxInflight = 64
+
+	ErrRpcMethodRegistered = errors.New("rpc method already registered")
+	ErrRpcClientClosed     = errors.New("rpc client closed")
+)
+
+// RpcCode RPC错误码
+type RpcCode int32
+
+const (
+	RpcOK RpcCode = iota
+	RpcCanceled
+	RpcDeadlineExceeded
+	RpcMethodNotFound
+	RpcInvalidArgument
+	RpcInternal
+	RpcUnavailable
+)
+
+var rpcCodeNames = []string{
+	"OK",
+	"CANCELED",
+	"DEADLINE_EXCEEDED",
+	"METHOD_NOT_FOUND",
+	"INVALID_ARGUMENT",
+	"INTERNAL",
+	"UNAVAILABLE",
+}
+
+func (gs RpcCode) String() string {
+	if gs < 0 || int(gs) >= len(rpcCodeNames) {
+		return fmt.Sprintf("CODE(%d)", int32(gs))
+	}
+	return rpcCodeNames[gs]
+}
+
+// RpcError 带错误码的RPC错误 跨连接传递
+type RpcError struct {
+	Code    RpcCode
+	Message string
+}
+
+func NewRpcError(code RpcCode, format string, args ...any) *RpcError {
+	return &RpcError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (gs *RpcError) Error() string {
+	return fmt.Sprintf("rpc error: code = %s, message = %s", gs.Code.String(), gs.Message)
+}
+
+// RpcErrorCode 获取错误码 非 RpcError 视为内部错误
+func RpcErrorCode(err error) RpcCode {
+	if err == nil {
+		return RpcOK
+	}
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code
+	}
+	return RpcInternal
+}
+
+// rpcFrame RPC帧
+type rpcFrame struct {
+	Seq     uint64 // 关联ID
+	Timeout int64  // 剩余超时 纳秒 0表示不限制
+	Code    RpcCode
+	Method  string
+	Message string
+	Body    []byte
+
+	deadline time.Time // 接收方收到请求时换算的本地截止时间
+}
+
+func (gs *rpcFrame) encode(order binary.ByteOrder) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, order, gs.Seq); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, order, gs.Timeout); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, order, int32(gs.Code)); err != nil {
+		return nil, err
+	}
+	buf.Write(utils.EncodeString(gs.Method, order))
+	buf.Write(utils.EncodeString(gs.Message, order))
+	buf.Write(gs.Body)
+
+	return buf.Bytes(), nil
+}
+
+func (gs *rpcFrame) decode(data []byte, order binary.ByteOrder) error {
+	var err error
+	var code int32
+	r := bytes.NewReader(data)
+	if err = binary.Read(r, order, &gs.Seq); err != nil {
+		return err
+	}
+	if err = binary.Read(r, order, &gs.Timeout); err != nil {
+		return err
+	}
+	if err = binary.Read(r, order, &code); err != nil {
+		return err
+	}
+	gs.Code = RpcCode(code)
+	if gs.Method, err = utils.DecodeReaderString(r, order); err != nil {
+		return err
+	}
+	if gs.Message, err = utils.DecodeReaderString(r, order); err != nil {
+		return err
+	}
+	gs.Body, err = io.ReadAll(r)
+
+	return err
+}
+
+// RpcHandlerFunc RPC处理函数 返回编码前的响应
+type RpcHandlerFunc func(ctx context.Context, session *Session, body []byte) (any, error)
+
+// RpcServer RPC服务端
+type RpcServer struct {
+	presentation PresentationLayer
+	byteOrder    binary.ByteOrder
+	methods      map[string]RpcHandlerFunc
+	maxInflight  atomic.Int32 // 单个会话同时处理的请求数上限 <=0 不限制
+	lock         sync.RWMutex
+}
+
+// NewRpcServer 创建RPC服务端
+func NewRpcServer(presentation PresentationLayer, order binary.ByteOrder) *RpcServer {
+	instance := &RpcServer{
+		presentation: presentation,
+		byteOrder:    order,
+		methods:      make(map[string]RpcHandlerFunc),
+	}
+	instance.maxInflight.Store(int32(DefaultRpcMaxInflight))
+
+	return instance
+}
+
+// SetMaxInflight 调整单个会话同时处理的请求数上限 <=0 不限制
+func (gs *RpcServer) SetMaxInflight(n int) {
+	gs.maxInflight.Store(int32(n))
+}
+
+// Handle 注册方法
+func (gs *RpcServer) Handle(method string, handler RpcHandlerFunc) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if _, exist := gs.methods[method]; exist {
+		return fmt.Errorf("%w: %s", ErrRpcMethodRegistered, method)
+	}
+	gs.methods[method] = handler
+
+	return nil
+}
+
+// RegisterRpc 注册强类型RPC方法
+func RegisterRpc[Req, Resp any](server *RpcServer, method string, handler func(ctx context.Context, session *Session, req *Req) (*Resp, error)) error {
+	return server.Handle(method, func(ctx context.Context, session *Session, body []byte) (any, error) {
+		req := new(Req)
+		if err := server.presentation.Decode(body, req); err != nil {
+			return nil, NewRpcError(RpcInvalidArgument, "decode request failed: %v", err)
+		}
+		resp, err := handler(ctx, session, req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// Mount 挂载到路由器的请求路由
+func (gs *RpcServer) Mount(router *Router) error {
+	return router.Handle(RpcRequestRoute, gs.HandleRoute)
+}
+
+// HandleRoute 路由处理函数 每个请求独立协程处理 不通过路由器回复
+func (gs *RpcServer) HandleRoute(ctx context.Context, session *Session, message *RouteMessage) (any, error) {
+	request := &rpcFrame{}
+	if err := request.decode(message.Body, gs.byteOrder); err != nil {
+		return nil, err
+	}
+	if request.Timeout > 0 {
+		request.deadline = time.Now().Add(time.Duration(request.Timeout))
+	}
+
+	inflight := gs.inflight(session)
+	maxInflight := gs.maxInflight.Load()
+	if count := inflight.Add(1); maxInflight > 0 && count > maxInflight {
+		inflight.Add(-1)
+		gslog.Warn("[RpcServer] too many inflight requests, reject", "connID", session.ConnectionID(), "method", request.Method, "maxInflight", maxInflight)
+		// 不阻塞路由分发 发送队列满时丢弃 由调用方超时
+		response := &rpcFrame{Seq: request.Seq, Code: RpcUnavailable, Message: "too many inflight requests"}
+		if packet := gs.responsePacket(session, request, response); packet != nil {
+			_ = session.Conn().TryWritePacket(packet)
+		}
+		return nil, nil
+	}
+
+	go func() {
+		defer inflight.Add(-1)
+		gs.serve(ctx, session, request)
+	}()
+
+	return nil, nil
+}
+
+// inflight 会话处理中的请求数 保存在会话属性中 随会话释放
+func (gs *RpcServer) inflight(session *Session) *atomic.Int32 {
+	if value, ok := session.Attributes().load(gs); ok {
+		return value.(*atomic.Int32)
+	}
+	return session.Attributes().loadOrStore(gs, &atomic.Int32{}).(*atomic.Int32)
+}
+
+func (gs *RpcServer) serve(ctx context.Context, session *Session, request *rpcFrame) {
+	response := &rpcFrame{Seq: request.Seq}
+	resp, err := gs.invoke(ctx, session, request)
+	if err == nil && resp != nil {
+		response.Body, err = gs.presentation.Encode(resp)
+	}
+	if err != nil {
+		response.Code = RpcErrorCode(err)
+		response.Message = err.Error()
+		var rpcErr *RpcError
+		if errors.As(err, &rpcErr) {
+			response.Message = rpcErr.Message
+		}
+	}
+
+	packet := gs.responsePacket(session, request, response)
+	if packet == nil {
+		return
+	}
+	// 响应不受请求截止时间限制 连接关闭时返回
+	if err = session.Conn().WritePacket(context.Background(), packet); err != nil {
+		gslog.Warn("[RpcServer] write response failed", "connID", session.ConnectionID(), "method", request.Method, "err", err)
+	}
+}
+
+// responsePacket 编码响应 失败时返回nil
+func (gs *RpcServer) responsePacket(session *Session, request, response *rpcFrame) *PublishPacket {
+	payload, err := response.encode(gs.byteOrder)
+	if err != nil {
+		gslog.Error("[RpcServer] encode response failed", "connID", session.ConnectionID(), "method", request.Method, "err", err)
+		return nil
+	}
+	packet := NewControlPacket(Publish).(*PublishPacket)
+	packet.Payload = EncodeRouteMessage(RpcResponseRoute, payload, gs.byteOrder)
+
+	return packet
+}
+
+func (gs *RpcServer) invoke(ctx context.Context, session *Session, request *rpcFrame) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			gslog.Critical("[RpcServer] handler panic", "connID", session.ConnectionID(), "method", request.Method, "err", r, "stack", string(debug.Stack()))
+			err = NewRpcError(RpcInternal, "handler panic: %v", r)
+		}
+	}()
+
+	gs.lock.RLock()
+	handler, exist := gs.methods[request.Method]
+	gs.lock.RUnlock()
+	if !exist {
+		return nil, NewRpcError(RpcMethodNotFound, "method %s not found", request.Method)
+	}
+
+	if !request.deadline.IsZero() {
+		if time.Now().After(request.deadline) {
+			return nil, NewRpcError(RpcDeadlineExceeded, "deadline exceeded before handle")
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, request.deadline)
+		defer cancel()
+	}
+
+	return handler(ctx, session, request.Body)
+}
+
+// RpcClient RPC客户端
+type RpcClient struct {
+	session      *Session
+	presentation PresentationLayer
+	byteOrder    binary.ByteOrder
+	seq          atomic.Uint64
+	pending      map[uint64]chan *rpcFrame // 关联ID => 响应通道
+	closed       bool
+	lock         sync.Mutex
+}
+
+// NewRpcClient 创建RPC客户端
+// 响应需要经由路由器分发 见 Mount
+func NewRpcClient(session *Session, presentation PresentationLayer, order binary.ByteOrder) *RpcClient {
+	return &RpcClient{
+		session:      session,
+		presentation: presentation,
+		byteOrder:    order,
+		pending:      make(map[uint64]chan *rpcFrame),
+	}
+}
+
+// Mount 挂载到路由器的响应路由
+func (gs *RpcClient) Mount(router *Router) error {
+	return router.Handle(RpcResponseRoute, gs.HandleRoute)
+}
+
+// HandleRoute 路由处理函数 按关联ID投递响应
+func (gs *RpcClient) HandleRoute(ctx context.Context, session *Session, message *RouteMessage) (any, error) {
+	response := &rpcFrame{}
+	if err := response.decode(message.Body, gs.byteOrder); err != nil {
+		return nil, err
+	}
+
+	gs.lock.Lock()
+	ch, exist := gs.pending[response.Seq]
+	delete(gs.pending, response.Seq)
+	gs.lock.Unlock()
+	if !exist {
+		// 调用已超时或取消
+		gslog.Debug("[RpcClient] drop response without pending call", "connID", session.ConnectionID(), "seq", response.Seq)
+		return nil, nil
+	}
+	ch <- response
+
+	return nil, nil
+}
+
+// Call 发起调用并等待响应
+// ctx 剩余超时随请求传递给服务端 未设置截止时间时使用 DefaultRpcTimeout
+func (gs *RpcClient) Call(ctx context.Context, method string, req any, resp any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRpcTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return NewRpcError(RpcDeadlineExceeded, "call %s deadline exceeded", method)
+	}
+
+	body, err := gs.presentation.Encode(req)
+	if err != nil {
+		return NewRpcError(RpcInvalidArgument, "encode request failed: %v", err)
+	}
+	request := &rpcFrame{
+		Seq:     gs.seq.Add(1),
+		Timeout: int64(timeout),
+		Method:  method,
+		Body:    body,
+	}
+	payload, err := request.encode(gs.byteOrder)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *rpcFrame, 1)
+	gs.lock.Lock()
+	if gs.closed {
+		gs.lock.Unlock()
+		return ErrRpcClientClosed
+	}
+	gs.pending[request.Seq] = ch
+	gs.lock.Unlock()
+	defer func() {
+		gs.lock.Lock()
+		delete(gs.pending, request.Seq)
+		gs.lock.Unlock()
+	}()
+
+	packet := NewControlPacket(Publish).(*PublishPacket)
+	packet.Payload = EncodeRouteMessage(RpcRequestRoute, payload, gs.byteOrder)
+	if err = gs.session.Conn().WritePacket(ctx, packet); err != nil {
+		return NewRpcError(RpcUnavailable, "write request failed: %v", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return NewRpcError(RpcDeadlineExceeded, "call %s deadline exceeded", method)
+		}
+		return NewRpcError(RpcCanceled, "call %s canceled", method)
+	case response, ok := <-ch:
+		if !ok {
+			return ErrRpcClientClosed
+		}
+		if response.Code != RpcOK {
+			return &RpcError{Code: response.Code, Message: response.Message}
+		}
+		if resp == nil || len(response.Body) == 0 {
+			return nil
+		}
+		if err = gs.presentation.Decode(response.Body, resp); err != nil {
+			return NewRpcError(RpcInternal, "decode response failed: %v", err)
+		}
+		return nil
+	}
+}
+
+// Close 关闭客户端 所有等待中的调用返回 ErrRpcClientClosed
+func (gs *RpcClient) Close() {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.closed {
+		return
+	}
+	gs.closed = true
+	for seq, ch := range gs.pending {
+		close(ch)
+		delete(gs.pending, seq)
+	}
+}
@@ -0,0 +1,101 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type echoMessage struct {
+	Text string
+}
+
+// newRpcPair 在 net.Pipe 上建立RPC服务端和客户端
+func newRpcPair(t *testing.T, server *RpcServer) *RpcClient {
+	t.Helper()
+	clientBroker, serverBroker, _ := newBrokerPair(t, &BrokerConf{ConnectionID: "c", ByteOrder: binary.BigEndian}, &BrokerConf{ConnectionID: "s", ByteOrder: binary.BigEndian})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	presentation := NewJsonPresentation()
+	serverSession := NewSession(NewTcpConnectionKeeper(ctx, oneShotBrokerFactory(serverBroker)), WithSessionPresentation(presentation, binary.BigEndian))
+	serverRouter := NewRouter(presentation, binary.BigEndian)
+	if err := server.Mount(serverRouter); err != nil {
+		t.Fatalf("mount server: %v", err)
+	}
+	go serverRouter.Serve(ctx, serverSession)
+
+	clientSession := NewSession(NewTcpConnectionKeeper(ctx, oneShotBrokerFactory(clientBroker)), WithSessionPresentation(presentation, binary.BigEndian))
+	client := NewRpcClient(clientSession, presentation, binary.BigEndian)
+	clientRouter := NewRouter(presentation, binary.BigEndian)
+	if err := client.Mount(clientRouter); err != nil {
+		t.Fatalf("mount client: %v", err)
+	}
+	go clientRouter.Serve(ctx, clientSession)
+	t.Cleanup(func() {
+		client.Close()
+		_ = clientSession.Close()
+		_ = serverSession.Close()
+	})
+
+	return client
+}
+
+func TestRpcMaxInflight(t *testing.T) {
+	server := NewRpcServer(NewJsonPresentation(), binary.BigEndian)
+	server.SetMaxInflight(2)
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	err := RegisterRpc(server, "echo", func(ctx context.Context, _ *Session, req *echoMessage) (*echoMessage, error) {
+		if req.Text == "block" {
+			entered <- struct{}{}
+			<-release
+		}
+		return req, nil
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	client := newRpcPair(t, server)
+
+	call := func(text string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp := &echoMessage{}
+		if err := client.Call(ctx, "echo", &echoMessage{Text: text}, resp); err != nil {
+			return err
+		}
+		if resp.Text != text {
+			t.Errorf("response %q, want %q", resp.Text, text)
+		}
+		return nil
+	}
+	blocked := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			blocked <- call("block")
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-entered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("blocking calls not handled")
+		}
+	}
+
+	// 已达上限 立即拒绝而不是排队等待
+	if err = call("rejected"); RpcErrorCode(err) != RpcUnavailable {
+		t.Fatalf("call over limit: %v, want %s", err, RpcUnavailable)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err = <-blocked; err != nil {
+			t.Fatalf("blocking call: %v", err)
+		}
+	}
+	if err = call("after"); err != nil {
+		t.Fatalf("call after release: %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"GameServer/gslog"
+)
+
+var (
+	// DefaultHandshakeTimeout 默认握手超时时间
+	DefaultHandshakeTimeout = 10 * time.Second
+	// DrainProgressInterval 关闭时输出剩余连接数的间隔
+	DrainProgressInterval = time.Second
+
+	ErrServerClosed        = errors.New("server closed")
+	ErrServerAlreadyServed = errors.New("server already served")
+)
+
+// serverConnection 服务端注册的连接
+type serverConnection struct {
+	conn     *TcpConnectionKeeper
+	broker   ConnectionBroker
+	remoteIP netip.Addr // 关闭时释放接入过滤器的计数
+
+	lock   sync.Mutex
+	opened bool // 打开回调已执行
+	closed bool // 已从注册表移除
+}
+
+// Server TCP服务端
+// 负责监听&接受连接 完成握手后以 TcpConnectionKeeper 维护连接的读写和心跳
+// 所有存活连接以 ConnectionID 为键注册在服务端
+type Server struct {
+	address          string
+	brokerConf       BrokerConf // 连接配置模板 每个连接拷贝一份
+	maxConnections   int        // 最大连接数
+	handshakeTimeout time.Duration
+	openCallbacks    []OnConnectionOpenCallback
+	closeCallbacks   []OnConnectionCloseCallback
+	keeperOptions    []KeeperOption
+	tlsConfig        *tls.Config // 非nil时在TLS之上握手
+	acceptFilters    acceptFilterChain
+	duplicates       *duplicateFilters // 按连接ID保留的接收去重窗口
+
+	listener    net.Listener
+	connections map[string]*serverConnection // connectionID => serverConnection
+	connCount   atomic.Int32                 // 已接受未关闭的连接数 包含握手中的连接
+	serving     atomic.Bool
+	wg          sync.WaitGroup
+
+	ctx           context.Context // 服务端上下文 关闭时停止接受连接
+	ctxCancel     context.CancelFunc
+	connCtx       context.Context // 连接上下文 关闭流程结束后才取消
+	connCtxCancel context.CancelFunc
+
+	lock sync.RWMutex
+}
+
+// NewServer 创建服务端
+func NewServer(address string, cfg *BrokerConf, options ...ServerOption) *Server {
+	instance := &Server{
+		address:          address,
+		brokerConf:       *cfg,
+		handshakeTimeout: DefaultHandshakeTimeout,
+		connections:      make(map[string]*serverConnection),
+		duplicates:       newDuplicateFilters(),
+	}
+	instance.ctx, instance.ctxCancel = context.WithCancel(context.Background())
+	instance.connCtx, instance.connCtxCancel = context.WithCancel(context.Background())
+
+	for _, option := range options {
+		option.apply(instance)
+	}
+
+	return instance
+}
+
+// ListenAndServe 监听地址并阻塞处理连接
+func (gs *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", gs.address)
+	if err != nil {
+		return err
+	}
+
+	return gs.Serve(listener)
+}
+
+// Serve 在指定监听器上阻塞处理连接 直到服务端关闭
+// 配置了 WithTLSConfig 时监听器会被TLS包装
+func (gs *Server) Serve(listener net.Listener) error {
+	if !gs.serving.CompareAndSwap(false, true) {
+		return ErrServerAlreadyServed
+	}
+	if gs.tlsConfig != nil {
+		listener = tls.NewListener(listener, gs.tlsConfig)
+	}
+	gs.lock.Lock()
+	gs.listener = listener
+	gs.lock.Unlock()
+	if gs.ctx.Err() != nil {
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+
+	gslog.Info("[Server] start serving...", "addr", listener.Addr().String())
+
+	var tempDelay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if gs.ctx.Err() != nil {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// 临时错误 退避后重试
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				gslog.Warn("[Server] accept error, retrying...", "err", err, "delay", tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+
+		gs.connCount.Add(1)
+		gs.wg.Add(1)
+		go func() {
+			defer gs.wg.Done()
+			gs.handleConn(conn)
+		}()
+	}
+}
+
+// Addr 监听地址
+func (gs *Server) Addr() net.Addr {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	if gs.listener == nil {
+		return nil
+	}
+	return gs.listener.Addr()
+}
+
+// handleConn 处理单个连接的握手和注册
+func (gs *Server) handleConn(conn net.Conn) {
+	cfg := gs.brokerConf
+	remoteAddr := conn.RemoteAddr().String()
+	if cfg.Statistics == nil {
+		cfg.Statistics = NewFlowStatistics()
+	}
+
+	ip := remoteIP(conn.RemoteAddr())
+	if err := gs.acceptFilters.allow(ip); err != nil {
+		gs.connCount.Add(-1)
+		gslog.Warn("[Server] connection rejected by accept filter", "remoteAddr", remoteAddr, "err", err)
+		_ = conn.Close()
+		return
+	}
+	// 未注册成功时释放过滤器计数 注册后在连接关闭时释放
+	registered := false
+	defer func() {
+		if !registered {
+			gs.acceptFilters.release(ip)
+		}
+	}()
+
+	if gs.maxConnections > 0 && int(gs.connCount.Load()) > gs.maxConnections {
+		gs.connCount.Add(-1)
+		gslog.Warn("[Server] connections reach limit, refuse", "remoteAddr", remoteAddr, "maxConnections", gs.maxConnections)
+		_ = RefuseBroker(conn, &cfg, RefusedServerUnavailable)
+		return
+	}
+
+	// broker 在连接层创建前已赋值 关闭回调只会在连接层运行后触发
+	var broker ConnectionBroker
+	cfg.OnCloseCallback = func(connectionID string) {
+		gs.removeConnection(connectionID, broker)
+	}
+
+	if gs.handshakeTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(gs.handshakeTimeout))
+	}
+	var err error
+	broker, err = AcceptBroker(conn, &cfg)
+	if err != nil {
+		gs.connCount.Add(-1)
+		cfg.Statistics.OnHandshakeFailed()
+		gslog.Warn("[Server] accept broker failed", "remoteAddr", remoteAddr, "err", err)
+		_ = conn.Close()
+		return
+	}
+	if gs.handshakeTimeout > 0 {
+		_ = conn.SetDeadline(time.Time{})
+	}
+
+	connectionID := broker.ConnectionID()
+	gs.lock.Lock()
+	for {
+		if gs.ctx.Err() != nil {
+			gs.lock.Unlock()
+			gs.connCount.Add(-1)
+			gslog.Warn("[Server] server closed, refuse", "connID", connectionID, "remoteAddr", remoteAddr)
+			// 注册前关闭 不触发关闭回调
+			_ = broker.WritePacket(NewDisConnectPacket(DisconnectServerShutdown, 0))
+			_ = conn.Close()
+			return
+		}
+		stale, exist := gs.connections[connectionID]
+		if !exist {
+			break
+		}
+		// 同ID的旧连接可能是半开连接 踢掉旧连接后注册新连接
+		gs.lock.Unlock()
+		gslog.Warn("[Server] connection id duplicated, kick the old connection", "connID", connectionID, "remoteAddr", remoteAddr)
+		gs.kick(stale)
+		gs.lock.Lock()
+	}
+	options := append([]KeeperOption{withDuplicateFilter(gs.duplicates.acquire(connectionID))}, gs.keeperOptions...)
+	keeper := newTcpConnectionKeeper(gs.connCtx, oneShotBrokerFactory(broker), options...)
+	current := &serverConnection{conn: keeper, broker: broker, remoteIP: ip}
+	gs.connections[connectionID] = current
+	gs.lock.Unlock()
+	registered = true
+
+	gslog.Debug("[Server] connection established", "connID", connectionID, "remoteAddr", remoteAddr)
+	for _, callback := range gs.openCallbacks {
+		callback(keeper)
+	}
+	// 打开回调期间连接已关闭 关闭回调推迟到此处执行
+	current.lock.Lock()
+	current.opened = true
+	closed := current.closed
+	current.lock.Unlock()
+	if closed {
+		gs.notifyClose(connectionID)
+	}
+}
+
+// kick 以重复登录原因关闭旧连接 等待其从注册表中移除
+func (gs *Server) kick(stale *serverConnection) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCloseTimeout)
+	defer cancel()
+
+	_ = stale.conn.CloseWithReason(ctx, DisconnectDuplicateLogin, 0)
+	// 旧连接已在关闭中时 CloseWithReason 不等待 连接层退出前已执行关闭回调
+	<-stale.conn.stopChan
+}
+
+// removeConnection 连接关闭 从注册表中移除
+// 只移除与注册时相同的连接代理 避免误删同ID的新连接
+func (gs *Server) removeConnection(connectionID string, broker ConnectionBroker) {
+	gs.lock.Lock()
+	current, exist := gs.connections[connectionID]
+	if !exist || current.broker != broker {
+		gs.lock.Unlock()
+		return
+	}
+	delete(gs.connections, connectionID)
+	gs.lock.Unlock()
+	gs.connCount.Add(-1)
+	gs.duplicates.release(connectionID)
+	gs.acceptFilters.release(current.remoteIP)
+	// 服务端连接不重连 关闭回调执行前连接层已是关闭状态
+	current.conn.markClosed()
+
+	// 关闭回调始终在打开回调之后执行
+	current.lock.Lock()
+	current.closed = true
+	opened := current.opened
+	current.lock.Unlock()
+	if opened {
+		gs.notifyClose(connectionID)
+	}
+}
+
+func (gs *Server) notifyClose(connectionID string) {
+	gslog.Debug("[Server] connection closed", "connID", connectionID)
+	for _, callback := range gs.closeCallbacks {
+		callback(connectionID)
+	}
+}
+
+// Connection 根据连接ID获取连接
+func (gs *Server) Connection(connectionID string) (ConnectionLayer, bool) {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	current, ok := gs.connections[connectionID]
+	if !ok {
+		return nil, false
+	}
+	return current.conn, true
+}
+
+// ConnectionCount 当前已注册连接数
+func (gs *Server) ConnectionCount() int {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	return len(gs.connections)
+}
+
+// RangeConnections 遍历所有连接 返回false终止遍历
+func (gs *Server) RangeConnections(fn func(conn ConnectionLayer) bool) {
+	gs.lock.RLock()
+	connections := make([]ConnectionLayer, 0, len(gs.connections))
+	for _, current := range gs.connections {
+		connections = append(connections, current.conn)
+	}
+	gs.lock.RUnlock()
+
+	for _, conn := range connections {
+		if !fn(conn) {
+			return
+		}
+	}
+}
+
+// Shutdown 优雅关闭 不带重连建议的 Drain
+func (gs *Server) Shutdown(ctx context.Context) error {
+	return gs.Drain(ctx, 0)
+}
+
+// Drain 滚动更新时的协调关闭
+// 停止接受新连接 每个连接等待已发送的可靠包确认后发送携带停机原因和重连建议的 DISCONNECT
+// 待发送的包写完后关闭 ctx结束时强制关闭剩余连接
+func (gs *Server) Drain(ctx context.Context, reconnectAfter time.Duration) error {
+	gs.lock.Lock()
+	listener := gs.listener
+	gs.lock.Unlock()
+
+	gs.ctxCancel()
+	if listener != nil {
+		_ = listener.Close()
+	}
+	defer gs.connCtxCancel()
+	gslog.Info("[Server] drain start, stop accepting connections", "address", gs.address)
+
+	// 等待握手中的连接完成
+	handshakeDone := make(chan struct{})
+	go func() {
+		gs.wg.Wait()
+		close(handshakeDone)
+	}()
+	select {
+	case <-handshakeDone:
+	case <-ctx.Done():
+		gslog.Warn("[Server] drain timeout waiting handshakes", "address", gs.address)
+		return ctx.Err()
+	}
+
+	total := gs.ConnectionCount()
+	gslog.Info("[Server] draining connections", "address", gs.address, "count", total, "reconnectAfter", reconnectAfter)
+
+	var forced atomic.Int32
+	closeWg := sync.WaitGroup{}
+	gs.RangeConnections(func(conn ConnectionLayer) bool {
+		closeWg.Add(1)
+		go func() {
+			defer closeWg.Done()
+			err := conn.CloseWithReason(ctx, DisconnectServerShutdown, reconnectAfter)
+			if errors.Is(err, ErrOperationCancel) {
+				forced.Add(1)
+			}
+		}()
+		return true
+	})
+
+	closeDone := make(chan struct{})
+	go func() {
+		closeWg.Wait()
+		close(closeDone)
+	}()
+	// ctx结束后连接层强制关闭 同样会结束等待
+	ticker := time.NewTicker(DrainProgressInterval)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		select {
+		case <-closeDone:
+			waiting = false
+		case <-ticker.C:
+			gslog.Info("[Server] draining...", "address", gs.address, "remaining", gs.ConnectionCount(), "total", total)
+		}
+	}
+
+	gslog.Info("[Server] shutdown finished...", "address", gs.address, "total", total, "forced", forced.Load())
+
+	return ctx.Err()
+}
+
+// oneShotBrokerFactory 只返回一次给定连接代理的工厂
+// 服务端连接断开后不重连
+func oneShotBrokerFactory(broker ConnectionBroker) ConnBrokerFactory {
+	var used atomic.Bool
+	return func(ctx context.Context) ConnectionBroker {
+		if !used.CompareAndSwap(false, true) {
+			return nil
+		}
+		return broker
+	}
+}
@@ -0,0 +1,186 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer 在本地随机端口启动服务端 测试结束时关闭
+func startServer(t *testing.T, options ...ServerOption) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := NewServer(listener.Addr().String(), &BrokerConf{ByteOrder: binary.BigEndian}, options...)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+
+	return server
+}
+
+// dialServer 连接服务端并完成握手
+func dialServer(t *testing.T, server *Server, connectionID string) ConnectionBroker {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.address)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	broker, err := ConnectBroker(conn, &BrokerConf{ConnectionID: connectionID, ByteOrder: binary.BigEndian})
+	if err != nil {
+		t.Fatalf("connect broker: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = broker.Close()
+	})
+
+	return broker
+}
+
+func TestServerCloseCallbackAfterOpen(t *testing.T) {
+	var lock sync.Mutex
+	var events []string
+	record := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	manager := NewSessionManager(nil)
+	closed := make(chan struct{})
+	server := startServer(t,
+		WithOnConnectionOpen(func(conn ConnectionLayer) {
+			// 打开回调执行期间连接关闭
+			select {
+			case <-conn.(*TcpConnectionKeeper).stopChan:
+			case <-time.After(5 * time.Second):
+			}
+			record("open")
+		}),
+		WithSessionManager(manager),
+		WithOnConnectionClose(func(string) {
+			record("close")
+			close(closed)
+		}),
+	)
+
+	_ = dialServer(t, server, "c1").Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("close callback not called")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got := strings.Join(events, ","); got != "open,close" {
+		t.Fatalf("callbacks %s, want open,close", got)
+	}
+	if count := manager.Count(); count != 0 {
+		t.Fatalf("%d sessions left after close", count)
+	}
+}
+
+func TestServerRateLimitDisconnect(t *testing.T) {
+	closed := make(chan struct{})
+	server := startServer(t,
+		WithRateLimit(RateLimitConf{
+			Packets: map[PacketType]RateLimit{Publish: {Rate: 1, Burst: 2}},
+			Action:  RateLimitDisconnect,
+		}),
+		WithOnConnectionOpen(func(conn ConnectionLayer) {
+			go func() {
+				for range conn.Read() {
+				}
+			}()
+		}),
+		WithOnConnectionClose(func(string) {
+			close(closed)
+		}),
+	)
+
+	client := dialServer(t, server, "c1")
+	for i := 0; i < 3; i++ {
+		if err := client.WritePacket(newPublish(0, "flood")); err != nil {
+			t.Fatalf("write publish %d: %v", i, err)
+		}
+	}
+	for {
+		packet, err := client.ReadPacket()
+		if err != nil {
+			t.Fatalf("read packet: %v", err)
+		}
+		if disconnect, ok := packet.(*DisConnectPacket); ok {
+			if disconnect.Reason != DisconnectRateLimited {
+				t.Fatalf("disconnect reason %s, want %s", disconnect.Reason, DisconnectRateLimited)
+			}
+			break
+		}
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server connection not closed")
+	}
+}
+
+func TestServerDuplicateConnectionKicksOld(t *testing.T) {
+	opened := make(chan ConnectionLayer, 2)
+	closed := make(chan string, 2)
+	server := startServer(t,
+		WithOnConnectionOpen(func(conn ConnectionLayer) {
+			opened <- conn
+		}),
+		WithOnConnectionClose(func(connectionID string) {
+			closed <- connectionID
+		}),
+	)
+
+	// 旧连接未断开 例如客户端换了网络后的半开连接
+	stale := dialServer(t, server, "c1")
+	<-opened
+	current := dialServer(t, server, "c1")
+
+	packet, err := stale.ReadPacket()
+	if err != nil {
+		t.Fatalf("read packet: %v", err)
+	}
+	if disconnect, ok := packet.(*DisConnectPacket); !ok || disconnect.Reason != DisconnectDuplicateLogin {
+		t.Fatalf("old connection received %v, want %s", packet, DisconnectDuplicateLogin)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("old connection not closed")
+	}
+
+	// 新连接注册成功 服务端写出的包由新连接收到
+	var conn ConnectionLayer
+	select {
+	case conn = <-opened:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("new connection not registered")
+	}
+	if registered, ok := server.Connection("c1"); !ok || registered != conn {
+		t.Fatalf("registered connection %v, want the new one", registered)
+	}
+	if err = conn.WritePacket(context.Background(), newPublish(0, "hello")); err != nil {
+		t.Fatalf("write packet: %v", err)
+	}
+	if packet, err = current.ReadPacket(); err != nil {
+		t.Fatalf("read packet: %v", err)
+	}
+	if publish, ok := packet.(*PublishPacket); !ok || string(publish.Payload) != "hello" {
+		t.Fatalf("new connection received %v", packet)
+	}
+}
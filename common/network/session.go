@@ -0,0 +1,238 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNoPresentation = errors.New("session without presentation layer")
+)
+
+// Session 会话
+// 承载一个连接层 作为路由等上层处理的调用上下文
+// 记录玩家身份、属性、连接时间和最近活跃时间
+type Session struct {
+	conn         ConnectionLayer
+	presentation PresentationLayer // 发送时的编码 nil时只能发送已编码的数据
+	byteOrder    binary.ByteOrder
+	attributes   *Attributes
+	connectedAt  time.Time
+	userID       atomic.Pointer[string] // 绑定的玩家ID 未绑定时使用鉴权身份
+	lastActive   atomic.Int64           // 最近活跃时间 unix纳秒
+	idleNotified atomic.Bool            // 本次空闲是否已回调
+}
+
+type SessionOption interface {
+	apply(session *Session)
+}
+
+type SessionOptionFunc func(session *Session)
+
+func (f SessionOptionFunc) apply(session *Session) {
+	f(session)
+}
+
+// WithSessionPresentation 会话发送消息使用的表示层和字节序
+func WithSessionPresentation(presentation PresentationLayer, order binary.ByteOrder) SessionOption {
+	return SessionOptionFunc(func(session *Session) {
+		session.presentation = presentation
+		session.byteOrder = order
+	})
+}
+
+// NewSession 创建会话
+func NewSession(conn ConnectionLayer, options ...SessionOption) *Session {
+	now := time.Now()
+	instance := &Session{
+		conn:        conn,
+		byteOrder:   binary.BigEndian,
+		attributes:  newAttributes(),
+		connectedAt: now,
+	}
+	instance.lastActive.Store(now.UnixNano())
+
+	for _, option := range options {
+		option.apply(instance)
+	}
+
+	return instance
+}
+
+// SessionID 会话ID 与连接ID相同
+func (gs *Session) SessionID() string {
+	return gs.conn.ConnectionID()
+}
+
+// ConnectionID 连接ID
+func (gs *Session) ConnectionID() string {
+	return gs.conn.ConnectionID()
+}
+
+// Conn 会话所属连接层
+func (gs *Session) Conn() ConnectionLayer {
+	return gs.conn
+}
+
+// Principal 鉴权通过的身份信息
+func (gs *Session) Principal() *Principal {
+	return gs.conn.Principal()
+}
+
+// UserID 玩家ID 优先使用登录绑定的ID 其次为鉴权身份 都没有时为空
+func (gs *Session) UserID() string {
+	if userID := gs.userID.Load(); userID != nil {
+		return *userID
+	}
+	if principal := gs.conn.Principal(); principal != nil {
+		return principal.ID
+	}
+	return ""
+}
+
+// bindUser 绑定玩家ID 由会话管理器调用
+func (gs *Session) bindUser(userID string) {
+	gs.userID.Store(&userID)
+}
+
+// Attributes 会话属性
+func (gs *Session) Attributes() *Attributes {
+	return gs.attributes
+}
+
+// ConnectedAt 连接建立时间
+func (gs *Session) ConnectedAt() time.Time {
+	return gs.connectedAt
+}
+
+// LastActive 最近一次收到消息的时间
+func (gs *Session) LastActive() time.Time {
+	return time.Unix(0, gs.lastActive.Load())
+}
+
+// Touch 刷新活跃时间 路由分发消息时调用
+func (gs *Session) Touch() {
+	gs.lastActive.Store(time.Now().UnixNano())
+	gs.idleNotified.Store(false)
+}
+
+// Send 以指定路由编码并发送消息
+func (gs *Session) Send(ctx context.Context, route uint32, msg any) error {
+	if gs.presentation == nil {
+		return ErrNoPresentation
+	}
+	body, err := gs.presentation.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return gs.SendRaw(ctx, route, body)
+}
+
+// SendRaw 以指定路由发送已编码的数据
+func (gs *Session) SendRaw(ctx context.Context, route uint32, body []byte) error {
+	packet := NewControlPacket(Publish).(*PublishPacket)
+	packet.Payload = EncodeRouteMessage(route, body, gs.byteOrder)
+
+	return gs.conn.WritePacket(ctx, packet)
+}
+
+// Close 关闭会话所属连接
+func (gs *Session) Close() error {
+	return gs.conn.Close()
+}
+
+// Kick 以指定原因关闭会话 最多等待 DefaultCloseTimeout
+func (gs *Session) Kick(reason DisconnectReason) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCloseTimeout)
+	defer cancel()
+
+	return gs.conn.CloseWithReason(ctx, reason, 0)
+}
+
+// Attributes 会话属性 并发安全
+// 通过 AttributeKey 存取以保证类型一致
+type Attributes struct {
+	values map[any]any
+	lock   sync.RWMutex
+}
+
+func newAttributes() *Attributes {
+	return &Attributes{
+		values: make(map[any]any),
+	}
+}
+
+func (gs *Attributes) load(key any) (any, bool) {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	value, ok := gs.values[key]
+	return value, ok
+}
+
+func (gs *Attributes) store(key, value any) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	gs.values[key] = value
+}
+
+// loadOrStore 已存在时返回已有的值 否则保存value
+func (gs *Attributes) loadOrStore(key, value any) any {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if current, ok := gs.values[key]; ok {
+		return current
+	}
+	gs.values[key] = value
+
+	return value
+}
+
+func (gs *Attributes) remove(key any) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	delete(gs.values, key)
+}
+
+// AttributeKey 类型化的会话属性键 以键对象本身区分 同名的两个键互不影响
+type AttributeKey[T any] struct {
+	name string
+}
+
+// NewAttributeKey 创建属性键 一般定义为包级变量
+func NewAttributeKey[T any](name string) *AttributeKey[T] {
+	return &AttributeKey[T]{name: name}
+}
+
+// Name 属性名
+func (gs *AttributeKey[T]) Name() string {
+	return gs.name
+}
+
+// Get 获取属性
+func (gs *AttributeKey[T]) Get(session SessionLayer) (T, bool) {
+	value, ok := session.Attributes().load(gs)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return value.(T), true
+}
+
+// Set 设置属性
+func (gs *AttributeKey[T]) Set(session SessionLayer, value T) {
+	session.Attributes().store(gs, value)
+}
+
+// Delete 删除属性
+func (gs *AttributeKey[T]) Delete(session SessionLayer) {
+	session.Attributes().remove(gs)
+}
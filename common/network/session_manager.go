@@ -0,0 +1,257 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"GameServer/gslog"
+)
+
+// 会话管理
+// 以连接ID和玩家ID索引存活会话 同一玩家重复登录时按策略踢掉旧会话或拒绝新登录
+// 连接建立/关闭时创建/移除会话 并回调应用层
+
+var (
+	ErrDuplicateLogin   = errors.New("duplicate login")
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrEmptyUserID      = errors.New("empty user id")
+	ErrUserAlreadyBound = errors.New("session already bound to another user")
+)
+
+// minIdleCheckInterval 空闲检测最小间隔
+const minIdleCheckInterval = 100 * time.Millisecond
+
+// DuplicateLoginPolicy 重复登录策略
+type DuplicateLoginPolicy int
+
+const (
+	KickOldSession   DuplicateLoginPolicy = iota // 踢掉旧会话
+	RejectNewSession                             // 拒绝新登录
+)
+
+// ApplicationHooks 函数形式的应用层 未设置的回调忽略
+type ApplicationHooks struct {
+	Open  func(session SessionLayer)
+	Close func(session SessionLayer)
+	Idle  func(session SessionLayer, idle time.Duration)
+}
+
+func (gs ApplicationHooks) OnOpen(session SessionLayer) {
+	if gs.Open != nil {
+		gs.Open(session)
+	}
+}
+
+func (gs ApplicationHooks) OnClose(session SessionLayer) {
+	if gs.Close != nil {
+		gs.Close(session)
+	}
+}
+
+func (gs ApplicationHooks) OnIdle(session SessionLayer, idle time.Duration) {
+	if gs.Idle != nil {
+		gs.Idle(session, idle)
+	}
+}
+
+// SessionManager 会话管理器
+type SessionManager struct {
+	application     ApplicationLayer
+	presentation    PresentationLayer
+	byteOrder       binary.ByteOrder
+	idleTimeout     time.Duration // <=0 不检测空闲
+	duplicatePolicy DuplicateLoginPolicy
+
+	sessions map[string]*Session // connectionID => session
+	users    map[string]*Session // userID => session
+	stopChan chan struct{}
+	stopOnce sync.Once
+
+	lock sync.RWMutex
+}
+
+// NewSessionManager 创建会话管理器 application 可为nil
+func NewSessionManager(application ApplicationLayer, options ...SessionManagerOption) *SessionManager {
+	if application == nil {
+		application = ApplicationHooks{}
+	}
+	instance := &SessionManager{
+		application: application,
+		byteOrder:   binary.BigEndian,
+		sessions:    make(map[string]*Session),
+		users:       make(map[string]*Session),
+		stopChan:    make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option.apply(instance)
+	}
+	if instance.idleTimeout > 0 {
+		go instance.idleLoop()
+	}
+
+	return instance
+}
+
+// Open 连接建立 创建并注册会话
+// 连接已鉴权时以鉴权身份绑定玩家 重复登录被拒绝时关闭新连接并返回nil
+func (gs *SessionManager) Open(conn ConnectionLayer) *Session {
+	session := NewSession(conn, WithSessionPresentation(gs.presentation, gs.byteOrder))
+
+	gs.lock.Lock()
+	gs.sessions[session.SessionID()] = session
+	gs.lock.Unlock()
+
+	if userID := session.UserID(); userID != "" {
+		if err := gs.Bind(session, userID); err != nil {
+			gslog.Warn("[SessionManager] bind authenticated user failed", "connID", session.SessionID(), "userID", userID, "err", err)
+			gs.remove(session.SessionID())
+			go session.Kick(DisconnectDuplicateLogin)
+			return nil
+		}
+	}
+	gslog.Debug("[SessionManager] session opened", "connID", session.SessionID(), "userID", session.UserID())
+	gs.application.OnOpen(session)
+
+	return session
+}
+
+// Bind 会话登录成功后绑定玩家ID
+// 该玩家已有其他会话时按重复登录策略处理
+func (gs *SessionManager) Bind(session *Session, userID string) error {
+	if userID == "" {
+		return ErrEmptyUserID
+	}
+
+	gs.lock.Lock()
+	if _, ok := gs.sessions[session.SessionID()]; !ok {
+		gs.lock.Unlock()
+		return ErrSessionNotFound
+	}
+	if bound := session.UserID(); bound != "" && bound != userID && gs.users[bound] == session {
+		gs.lock.Unlock()
+		return ErrUserAlreadyBound
+	}
+	old, exist := gs.users[userID]
+	if exist && old != session && gs.duplicatePolicy == RejectNewSession {
+		gs.lock.Unlock()
+		return ErrDuplicateLogin
+	}
+	gs.users[userID] = session
+	session.bindUser(userID)
+	gs.lock.Unlock()
+
+	if exist && old != session {
+		gslog.Info("[SessionManager] kick duplicate login", "userID", userID, "oldConnID", old.SessionID(), "newConnID", session.SessionID())
+		// 关闭会等待断开包发出 不阻塞登录流程
+		go old.Kick(DisconnectDuplicateLogin)
+	}
+
+	return nil
+}
+
+// OnConnectionClose 连接关闭 移除会话并回调应用层
+func (gs *SessionManager) OnConnectionClose(connectionID string) {
+	session := gs.remove(connectionID)
+	if session == nil {
+		return
+	}
+	gslog.Debug("[SessionManager] session closed", "connID", connectionID, "userID", session.UserID())
+	gs.application.OnClose(session)
+}
+
+// remove 移除会话 只移除玩家索引中仍指向该会话的项
+func (gs *SessionManager) remove(connectionID string) *Session {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	session, ok := gs.sessions[connectionID]
+	if !ok {
+		return nil
+	}
+	delete(gs.sessions, connectionID)
+	if userID := session.UserID(); userID != "" && gs.users[userID] == session {
+		delete(gs.users, userID)
+	}
+
+	return session
+}
+
+// Session 根据连接ID获取会话
+func (gs *SessionManager) Session(connectionID string) (*Session, bool) {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	session, ok := gs.sessions[connectionID]
+	return session, ok
+}
+
+// SessionByUser 根据玩家ID获取会话
+func (gs *SessionManager) SessionByUser(userID string) (*Session, bool) {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	session, ok := gs.users[userID]
+	return session, ok
+}
+
+// Count 存活会话数
+func (gs *SessionManager) Count() int {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	return len(gs.sessions)
+}
+
+// Range 遍历存活会话 fn返回false时停止
+// 遍历的是调用时的快照 fn中可以安全地关闭会话
+func (gs *SessionManager) Range(fn func(session *Session) bool) {
+	for _, session := range gs.snapshot() {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+func (gs *SessionManager) snapshot() []*Session {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	sessions := make([]*Session, 0, len(gs.sessions))
+	for _, session := range gs.sessions {
+		sessions = append(sessions, session)
+	}
+
+	return sessions
+}
+
+// Stop 停止空闲检测 不关闭会话
+func (gs *SessionManager) Stop() {
+	gs.stopOnce.Do(func() {
+		close(gs.stopChan)
+	})
+}
+
+// idleLoop 定期检测空闲会话
+func (gs *SessionManager) idleLoop() {
+	interval := max(gs.idleTimeout/2, minIdleCheckInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gs.stopChan:
+			return
+		case now := <-ticker.C:
+			for _, session := range gs.snapshot() {
+				idle := now.Sub(session.LastActive())
+				if idle < gs.idleTimeout || session.idleNotified.Swap(true) {
+					continue
+				}
+				gs.application.OnIdle(session, idle)
+			}
+		}
+	}
+}
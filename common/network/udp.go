@@ -0,0 +1,950 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"GameServer/gslog"
+)
+
+// 可靠UDP传输(参考KCP)
+// 每个会话以 conv 标识 会话实现 net.Conn 供 ConnectBroker/AcceptBroker 复用握手和包编码
+// 可靠通道: 每次 Write 为一个消息 按MSS分片 序号+逐段确认(选择确认)+累计确认(una)
+//         超时重传 RTO按RTT估计 收到后续序号的确认达到阈值时快速重传 拥塞窗口慢启动/拥塞避免
+// 不可靠有序通道: 单段消息 不重传 只接收比已收到的序号更新的消息 适合状态快照
+// 两个通道的消息都以完整消息为单位进入读队列 因此可以在同一个包流中交错读取
+
+const (
+	udpCmdPush       byte = 1 // 可靠数据
+	udpCmdAck        byte = 2 // 确认
+	udpCmdUnreliable byte = 3 // 不可靠有序数据
+	udpCmdClose      byte = 4 // 关闭
+
+	// conv(4) cmd(1) frg(1) wnd(2) ts(4) sn(4) una(4) len(2)
+	udpHeaderSize   = 22
+	udpMaxFragments = 255
+)
+
+var (
+	ErrUdpSessionClosed       = errors.New("udp session closed")
+	ErrUdpSessionDead         = errors.New("udp session dead link")
+	ErrUdpMessageTooLarge     = errors.New("udp message too large")
+	ErrUdpListenerClosed      = errors.New("udp listener closed")
+	ErrInvalidUdpSegment      = errors.New("invalid udp segment")
+	ErrUnreliableNotSupported = errors.New("unreliable channel not supported")
+)
+
+// UdpConf 可靠UDP配置
+type UdpConf struct {
+	MTU                int           // 单个数据报最大长度
+	SendWindow         int           // 发送窗口 段数
+	RecvWindow         int           // 接收窗口 段数
+	Interval           time.Duration // 刷新间隔
+	MinRTO             time.Duration // 最小重传超时
+	MaxRTO             time.Duration // 最大重传超时
+	FastResend         int           // 被跳过确认的次数达到该值时快速重传 <=0 关闭
+	NoCongestionWindow bool          // 关闭拥塞控制 只受收发窗口限制
+	DeadLink           int           // 单段最大发送次数 超过后会话失效
+	LossRate           float64       // 模拟丢包率 仅用于测试
+}
+
+var DefaultUdpConf = UdpConf{
+	MTU:        1400,
+	SendWindow: 128,
+	RecvWindow: 128,
+	Interval:   10 * time.Millisecond,
+	MinRTO:     30 * time.Millisecond,
+	MaxRTO:     5 * time.Second,
+	FastResend: 2,
+	DeadLink:   20,
+}
+
+func (gs *UdpConf) withDefaults() UdpConf {
+	conf := DefaultUdpConf
+	if gs == nil {
+		return conf
+	}
+	conf.NoCongestionWindow = gs.NoCongestionWindow
+	conf.LossRate = gs.LossRate
+	conf.FastResend = gs.FastResend
+	if gs.MTU > udpHeaderSize {
+		conf.MTU = gs.MTU
+	}
+	if gs.SendWindow > 0 {
+		conf.SendWindow = gs.SendWindow
+	}
+	if gs.RecvWindow > 0 {
+		conf.RecvWindow = gs.RecvWindow
+	}
+	if gs.Interval > 0 {
+		conf.Interval = gs.Interval
+	}
+	if gs.MinRTO > 0 {
+		conf.MinRTO = gs.MinRTO
+	}
+	if gs.MaxRTO > 0 {
+		conf.MaxRTO = gs.MaxRTO
+	}
+	if gs.DeadLink > 0 {
+		conf.DeadLink = gs.DeadLink
+	}
+
+	return conf
+}
+
+// seqBefore 序号比较 处理回绕
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+type udpSegment struct {
+	conv uint32
+	cmd  byte
+	frg  byte // 剩余分片数 0为消息最后一段
+	wnd  uint16
+	ts   uint32
+	sn   uint32
+	una  uint32
+	data []byte
+
+	// 发送状态
+	resendAt time.Time
+	rto      time.Duration
+	fastAck  int
+	xmit     int
+}
+
+func (gs *udpSegment) encode(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, gs.conv)
+	buf = append(buf, gs.cmd, gs.frg)
+	buf = binary.BigEndian.AppendUint16(buf, gs.wnd)
+	buf = binary.BigEndian.AppendUint32(buf, gs.ts)
+	buf = binary.BigEndian.AppendUint32(buf, gs.sn)
+	buf = binary.BigEndian.AppendUint32(buf, gs.una)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(gs.data)))
+	return append(buf, gs.data...)
+}
+
+// decodeUdpSegments 解析数据报中的所有段
+func decodeUdpSegments(data []byte) ([]*udpSegment, error) {
+	var segments []*udpSegment
+	for len(data) > 0 {
+		if len(data) < udpHeaderSize {
+			return nil, ErrInvalidUdpSegment
+		}
+		segment := &udpSegment{
+			conv: binary.BigEndian.Uint32(data),
+			cmd:  data[4],
+			frg:  data[5],
+			wnd:  binary.BigEndian.Uint16(data[6:]),
+			ts:   binary.BigEndian.Uint32(data[8:]),
+			sn:   binary.BigEndian.Uint32(data[12:]),
+			una:  binary.BigEndian.Uint32(data[16:]),
+		}
+		length := int(binary.BigEndian.Uint16(data[20:]))
+		data = data[udpHeaderSize:]
+		if length > len(data) {
+			return nil, ErrInvalidUdpSegment
+		}
+		segment.data = append([]byte(nil), data[:length]...)
+		data = data[length:]
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+type udpAck struct {
+	sn uint32
+	ts uint32
+}
+
+// udpSession 可靠UDP会话
+type udpSession struct {
+	conv       uint32
+	conf       UdpConf
+	mss        int
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	output     func(data []byte) error
+	onClose    func()
+	start      time.Time
+
+	// 发送
+	sndNxt        uint32
+	sndUna        uint32
+	sndQueue      []*udpSegment // 等待进入窗口
+	sndBuf        []*udpSegment // 已发送未确认 按序号排列
+	unreliableSnd uint32
+	peerWnd       int
+	cwnd          int
+	ssthresh      int
+	ackCount      int
+	srtt          time.Duration
+	rttvar        time.Duration
+	rto           time.Duration
+
+	// 接收
+	rcvNxt        uint32
+	rcvBuf        map[uint32]*udpSegment // 乱序到达的段
+	fragments     []byte                 // 组装中的消息
+	messages      [][]byte               // 已完整的消息
+	readBuf       []byte                 // 当前消息未读部分
+	unreliableRcv uint32
+	ackList       []udpAck
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        bool
+	closeErr      error
+	readNotify    chan struct{}
+	writeNotify   chan struct{}
+	flushNotify   chan struct{}
+	closeChan     chan struct{}
+
+	lock sync.Mutex
+}
+
+func newUdpSession(conv uint32, conf UdpConf, localAddr, remoteAddr net.Addr, output func(data []byte) error) *udpSession {
+	instance := &udpSession{
+		conv:        conv,
+		conf:        conf,
+		mss:         conf.MTU - udpHeaderSize,
+		localAddr:   localAddr,
+		remoteAddr:  remoteAddr,
+		output:      output,
+		start:       time.Now(),
+		peerWnd:     conf.RecvWindow,
+		cwnd:        1,
+		ssthresh:    conf.SendWindow,
+		rto:         200 * time.Millisecond,
+		rcvBuf:      make(map[uint32]*udpSegment),
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+		flushNotify: make(chan struct{}, 1),
+		closeChan:   make(chan struct{}),
+	}
+	if instance.rto < conf.MinRTO {
+		instance.rto = conf.MinRTO
+	}
+	go instance.updateLoop()
+
+	return instance
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (gs *udpSession) now() uint32 {
+	return uint32(time.Since(gs.start).Milliseconds())
+}
+
+// Read 读取消息 可靠和不可靠通道的消息按到达顺序交错
+func (gs *udpSession) Read(p []byte) (int, error) {
+	for {
+		gs.lock.Lock()
+		if len(gs.readBuf) == 0 && len(gs.messages) > 0 {
+			gs.readBuf = gs.messages[0]
+			gs.messages[0] = nil
+			gs.messages = gs.messages[1:]
+			// 读走消息后接收窗口变大 通知对端
+			notify(gs.flushNotify)
+		}
+		if len(gs.readBuf) > 0 {
+			n := copy(p, gs.readBuf)
+			gs.readBuf = gs.readBuf[n:]
+			gs.lock.Unlock()
+			return n, nil
+		}
+		if gs.closed {
+			err := gs.closeErr
+			gs.lock.Unlock()
+			return 0, err
+		}
+		deadline := gs.readDeadline
+		gs.lock.Unlock()
+
+		if err := waitNotify(gs.readNotify, gs.closeChan, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 发送一个可靠消息 发送队列过长时阻塞
+func (gs *udpSession) Write(p []byte) (int, error) {
+	count := (len(p) + gs.mss - 1) / gs.mss
+	if count == 0 {
+		return 0, nil
+	}
+	if count > udpMaxFragments {
+		return 0, ErrUdpMessageTooLarge
+	}
+
+	for {
+		gs.lock.Lock()
+		if gs.closed {
+			gs.lock.Unlock()
+			return 0, ErrUdpSessionClosed
+		}
+		if len(gs.sndQueue) < 2*gs.conf.SendWindow {
+			break
+		}
+		deadline := gs.writeDeadline
+		gs.lock.Unlock()
+
+		if err := waitNotify(gs.writeNotify, gs.closeChan, deadline); err != nil {
+			return 0, err
+		}
+	}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * gs.mss
+		if end > len(p) {
+			end = len(p)
+		}
+		gs.sndQueue = append(gs.sndQueue, &udpSegment{
+			cmd:  udpCmdPush,
+			frg:  byte(count - i - 1),
+			data: append([]byte(nil), p[i*gs.mss:end]...),
+		})
+	}
+	gs.lock.Unlock()
+	notify(gs.flushNotify)
+
+	return len(p), nil
+}
+
+// WriteUnreliable 发送一个不可靠有序消息 不分片不重传
+func (gs *udpSession) WriteUnreliable(p []byte) error {
+	if len(p) > gs.mss {
+		return ErrUdpMessageTooLarge
+	}
+	gs.lock.Lock()
+	if gs.closed {
+		gs.lock.Unlock()
+		return ErrUdpSessionClosed
+	}
+	gs.unreliableSnd++
+	segment := &udpSegment{
+		conv: gs.conv,
+		cmd:  udpCmdUnreliable,
+		wnd:  gs.recvWindowLocked(),
+		ts:   gs.now(),
+		sn:   gs.unreliableSnd,
+		una:  gs.rcvNxt,
+		data: p,
+	}
+	gs.lock.Unlock()
+
+	return gs.send(segment.encode(make([]byte, 0, udpHeaderSize+len(p))))
+}
+
+// waitNotify 等待通知 超过期限返回超时错误
+func waitNotify(ch chan struct{}, closeChan chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+	case <-closeChan:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+
+	return nil
+}
+
+// input 处理收到的数据报
+func (gs *udpSession) input(data []byte) {
+	segments, err := decodeUdpSegments(data)
+	if err != nil {
+		gslog.Debug("[UdpSession] drop invalid datagram", "conv", gs.conv, "remoteAddr", gs.remoteAddr.String(), "err", err)
+		return
+	}
+
+	gs.lock.Lock()
+	if gs.closed {
+		gs.lock.Unlock()
+		return
+	}
+	now := gs.now()
+	received := false
+	for _, segment := range segments {
+		if segment.conv != gs.conv {
+			continue
+		}
+		gs.peerWnd = int(segment.wnd)
+		gs.ackUntil(segment.una)
+
+		switch segment.cmd {
+		case udpCmdAck:
+			if rtt := int32(now - segment.ts); rtt >= 0 {
+				gs.updateRTT(time.Duration(rtt) * time.Millisecond)
+			}
+			gs.ackSegment(segment.sn)
+		case udpCmdPush:
+			if !seqBefore(segment.sn, gs.rcvNxt+uint32(gs.conf.RecvWindow)) {
+				// 超出接收窗口 丢弃且不确认
+				continue
+			}
+			gs.ackList = append(gs.ackList, udpAck{sn: segment.sn, ts: segment.ts})
+			if !seqBefore(segment.sn, gs.rcvNxt) {
+				if _, exist := gs.rcvBuf[segment.sn]; !exist {
+					gs.rcvBuf[segment.sn] = segment
+				}
+			}
+			received = gs.assemble() || received
+		case udpCmdUnreliable:
+			if gs.unreliableRcv == 0 || seqBefore(gs.unreliableRcv, segment.sn) {
+				gs.unreliableRcv = segment.sn
+				gs.messages = append(gs.messages, segment.data)
+				received = true
+			}
+		case udpCmdClose:
+			gs.closeLocked(io.EOF)
+			gs.lock.Unlock()
+			gs.afterClose()
+			return
+		}
+	}
+	gs.lock.Unlock()
+
+	if received {
+		notify(gs.readNotify)
+	}
+	notify(gs.flushNotify)
+	notify(gs.writeNotify)
+}
+
+// assemble 按序移动接收缓冲区中的段并组装消息 返回是否有新消息 调用方持有锁
+func (gs *udpSession) assemble() bool {
+	completed := false
+	for {
+		segment, ok := gs.rcvBuf[gs.rcvNxt]
+		if !ok {
+			return completed
+		}
+		delete(gs.rcvBuf, gs.rcvNxt)
+		gs.rcvNxt++
+		gs.fragments = append(gs.fragments, segment.data...)
+		if segment.frg == 0 {
+			gs.messages = append(gs.messages, gs.fragments)
+			gs.fragments = nil
+			completed = true
+		}
+	}
+}
+
+// ackUntil 累计确认 移除序号小于una的段 调用方持有锁
+func (gs *udpSession) ackUntil(una uint32) {
+	count := 0
+	for _, segment := range gs.sndBuf {
+		if !seqBefore(segment.sn, una) {
+			break
+		}
+		count++
+	}
+	for i := 0; i < count; i++ {
+		gs.onAcked()
+	}
+	gs.sndBuf = gs.sndBuf[count:]
+	gs.updateUna()
+}
+
+// ackSegment 选择确认 移除指定段 并为之前未确认的段累计快速重传计数 调用方持有锁
+func (gs *udpSession) ackSegment(sn uint32) {
+	for i, segment := range gs.sndBuf {
+		if segment.sn == sn {
+			gs.sndBuf = append(gs.sndBuf[:i], gs.sndBuf[i+1:]...)
+			gs.onAcked()
+			break
+		}
+		if seqBefore(sn, segment.sn) {
+			break
+		}
+		segment.fastAck++
+	}
+	gs.updateUna()
+}
+
+func (gs *udpSession) updateUna() {
+	if len(gs.sndBuf) > 0 {
+		gs.sndUna = gs.sndBuf[0].sn
+	} else {
+		gs.sndUna = gs.sndNxt
+	}
+}
+
+// onAcked 拥塞窗口增长 慢启动阶段每个确认+1 拥塞避免阶段每窗口+1
+func (gs *udpSession) onAcked() {
+	if gs.conf.NoCongestionWindow {
+		return
+	}
+	if gs.cwnd < gs.ssthresh {
+		gs.cwnd++
+	} else {
+		gs.ackCount++
+		if gs.ackCount >= gs.cwnd {
+			gs.cwnd++
+			gs.ackCount = 0
+		}
+	}
+	if gs.cwnd > gs.conf.SendWindow {
+		gs.cwnd = gs.conf.SendWindow
+	}
+}
+
+// updateRTT RFC6298 重传超时估计
+func (gs *udpSession) updateRTT(rtt time.Duration) {
+	if gs.srtt == 0 {
+		gs.srtt = rtt
+		gs.rttvar = rtt / 2
+	} else {
+		delta := rtt - gs.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		gs.rttvar = (3*gs.rttvar + delta) / 4
+		gs.srtt = (7*gs.srtt + rtt) / 8
+	}
+	variance := 4 * gs.rttvar
+	if variance < gs.conf.Interval {
+		variance = gs.conf.Interval
+	}
+	gs.rto = gs.srtt + variance
+	if gs.rto < gs.conf.MinRTO {
+		gs.rto = gs.conf.MinRTO
+	}
+	if gs.rto > gs.conf.MaxRTO {
+		gs.rto = gs.conf.MaxRTO
+	}
+}
+
+// recvWindowLocked 剩余接收窗口
+func (gs *udpSession) recvWindowLocked() uint16 {
+	remain := gs.conf.RecvWindow - len(gs.rcvBuf) - len(gs.messages)
+	if remain < 0 {
+		remain = 0
+	}
+	return uint16(remain)
+}
+
+func (gs *udpSession) updateLoop() {
+	ticker := time.NewTicker(gs.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gs.closeChan:
+			return
+		case <-ticker.C:
+		case <-gs.flushNotify:
+		}
+		if err := gs.flush(); err != nil {
+			gslog.Warn("[UdpSession] session broken", "conv", gs.conv, "remoteAddr", gs.remoteAddr.String(), "err", err)
+			gs.lock.Lock()
+			gs.closeLocked(err)
+			gs.lock.Unlock()
+			gs.afterClose()
+			return
+		}
+	}
+}
+
+// flush 发送确认、新数据和需要重传的段
+func (gs *udpSession) flush() error {
+	gs.lock.Lock()
+	if gs.closed {
+		gs.lock.Unlock()
+		return nil
+	}
+	now := time.Now()
+	ts := gs.now()
+	wnd := gs.recvWindowLocked()
+	var datagrams [][]byte
+	buf := make([]byte, 0, gs.conf.MTU)
+	appendSegment := func(segment *udpSegment) {
+		if len(buf)+udpHeaderSize+len(segment.data) > gs.conf.MTU {
+			datagrams = append(datagrams, buf)
+			buf = make([]byte, 0, gs.conf.MTU)
+		}
+		buf = segment.encode(buf)
+	}
+
+	// 确认
+	for _, ack := range gs.ackList {
+		appendSegment(&udpSegment{conv: gs.conv, cmd: udpCmdAck, wnd: wnd, ts: ack.ts, sn: ack.sn, una: gs.rcvNxt})
+	}
+	gs.ackList = gs.ackList[:0]
+
+	// 新数据进入发送窗口
+	window := gs.conf.SendWindow
+	if gs.peerWnd < window {
+		window = gs.peerWnd
+	}
+	if !gs.conf.NoCongestionWindow && gs.cwnd < window {
+		window = gs.cwnd
+	}
+	if window < 1 {
+		// 对端窗口为0时仍允许一个段 作为窗口探测
+		window = 1
+	}
+	moved := 0
+	for len(gs.sndQueue) > moved && seqBefore(gs.sndNxt, gs.sndUna+uint32(window)) {
+		segment := gs.sndQueue[moved]
+		segment.conv = gs.conv
+		segment.sn = gs.sndNxt
+		segment.rto = gs.rto
+		gs.sndNxt++
+		gs.sndBuf = append(gs.sndBuf, segment)
+		moved++
+	}
+	if moved > 0 {
+		gs.sndQueue = gs.sndQueue[moved:]
+		notify(gs.writeNotify)
+	}
+
+	// 首次发送、超时重传、快速重传
+	timeoutLost := false
+	fastLost := false
+	for _, segment := range gs.sndBuf {
+		send := false
+		switch {
+		case segment.xmit == 0:
+			send = true
+			segment.rto = gs.rto
+		case !now.Before(segment.resendAt):
+			send = true
+			timeoutLost = true
+			segment.rto += segment.rto / 2
+			if segment.rto > gs.conf.MaxRTO {
+				segment.rto = gs.conf.MaxRTO
+			}
+		case gs.conf.FastResend > 0 && segment.fastAck >= gs.conf.FastResend:
+			send = true
+			fastLost = true
+		}
+		if !send {
+			continue
+		}
+		segment.xmit++
+		if segment.xmit > gs.conf.DeadLink {
+			gs.lock.Unlock()
+			return ErrUdpSessionDead
+		}
+		segment.fastAck = 0
+		segment.resendAt = now.Add(segment.rto)
+		segment.ts = ts
+		segment.wnd = wnd
+		segment.una = gs.rcvNxt
+		appendSegment(segment)
+	}
+
+	// 拥塞控制
+	if !gs.conf.NoCongestionWindow {
+		if fastLost {
+			gs.ssthresh = max(len(gs.sndBuf)/2, 2)
+			gs.cwnd = gs.ssthresh + gs.conf.FastResend
+		}
+		if timeoutLost {
+			gs.ssthresh = max(gs.cwnd/2, 2)
+			gs.cwnd = 1
+		}
+		gs.ackCount = 0
+	}
+	if len(buf) > 0 {
+		datagrams = append(datagrams, buf)
+	}
+	gs.lock.Unlock()
+
+	for _, datagram := range datagrams {
+		if err := gs.send(datagram); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send 发送数据报 按配置模拟丢包
+func (gs *udpSession) send(datagram []byte) error {
+	if gs.conf.LossRate > 0 && mathrand.Float64() < gs.conf.LossRate {
+		return nil
+	}
+	return gs.output(datagram)
+}
+
+// closeLocked 标记关闭 调用方持有锁
+func (gs *udpSession) closeLocked(err error) {
+	if gs.closed {
+		return
+	}
+	gs.closed = true
+	gs.closeErr = err
+	close(gs.closeChan)
+}
+
+func (gs *udpSession) afterClose() {
+	if gs.onClose != nil {
+		gs.onClose()
+	}
+}
+
+// Close 通知对端后关闭会话 关闭帧不保证送达 对端最终由心跳或重传失效发现
+func (gs *udpSession) Close() error {
+	gs.lock.Lock()
+	if gs.closed {
+		gs.lock.Unlock()
+		return ErrUdpSessionClosed
+	}
+	segment := &udpSegment{conv: gs.conv, cmd: udpCmdClose, ts: gs.now(), una: gs.rcvNxt}
+	gs.closeLocked(io.EOF)
+	gs.lock.Unlock()
+
+	_ = gs.output(segment.encode(make([]byte, 0, udpHeaderSize)))
+	gs.afterClose()
+
+	return nil
+}
+
+func (gs *udpSession) LocalAddr() net.Addr {
+	return gs.localAddr
+}
+
+func (gs *udpSession) RemoteAddr() net.Addr {
+	return gs.remoteAddr
+}
+
+func (gs *udpSession) SetDeadline(t time.Time) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+	gs.readDeadline = t
+	gs.writeDeadline = t
+	return nil
+}
+
+func (gs *udpSession) SetReadDeadline(t time.Time) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+	gs.readDeadline = t
+	return nil
+}
+
+func (gs *udpSession) SetWriteDeadline(t time.Time) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+	gs.writeDeadline = t
+	return nil
+}
+
+// UdpListener 可靠UDP监听器 实现 net.Listener 交给 Server.Serve 使用
+// 所有会话共用一个UDP套接字 监听器关闭后等待已建立的会话全部关闭再关闭套接字
+type UdpListener struct {
+	conn       *net.UDPConn
+	conf       UdpConf
+	sessions   map[string]*udpSession // remoteAddr/conv => session
+	acceptChan chan net.Conn
+	closeChan  chan struct{}
+	closed     bool
+	lock       sync.Mutex
+}
+
+// ListenUdp 监听UDP地址
+func ListenUdp(address string, conf *UdpConf) (*UdpListener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	instance := &UdpListener{
+		conn:       conn,
+		conf:       conf.withDefaults(),
+		sessions:   make(map[string]*udpSession),
+		acceptChan: make(chan net.Conn, 128),
+		closeChan:  make(chan struct{}),
+	}
+	go instance.readLoop()
+
+	return instance, nil
+}
+
+func udpSessionKey(addr net.Addr, conv uint32) string {
+	return addr.String() + "/" + string(binary.BigEndian.AppendUint32(nil, conv))
+}
+
+func (gs *UdpListener) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := gs.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			gslog.Warn("[UdpListener] read datagram failed", "err", err)
+			continue
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+		conv := binary.BigEndian.Uint32(buf)
+		key := udpSessionKey(addr, conv)
+
+		gs.lock.Lock()
+		session, exist := gs.sessions[key]
+		if !exist {
+			// 只有可靠数据段可以创建会话
+			if gs.closed || buf[4] != udpCmdPush {
+				gs.lock.Unlock()
+				continue
+			}
+			session = gs.newSession(addr, conv, key)
+			select {
+			case gs.acceptChan <- session:
+			default:
+				gs.lock.Unlock()
+				gslog.Warn("[UdpListener] accept queue full, drop session", "remoteAddr", addr.String())
+				_ = session.Close()
+				continue
+			}
+		}
+		gs.lock.Unlock()
+
+		session.input(buf[:n])
+	}
+}
+
+// newSession 创建会话 调用方持有锁
+func (gs *UdpListener) newSession(addr *net.UDPAddr, conv uint32, key string) *udpSession {
+	session := newUdpSession(conv, gs.conf, gs.conn.LocalAddr(), addr, func(data []byte) error {
+		_, err := gs.conn.WriteToUDP(data, addr)
+		return err
+	})
+	session.onClose = func() {
+		gs.lock.Lock()
+		defer gs.lock.Unlock()
+		if gs.sessions[key] == session {
+			delete(gs.sessions, key)
+		}
+		if gs.closed && len(gs.sessions) == 0 {
+			_ = gs.conn.Close()
+		}
+	}
+	gs.sessions[key] = session
+
+	return session
+}
+
+// Accept 实现 net.Listener
+func (gs *UdpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-gs.acceptChan:
+		return conn, nil
+	case <-gs.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 停止接受新会话
+func (gs *UdpListener) Close() error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.closed {
+		return ErrUdpListenerClosed
+	}
+	gs.closed = true
+	close(gs.closeChan)
+	if len(gs.sessions) == 0 {
+		return gs.conn.Close()
+	}
+
+	return nil
+}
+
+func (gs *UdpListener) Addr() net.Addr {
+	return gs.conn.LocalAddr()
+}
+
+// DialUdp 建立可靠UDP会话 会话关闭时关闭套接字
+func DialUdp(ctx context.Context, address string, conf *UdpConf) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	udpConn := conn.(*net.UDPConn)
+
+	var convBytes [4]byte
+	var conv uint32
+	for conv == 0 {
+		if _, err = rand.Read(convBytes[:]); err != nil {
+			_ = udpConn.Close()
+			return nil, err
+		}
+		conv = binary.BigEndian.Uint32(convBytes[:])
+	}
+	session := newUdpSession(conv, conf.withDefaults(), udpConn.LocalAddr(), udpConn.RemoteAddr(), func(data []byte) error {
+		_, err := udpConn.Write(data)
+		return err
+	})
+	session.onClose = func() {
+		_ = udpConn.Close()
+	}
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := udpConn.Read(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				// 对端端口不可达等错误 交给重传失效处理
+				select {
+				case <-session.closeChan:
+					return
+				case <-time.After(TimeoutWaitInterval):
+				}
+				continue
+			}
+			session.input(buf[:n])
+		}
+	}()
+
+	return session, nil
+}
+
+// NewUdpClient 创建可靠UDP客户端连接层
+func NewUdpClient(ctx context.Context, address string, cfg *BrokerConf, conf *UdpConf, options ...KeeperOption) ConnectionLayer {
+	options = append([]KeeperOption{withConnectionID(cfg.ConnectionID)}, options...)
+
+	return NewTcpConnectionKeeper(ctx, UdpDialBrokerFactory(address, cfg, conf), options...)
+}
+
+// UdpDialBrokerFactory 可靠UDP拨号并握手的连接代理工厂
+func UdpDialBrokerFactory(address string, cfg *BrokerConf, conf *UdpConf) ConnBrokerFactory {
+	return DialBrokerFactory(address, cfg, func(ctx context.Context, _ string, address string) (net.Conn, error) {
+		return DialUdp(ctx, address, conf)
+	})
+}
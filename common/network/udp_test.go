@@ -0,0 +1,286 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newUdpSessionPair 内存中相连的两个会话 filter 返回false的段不送达对端
+func newUdpSessionPair(t *testing.T, conf UdpConf, filter func(segment *udpSegment) bool) (*udpSession, *udpSession) {
+	t.Helper()
+	var a, b *udpSession
+	deliver := func(to **udpSession) func(data []byte) error {
+		return func(data []byte) error {
+			segments, err := decodeUdpSegments(data)
+			if err != nil {
+				return err
+			}
+			datagram := make([]byte, 0, len(data))
+			for _, segment := range segments {
+				if filter == nil || filter(segment) {
+					datagram = segment.encode(datagram)
+				}
+			}
+			if len(datagram) > 0 {
+				(*to).input(datagram)
+			}
+			return nil
+		}
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	a = newUdpSession(1, conf, addr, addr, deliver(&b))
+	b = newUdpSession(1, conf, addr, addr, deliver(&a))
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+
+	return a, b
+}
+
+// readMessage 读取一个完整消息 缓冲区需大于消息长度
+func readMessage(t *testing.T, conn net.Conn, timeout time.Duration) ([]byte, error) {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+func udpTestMessage(i int) []byte {
+	message := []byte(fmt.Sprintf("msg-%04d", i))
+	if i%20 == 0 {
+		// 多个分片的消息
+		message = append(message, bytes.Repeat([]byte{byte(i)}, 10000)...)
+	}
+	return message
+}
+
+func TestUdpReliableDeliveryUnderLoss(t *testing.T) {
+	conf := &UdpConf{LossRate: 0.2, FastResend: 2}
+	listener, err := ListenUdp("127.0.0.1:0", conf)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	client, err := DialUdp(context.Background(), listener.Addr().String(), conf)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	const count = 200
+	written := make(chan error, 1)
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := client.Write(udpTestMessage(i)); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer server.Close()
+	for i := 0; i < count; i++ {
+		message, err := readMessage(t, server, 10*time.Second)
+		if err != nil {
+			t.Fatalf("read message %d: %v", i, err)
+		}
+		if want := udpTestMessage(i); !bytes.Equal(message, want) {
+			t.Fatalf("message %d: got %q..., want %q...", i, message[:min(len(message), 8)], want[:8])
+		}
+	}
+	if err = <-written; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestUdpFastResend(t *testing.T) {
+	conf := DefaultUdpConf
+	conf.NoCongestionWindow = true
+	conf.FastResend = 2
+	// 超时重传远晚于测试期限 只有快速重传能补发丢失的段
+	conf.MinRTO = 3 * time.Second
+
+	var lock sync.Mutex
+	dropped := false
+	resent := 0
+	a, b := newUdpSessionPair(t, conf, func(segment *udpSegment) bool {
+		if segment.cmd != udpCmdPush || segment.sn != 0 {
+			return true
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if !dropped {
+			dropped = true
+			return false
+		}
+		resent++
+		return true
+	})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := a.Write([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		message, err := readMessage(t, b, time.Second)
+		if err != nil {
+			t.Fatalf("read message %d: %v", i, err)
+		}
+		if string(message) != strconv.Itoa(i) {
+			t.Fatalf("message %d: got %q", i, message)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= conf.MinRTO {
+		t.Fatalf("recovered after %s, not faster than rto %s", elapsed, conf.MinRTO)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !dropped || resent == 0 {
+		t.Fatalf("dropped %v resent %d", dropped, resent)
+	}
+}
+
+func TestUdpUnreliableOrdering(t *testing.T) {
+	datagrams := make(chan []byte, 16)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	sender := newUdpSession(1, DefaultUdpConf, addr, addr, func(data []byte) error {
+		datagrams <- append([]byte{}, data...)
+		return nil
+	})
+	receiver := newUdpSession(1, DefaultUdpConf, addr, addr, func([]byte) error { return nil })
+	defer sender.Close()
+	defer receiver.Close()
+
+	captured := make([][]byte, 0, 5)
+	for i := 1; i <= 5; i++ {
+		if err := sender.WriteUnreliable([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("write unreliable: %v", err)
+		}
+		captured = append(captured, <-datagrams)
+	}
+
+	// 乱序到达 晚到的旧消息丢弃
+	for _, i := range []int{1, 3, 2, 5, 4} {
+		receiver.input(captured[i-1])
+	}
+	for _, want := range []string{"1", "3", "5"} {
+		message, err := readMessage(t, receiver, time.Second)
+		if err != nil || string(message) != want {
+			t.Fatalf("read %q %v, want %q", message, err, want)
+		}
+	}
+	if _, err := readMessage(t, receiver, 50*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("stale message delivered: %v", err)
+	}
+}
+
+func TestUdpUnreliableNoRetransmit(t *testing.T) {
+	conf := DefaultUdpConf
+	conf.LossRate = 0.5
+	a, b := newUdpSessionPair(t, conf, nil)
+
+	const count = 200
+	for i := 0; i < count; i++ {
+		if err := a.WriteUnreliable([]byte(fmt.Sprintf("snap-%04d", i))); err != nil {
+			t.Fatalf("write unreliable: %v", err)
+		}
+	}
+
+	received := 0
+	last := ""
+	for {
+		// 等待足够多个重传周期 丢失的消息不会补发
+		message, err := readMessage(t, b, 200*time.Millisecond)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(message) <= last {
+			t.Fatalf("out of order %q after %q", message, last)
+		}
+		last = string(message)
+		received++
+	}
+	if received == 0 || received == count {
+		t.Fatalf("received %d of %d with loss rate %.1f", received, count, conf.LossRate)
+	}
+}
+
+func TestUdpServerRemovesClientClosedConnection(t *testing.T) {
+	listener, err := ListenUdp("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	opened := make(chan string, 2)
+	closed := make(chan string, 2)
+	server := NewServer(listener.Addr().String(), &BrokerConf{ByteOrder: binary.BigEndian},
+		// 单IP只允许一个连接 关闭后未释放计数时无法再次连接
+		WithAcceptFilters(NewIPConnectionLimiter(1)),
+		WithOnConnectionOpen(func(conn ConnectionLayer) {
+			opened <- conn.ConnectionID()
+		}),
+		WithOnConnectionClose(func(connectionID string) {
+			closed <- connectionID
+		}),
+	)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	for _, connectionID := range []string{"c1", "c2"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		client := NewUdpClient(ctx, listener.Addr().String(), &BrokerConf{ConnectionID: connectionID, ByteOrder: binary.BigEndian}, nil)
+		select {
+		case id := <-opened:
+			if id != connectionID {
+				t.Fatalf("opened %q, want %q", id, connectionID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not accepted", connectionID)
+		}
+
+		// 客户端关闭 服务端会话由对端的关闭帧关闭
+		_ = client.Close()
+		cancel()
+		select {
+		case id := <-closed:
+			if id != connectionID {
+				t.Fatalf("closed %q, want %q", id, connectionID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server kept %s after the client closed", connectionID)
+		}
+		if count := server.ConnectionCount(); count != 0 {
+			t.Fatalf("%d connections left", count)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"GameServer/gslog"
+)
+
+// WebSocket 传输
+// wsConn 将 WebSocket 连接包装为 net.Conn 每次 Write 发送一个二进制消息
+// 连接代理每次写入一个完整的 ControlPacket 因此每个包对应一个二进制消息
+// 握手、心跳、关闭仍由 ConnectBroker/AcceptBroker 和连接层完成 与TCP完全一致
+// 服务端通过 WebSocketAcceptor 挂载到 http 服务 并作为 net.Listener 交给 Server.Serve
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsFinalBit       = 0x80
+	wsMaskBit        = 0x80
+	wsCloseNormal    = 1000
+	wsAcceptGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsCloseFlushTime = time.Second
+)
+
+var (
+	// DefaultWebSocketMaxMessageSize 默认单个消息最大长度
+	DefaultWebSocketMaxMessageSize = 16 * 1024 * 1024
+
+	ErrWebSocketProtocol        = errors.New("websocket protocol error")
+	ErrWebSocketMessageTooLarge = errors.New("websocket message too large")
+	ErrWebSocketHandshake       = errors.New("websocket handshake failed")
+)
+
+// wsConn WebSocket 连接
+type wsConn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	isClient       bool   // 客户端发送的帧需要掩码
+	readBuf        []byte // 当前消息未读部分
+	maxMessageSize int
+	writeLock      sync.Mutex
+	closeOnce      sync.Once
+}
+
+func newWsConn(conn net.Conn, reader *bufio.Reader, isClient bool) *wsConn {
+	if reader == nil {
+		reader = bufio.NewReader(conn)
+	}
+	return &wsConn{
+		conn:           conn,
+		reader:         reader,
+		isClient:       isClient,
+		maxMessageSize: DefaultWebSocketMaxMessageSize,
+	}
+}
+
+func (gs *wsConn) Read(p []byte) (int, error) {
+	for len(gs.readBuf) == 0 {
+		message, err := gs.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		gs.readBuf = message
+	}
+	n := copy(p, gs.readBuf)
+	gs.readBuf = gs.readBuf[n:]
+
+	return n, nil
+}
+
+func (gs *wsConn) Write(p []byte) (int, error) {
+	if err := gs.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close 发送关闭帧后关闭底层连接
+func (gs *wsConn) Close() error {
+	err := net.ErrClosed
+	gs.closeOnce.Do(func() {
+		payload := make([]byte, 2)
+		binary.BigEndian.PutUint16(payload, wsCloseNormal)
+		_ = gs.conn.SetWriteDeadline(time.Now().Add(wsCloseFlushTime))
+		_ = gs.writeFrame(wsOpClose, payload)
+		err = gs.conn.Close()
+	})
+
+	return err
+}
+
+func (gs *wsConn) LocalAddr() net.Addr {
+	return gs.conn.LocalAddr()
+}
+
+func (gs *wsConn) RemoteAddr() net.Addr {
+	return gs.conn.RemoteAddr()
+}
+
+func (gs *wsConn) SetDeadline(t time.Time) error {
+	return gs.conn.SetDeadline(t)
+}
+
+func (gs *wsConn) SetReadDeadline(t time.Time) error {
+	return gs.conn.SetReadDeadline(t)
+}
+
+func (gs *wsConn) SetWriteDeadline(t time.Time) error {
+	return gs.conn.SetWriteDeadline(t)
+}
+
+// readMessage 读取一个完整的二进制消息 期间处理控制帧
+func (gs *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := gs.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err = gs.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			// 回复关闭帧 对端状态码原样返回
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = gs.writeFrame(wsOpClose, payload)
+			return nil, io.EOF
+		case wsOpBinary:
+			if started {
+				return nil, ErrWebSocketProtocol
+			}
+			started = true
+		case wsOpContinuation:
+			if !started {
+				return nil, ErrWebSocketProtocol
+			}
+		default:
+			// 只接受二进制消息
+			return nil, ErrWebSocketProtocol
+		}
+		if len(message)+len(payload) > gs.maxMessageSize {
+			return nil, ErrWebSocketMessageTooLarge
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame 读取一帧
+func (gs *wsConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(gs.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&wsFinalBit != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&wsMaskBit != 0
+	if header[0]&0x70 != 0 || masked == gs.isClient {
+		// 未协商扩展 RSV位必须为0 客户端发送的帧必须掩码 服务端发送的帧不能掩码
+		return false, 0, nil, ErrWebSocketProtocol
+	}
+
+	length := uint64(header[1] &^ wsMaskBit)
+	switch length {
+	case 126:
+		extend := make([]byte, 2)
+		if _, err := io.ReadFull(gs.reader, extend); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extend))
+	case 127:
+		extend := make([]byte, 8)
+		if _, err := io.ReadFull(gs.reader, extend); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extend)
+	}
+	if opcode >= wsOpClose && (length > 125 || !fin) {
+		return false, 0, nil, ErrWebSocketProtocol
+	}
+	if length > uint64(gs.maxMessageSize) {
+		return false, 0, nil, ErrWebSocketMessageTooLarge
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(gs.reader, maskKey[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(gs.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= maskKey[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame 写入一个完整帧
+func (gs *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, wsFinalBit|opcode)
+
+	var maskBit byte
+	if gs.isClient {
+		maskBit = wsMaskBit
+	}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 65535:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	if gs.isClient {
+		var maskKey [4]byte
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return err
+		}
+		frame = append(frame, maskKey[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := start; i < len(frame); i++ {
+			frame[i] ^= maskKey[(i-start)%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	_, err := gs.conn.Write(frame)
+
+	return err
+}
+
+// wsAcceptKey 计算 Sec-WebSocket-Accept
+func wsAcceptKey(key string) string {
+	hash := sha1.New()
+	hash.Write([]byte(key))
+	hash.Write([]byte(wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// headerContains 逗号分隔的请求头中是否包含指定值 忽略大小写
+func headerContains(header http.Header, name string, value string) bool {
+	for _, line := range header.Values(name) {
+		for _, token := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAddr WebSocket 接收器地址
+type wsAddr string
+
+func (gs wsAddr) Network() string {
+	return "websocket"
+}
+
+func (gs wsAddr) String() string {
+	return string(gs)
+}
+
+// WebSocketAcceptor WebSocket 接收器
+// 作为 http.Handler 完成升级 作为 net.Listener 交给 Server.Serve 接受连接
+type WebSocketAcceptor struct {
+	address     string
+	CheckOrigin func(r *http.Request) bool // 校验来源 nil时不校验
+	acceptChan  chan net.Conn
+	closeChan   chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewWebSocketAcceptor 创建 WebSocket 接收器 address 仅用于展示
+func NewWebSocketAcceptor(address string) *WebSocketAcceptor {
+	return &WebSocketAcceptor{
+		address:    address,
+		acceptChan: make(chan net.Conn),
+		closeChan:  make(chan struct{}),
+	}
+}
+
+func (gs *WebSocketAcceptor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+	if gs.CheckOrigin != nil && !gs.CheckOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		gslog.Warn("[WebSocketAcceptor] hijack failed", "remoteAddr", r.RemoteAddr, "err", err)
+		return
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	if _, err = rw.WriteString(response); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		gslog.Warn("[WebSocketAcceptor] write upgrade response failed", "remoteAddr", r.RemoteAddr, "err", err)
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case gs.acceptChan <- newWsConn(conn, rw.Reader, false):
+	case <-gs.closeChan:
+		_ = conn.Close()
+	}
+}
+
+// Accept 实现 net.Listener
+func (gs *WebSocketAcceptor) Accept() (net.Conn, error) {
+	select {
+	case conn := <-gs.acceptChan:
+		return conn, nil
+	case <-gs.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 停止接受连接 不影响已建立的连接
+func (gs *WebSocketAcceptor) Close() error {
+	gs.closeOnce.Do(func() {
+		close(gs.closeChan)
+	})
+	return nil
+}
+
+func (gs *WebSocketAcceptor) Addr() net.Addr {
+	return wsAddr(gs.address)
+}
+
+// DialWebSocket 连接 WebSocket 服务端 支持 ws/wss
+// tlsConfig 仅在 wss 时使用 可为nil
+func DialWebSocket(ctx context.Context, rawURL string, tlsConfig *tls.Config) (net.Conn, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := target.Host
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: DefaultDialTimeout}
+	switch target.Scheme {
+	case "ws":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "80")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "wss":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "443")
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrWebSocketHandshake, target.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := wsClientHandshake(ctx, conn, target)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// wsClientHandshake 客户端升级握手
+func wsClientHandshake(ctx context.Context, conn net.Conn, target *url.URL) (*wsConn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(DefaultDialTimeout))
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request := "GET " + target.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + target.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: unexpected status %s", ErrWebSocketHandshake, response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return nil, fmt.Errorf("%w: bad accept key", ErrWebSocketHandshake)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return newWsConn(conn, reader, true), nil
+}
+
+// NewWebSocketClient 创建 WebSocket 客户端连接层
+func NewWebSocketClient(ctx context.Context, rawURL string, cfg *BrokerConf, tlsConfig *tls.Config, options ...KeeperOption) ConnectionLayer {
+	options = append([]KeeperOption{withConnectionID(cfg.ConnectionID)}, options...)
+
+	return NewTcpConnectionKeeper(ctx, WebSocketDialBrokerFactory(rawURL, cfg, tlsConfig), options...)
+}
+
+// WebSocketDialBrokerFactory WebSocket 拨号并握手的连接代理工厂
+func WebSocketDialBrokerFactory(rawURL string, cfg *BrokerConf, tlsConfig *tls.Config) ConnBrokerFactory {
+	return DialBrokerFactory(rawURL, cfg, func(ctx context.Context, _ string, address string) (net.Conn, error) {
+		return DialWebSocket(ctx, address, tlsConfig)
+	})
+}
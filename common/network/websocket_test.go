@@ -0,0 +1,221 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startWebSocketServer 以 httptest 挂载 WebSocket 接收器 返回服务端和 ws 地址
+func startWebSocketServer(t *testing.T, options ...ServerOption) (*Server, string) {
+	t.Helper()
+	acceptor := NewWebSocketAcceptor("websocket")
+	httpServer := httptest.NewServer(acceptor)
+	server := NewServer(acceptor.Addr().String(), &BrokerConf{ByteOrder: binary.BigEndian}, options...)
+	go func() {
+		_ = server.Serve(acceptor)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+		httpServer.Close()
+	})
+
+	return server, "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+}
+
+func TestWebSocketServerRoundTrip(t *testing.T) {
+	closed := make(chan string, 1)
+	server, address := startWebSocketServer(t,
+		WithOnConnectionOpen(func(conn ConnectionLayer) {
+			// 原样回显 PUBLISH
+			go func() {
+				for packet := range conn.Read() {
+					if _, ok := packet.(*PublishPacket); ok {
+						_ = conn.WritePacket(context.Background(), packet)
+					}
+				}
+			}()
+		}),
+		WithOnConnectionClose(func(connectionID string) {
+			closed <- connectionID
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewWebSocketClient(ctx, address, &BrokerConf{ConnectionID: "c1", ByteOrder: binary.BigEndian}, nil)
+	defer client.Close()
+
+	// 覆盖 7位、16位和64位三种负载长度
+	for i, size := range []int{16, 1024, 100000} {
+		payload := bytes.Repeat([]byte{byte(i + 1)}, size)
+		if err := client.WritePacket(ctx, newPublish(0, string(payload))); err != nil {
+			t.Fatalf("write packet: %v", err)
+		}
+		select {
+		case packet := <-client.Read():
+			if publish, ok := packet.(*PublishPacket); !ok || !bytes.Equal(publish.Payload, payload) {
+				t.Fatalf("received %v, want payload of %d bytes", packet, size)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("echo of %d bytes not received", size)
+		}
+	}
+	if _, ok := server.Connection("c1"); !ok {
+		t.Fatalf("connection not registered")
+	}
+
+	_ = client.Close()
+	select {
+	case connectionID := <-closed:
+		if connectionID != "c1" {
+			t.Fatalf("closed %q, want c1", connectionID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not notice the close")
+	}
+	if count := server.ConnectionCount(); count != 0 {
+		t.Fatalf("%d connections left", count)
+	}
+}
+
+func TestWebSocketUpgradeRejected(t *testing.T) {
+	upgrade := func(r *http.Request) {
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	}
+	tests := []struct {
+		name   string
+		method string
+		modify func(r *http.Request)
+		want   int
+	}{
+		{name: "post", method: http.MethodPost, modify: upgrade, want: http.StatusMethodNotAllowed},
+		{name: "no upgrade", method: http.MethodGet, modify: func(*http.Request) {}, want: http.StatusBadRequest},
+		{name: "old version", method: http.MethodGet, modify: func(r *http.Request) {
+			upgrade(r)
+			r.Header.Set("Sec-WebSocket-Version", "8")
+		}, want: http.StatusUpgradeRequired},
+		{name: "missing key", method: http.MethodGet, modify: func(r *http.Request) {
+			upgrade(r)
+			r.Header.Del("Sec-WebSocket-Key")
+		}, want: http.StatusBadRequest},
+		{name: "forbidden origin", method: http.MethodGet, modify: func(r *http.Request) {
+			upgrade(r)
+			r.Header.Set("Origin", "http://evil.example")
+		}, want: http.StatusForbidden},
+	}
+	acceptor := NewWebSocketAcceptor("websocket")
+	acceptor.CheckOrigin = func(r *http.Request) bool {
+		return r.Header.Get("Origin") == ""
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/ws", nil)
+			tt.modify(request)
+			recorder := httptest.NewRecorder()
+			acceptor.ServeHTTP(recorder, request)
+			if recorder.Code != tt.want {
+				t.Fatalf("status %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
+
+// wsServerFrame 服务端发出的不带掩码的帧
+func wsServerFrame(fin bool, opcode byte, payload string) []byte {
+	first := opcode
+	if fin {
+		first |= wsFinalBit
+	}
+	return append([]byte{first, byte(len(payload))}, payload...)
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  [][]byte
+		want    string
+		wantErr error
+	}{
+		{
+			name: "fragmented with ping",
+			frames: [][]byte{
+				wsServerFrame(false, wsOpBinary, "ab"),
+				wsServerFrame(true, wsOpPing, "p"),
+				wsServerFrame(true, wsOpContinuation, "cd"),
+			},
+			want: "abcd",
+		},
+		{name: "text message", frames: [][]byte{wsServerFrame(true, wsOpText, "text")}, wantErr: ErrWebSocketProtocol},
+		{name: "orphan continuation", frames: [][]byte{wsServerFrame(true, wsOpContinuation, "cd")}, wantErr: ErrWebSocketProtocol},
+		{name: "fragmented ping", frames: [][]byte{wsServerFrame(false, wsOpPing, "p")}, wantErr: ErrWebSocketProtocol},
+		{
+			name: "masked server frame",
+			frames: [][]byte{
+				{wsFinalBit | wsOpBinary, wsMaskBit | 1, 0, 0, 0, 0, 'x'},
+			},
+			wantErr: ErrWebSocketProtocol,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSide, serverSide := net.Pipe()
+			client := newWsConn(clientSide, nil, true)
+			server := newWsConn(serverSide, nil, false)
+			defer clientSide.Close()
+			defer serverSide.Close()
+
+			// 服务端写出原始帧 并读取客户端回复的控制帧
+			replies := make(chan byte, 4)
+			go func() {
+				for {
+					_, opcode, _, err := server.readFrame()
+					if err != nil {
+						return
+					}
+					replies <- opcode
+				}
+			}()
+			go func() {
+				for _, frame := range tt.frames {
+					if _, err := serverSide.Write(frame); err != nil {
+						return
+					}
+				}
+			}()
+
+			buf := make([]byte, 64)
+			_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := client.Read(buf)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("read: %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(buf[:n]) != tt.want {
+				t.Fatalf("read %q %v, want %q", buf[:n], err, tt.want)
+			}
+			select {
+			case opcode := <-replies:
+				if opcode != wsOpPong {
+					t.Fatalf("replied opcode %#x, want pong", opcode)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("ping not answered")
+			}
+		})
+	}
+}
@@ -3,6 +3,6 @@ module GameServer
 go 1.21
 
 require (
-	github.com/golang/protobuf v1.5.4 // indirect
-	google.golang.org/protobuf v1.34.2 // indirect
+	github.com/golang/protobuf v1.5.4
+	google.golang.org/protobuf v1.34.2
 )
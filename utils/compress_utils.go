@@ -1,11 +1,18 @@
 package utils
 
 import (
+	"bytes"
 	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"io"
 	"os"
 )
 
+var (
+	ErrDecompressedTooLarge = errors.New("decompressed data too large")
+)
+
 // CompressFileByGzip 压缩文件为gzip
 func CompressFileByGzip(src, dst string) (err error) {
 	defer func() {
@@ -59,3 +66,69 @@ func CompressFileByGzip(src, dst string) (err error) {
 
 	return nil
 }
+
+// GzipCompress gzip压缩字节数组
+func GzipCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// GzipDecompress gzip解压字节数组 解压后超过maxSize返回错误 maxSize<=0 不限制
+func GzipDecompress(data []byte, maxSize int) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return readAllLimit(gz, maxSize)
+}
+
+// ZlibCompress zlib压缩字节数组
+func ZlibCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ZlibDecompress zlib解压字节数组 解压后超过maxSize返回错误 maxSize<=0 不限制
+func ZlibDecompress(data []byte, maxSize int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return readAllLimit(zr, maxSize)
+}
+
+// readAllLimit 读取全部数据 超过maxSize返回错误 避免解压炸弹
+func readAllLimit(r io.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return data, nil
+}
@@ -1,15 +1,20 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
 )
 
 var (
-	ErrInvalidBuffer = errors.New("invalid bytes buffer")
+	ErrInvalidBuffer       = errors.New("invalid bytes buffer")
+	ErrVariableIntOverflow = errors.New("variable int overflow")
 )
 
+// readerPreallocSize 长度前缀不超过该值时一次性分配 超过时按实际读到的数据增长
+const readerPreallocSize = 64 * 1024
+
 // EncodeVariableInt 变长int/int64编码 对于小于128的值采用单字节编码
 // 高出的值采取 低7位编码有效数据,可以编码128个数值; 最高位延续位指示后续是否还有剩余字节
 func EncodeVariableInt(num int64) []byte {
@@ -31,27 +36,26 @@ func EncodeVariableInt(num int64) []byte {
 }
 
 // DecodeReaderVariableInt  变长int解码
+// 最多4字节 第4字节仍有延续位时返回 ErrVariableIntOverflow
 func DecodeReaderVariableInt(r io.Reader) (int, error) {
 	var num uint32
 	var shift uint32
-	var err error
 	var bs = make([]byte, 1)
 
 	for shift < 28 {
-		_, err = r.Read(bs)
-		if err != nil {
+		if _, err := io.ReadFull(r, bs); err != nil {
 			return 0, err
 		}
 		digit := bs[0]
 
 		num |= uint32(digit&0x7F) << shift
 		if digit&0x80 == 0 {
-			break
+			return int(num), nil
 		}
 		shift += 7
 	}
 
-	return int(num), err
+	return 0, ErrVariableIntOverflow
 }
 
 // DecodeVariableInt  变长int解码
@@ -147,14 +151,31 @@ func DecodeBytes(data []byte, order binary.ByteOrder) ([]byte, error) {
 
 func DecodeReaderBytes(r io.Reader, order binary.ByteOrder) ([]byte, error) {
 	num := make([]byte, 4)
-	_, err := r.Read(num)
+	_, err := io.ReadFull(r, num)
 	if err != nil {
 		return nil, err
 	}
 	length := order.Uint32(num)
 
+	// 长度前缀来自外部数据 不可直接按其分配内存
+	// 可获知剩余长度的读取器提前校验 其他读取器按实际读到的数据增长
+	if sized, ok := r.(interface{ Len() int }); ok && int64(length) > int64(sized.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if length > readerPreallocSize {
+		var field bytes.Buffer
+		if _, err = io.CopyN(&field, r, int64(length)); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return field.Bytes(), nil
+	}
+
+	// 使用ReadFull 空字段在数据末尾时不会返回EOF
 	field := make([]byte, length)
-	_, err = r.Read(field)
+	_, err = io.ReadFull(r, field)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// 轻量LZ77压缩 格式参考LZ4块格式 以压缩速度优先
+// 数据 = 原始长度(4字节小端) + 若干序列
+// 序列 = token + [字面量扩展长度] + 字面量 + 偏移(2字节小端) + [匹配扩展长度]
+// token 高4位为字面量长度 低4位为匹配长度-4 值为15时后续字节继续累加 遇到非255字节结束
+// 最后一个序列只有字面量 没有偏移和匹配
+
+const (
+	lzMinMatch  = 4
+	lzHashLog   = 12
+	lzMaxOffset = 65535
+)
+
+var (
+	ErrInvalidLZData = errors.New("invalid lz data")
+)
+
+// LZCompress 压缩
+func LZCompress(src []byte) []byte {
+	dst := make([]byte, 4, 4+len(src)+len(src)/255+16)
+	binary.LittleEndian.PutUint32(dst, uint32(len(src)))
+	if len(src) == 0 {
+		return dst
+	}
+
+	var table [1 << lzHashLog]int32 // 哈希 => 位置+1
+	anchor := 0
+	for i := 0; i+lzMinMatch <= len(src); {
+		sequence := binary.LittleEndian.Uint32(src[i:])
+		hash := (sequence * 2654435761) >> (32 - lzHashLog)
+		ref := int(table[hash]) - 1
+		table[hash] = int32(i + 1)
+		if ref < 0 || i-ref > lzMaxOffset || binary.LittleEndian.Uint32(src[ref:]) != sequence {
+			i++
+			continue
+		}
+		// 向后扩展匹配
+		matchLength := lzMinMatch
+		for i+matchLength < len(src) && src[ref+matchLength] == src[i+matchLength] {
+			matchLength++
+		}
+		dst = lzAppendSequence(dst, src[anchor:i], i-ref, matchLength)
+		i += matchLength
+		anchor = i
+	}
+
+	return lzAppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// lzAppendSequence 写入一个序列 matchLength为0时为最后一个序列
+func lzAppendSequence(dst []byte, literals []byte, offset int, matchLength int) []byte {
+	literalLength := len(literals)
+	var token byte
+	if literalLength >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(literalLength) << 4
+	}
+	if matchLength > 0 {
+		if matchLength-lzMinMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(matchLength - lzMinMatch)
+		}
+	}
+	dst = append(dst, token)
+	if literalLength >= 15 {
+		dst = lzAppendLength(dst, literalLength-15)
+	}
+	dst = append(dst, literals...)
+	if matchLength == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if matchLength-lzMinMatch >= 15 {
+		dst = lzAppendLength(dst, matchLength-lzMinMatch-15)
+	}
+
+	return dst
+}
+
+func lzAppendLength(dst []byte, length int) []byte {
+	for length >= 255 {
+		dst = append(dst, 255)
+		length -= 255
+	}
+	return append(dst, byte(length))
+}
+
+// LZDecompress 解压 原始长度超过maxSize返回错误 maxSize<=0 不限制
+func LZDecompress(src []byte, maxSize int) ([]byte, error) {
+	if len(src) < 4 {
+		return nil, ErrInvalidLZData
+	}
+	size := int(binary.LittleEndian.Uint32(src))
+	if maxSize > 0 && size > maxSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	dst := make([]byte, 0, size)
+
+	pos := 4
+	for pos < len(src) {
+		token := src[pos]
+		pos++
+
+		literalLength := int(token >> 4)
+		if literalLength == 15 {
+			extend, n, err := lzReadLength(src[pos:])
+			if err != nil {
+				return nil, err
+			}
+			literalLength += extend
+			pos += n
+		}
+		if literalLength > len(src)-pos || literalLength > size-len(dst) {
+			return nil, ErrInvalidLZData
+		}
+		dst = append(dst, src[pos:pos+literalLength]...)
+		pos += literalLength
+		if pos == len(src) {
+			// 最后一个序列
+			break
+		}
+
+		if len(src)-pos < 2 {
+			return nil, ErrInvalidLZData
+		}
+		offset := int(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, ErrInvalidLZData
+		}
+		matchLength := int(token&15) + lzMinMatch
+		if token&15 == 15 {
+			extend, n, err := lzReadLength(src[pos:])
+			if err != nil {
+				return nil, err
+			}
+			matchLength += extend
+			pos += n
+		}
+		if matchLength > size-len(dst) {
+			return nil, ErrInvalidLZData
+		}
+		// 匹配可能与输出重叠 逐字节拷贝
+		start := len(dst) - offset
+		for i := 0; i < matchLength; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if len(dst) != size {
+		return nil, ErrInvalidLZData
+	}
+
+	return dst, nil
+}
+
+func lzReadLength(src []byte) (int, int, error) {
+	length := 0
+	for i, b := range src {
+		length += int(b)
+		if b != 255 {
+			return length, i + 1, nil
+		}
+	}
+	return 0, 0, ErrInvalidLZData
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLZRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 4096)
+	r.Read(random)
+	// 超过最大偏移的重复 只能部分匹配
+	farRepeat := append(append(append([]byte{}, random...), bytes.Repeat([]byte{'x'}, 70000)...), random...)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "single byte", data: []byte("a")},
+		{name: "shorter than min match", data: []byte("abc")},
+		{name: "text", data: []byte(strings.Repeat("hello world ", 1000))},
+		{name: "zeros", data: make([]byte, 100000)},
+		{name: "overlapping match", data: []byte("abababababababababababababab")},
+		{name: "random", data: random},
+		{name: "far repeat", data: farRepeat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := LZCompress(tt.data)
+			got, err := LZDecompress(compressed, len(tt.data))
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestLZRoundTripRandomAlphabet(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		data := make([]byte, r.Intn(5000))
+		for j := range data {
+			data[j] = byte(r.Intn(1 + i%8))
+		}
+		got, err := LZDecompress(LZCompress(data), 0)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("case %d: round trip of %d bytes failed: %v", i, len(data), err)
+		}
+	}
+}
+
+func TestLZDecompressLimit(t *testing.T) {
+	compressed := LZCompress(make([]byte, 1024))
+	if _, err := LZDecompress(compressed, 1023); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Fatalf("decompress: %v, want %v", err, ErrDecompressedTooLarge)
+	}
+	if _, err := LZDecompress(compressed, 1024); err != nil {
+		t.Fatalf("decompress at limit: %v", err)
+	}
+}
+
+func TestLZDecompressCorrupt(t *testing.T) {
+	valid := LZCompress([]byte(strings.Repeat("corrupted payload ", 50)))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "too short", data: []byte{1, 0}},
+		{name: "truncated", data: valid[:len(valid)-3]},
+		{name: "length mismatch", data: append([]byte{0xFF, 0, 0, 0}, valid[4:]...)},
+		{name: "offset before start", data: []byte{4, 0, 0, 0, 0x10, 'a', 9, 0}},
+		{name: "zero offset", data: []byte{4, 0, 0, 0, 0x10, 'a', 0, 0}},
+		{name: "unterminated length", data: []byte{0, 1, 0, 0, 0xF0, 255, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LZDecompress(tt.data, 1<<20); !errors.Is(err, ErrInvalidLZData) {
+				t.Fatalf("decompress: %v, want %v", err, ErrInvalidLZData)
+			}
+		})
+	}
+
+	// 随机翻转不应导致崩溃
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 1000; i++ {
+		data := append([]byte{}, valid...)
+		data[4+r.Intn(len(data)-4)] ^= byte(1 + r.Intn(255))
+		_, _ = LZDecompress(data, 1<<20)
+	}
+}